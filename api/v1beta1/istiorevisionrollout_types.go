@@ -0,0 +1,141 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelIstioRevisionRollout marks the LNamespaces moved by an
+// IstioRevisionRollout with its name.
+const LabelIstioRevisionRollout = "gial.lblw.dev/istio-revision-rollout"
+
+// IstioRevisionRolloutSpec defines the desired state of IstioRevisionRollout
+type IstioRevisionRolloutSpec struct {
+	// From is the istio revision that LNamespaces are moved away from. It
+	// cannot change once the rollout has started.
+	From string `json:"from"`
+	// To is the istio revision that LNamespaces are moved to. It must be
+	// installed, differ from From, and cannot change once the rollout has started.
+	To string `json:"to"`
+
+	// Selector restricts the rollout to LNamespaces with matching labels.
+	// All LNamespaces on the From revision are selected when empty. It cannot
+	// change once the rollout has started.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// BatchSize is the number of LNamespaces moved at a time. Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// Paused stops the rollout from starting new batches. A batch in progress
+	// is still completed or rolled back, so the rollout pauses between batches.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// RestartWorkloads triggers a rollout restart of the Deployments,
+	// StatefulSets and DaemonSets of each batch, so that their sidecars are
+	// re-injected by the new revision.
+	// +optional
+	RestartWorkloads bool `json:"restartWorkloads,omitempty"`
+
+	// ProgressDeadlineSeconds is how long the workloads of a batch have to
+	// become available before the rollout is rolled back. Defaults to 600.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds int32 `json:"progressDeadlineSeconds,omitempty"`
+}
+
+// RolloutPhase is the lifecycle phase of an IstioRevisionRollout.
+type RolloutPhase string
+
+const (
+	// RolloutProgressing means that batches are being moved to the new revision.
+	RolloutProgressing RolloutPhase = "Progressing"
+	// RolloutPaused means that no new batch is started until the rollout is resumed.
+	RolloutPaused RolloutPhase = "Paused"
+	// RolloutCompleted means that every selected LNamespace is on the new revision.
+	RolloutCompleted RolloutPhase = "Completed"
+	// RolloutRollingBack means that a batch failed its health gate and the
+	// LNamespaces moved by the rollout are being returned to the previous revision.
+	RolloutRollingBack RolloutPhase = "RollingBack"
+	// RolloutRolledBack means that every LNamespace moved by the rollout was
+	// returned to the previous revision.
+	RolloutRolledBack RolloutPhase = "RolledBack"
+	// RolloutFailed means that the rollout cannot start, e.g. because the new revision is not installed.
+	RolloutFailed RolloutPhase = "Failed"
+)
+
+// IstioRevisionRolloutStatus defines the observed state of IstioRevisionRollout
+type IstioRevisionRolloutStatus struct {
+	// Phase is the lifecycle phase of the rollout.
+	// +optional
+	Phase RolloutPhase `json:"phase,omitempty"`
+	// Message describes the reason for the current phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Total is the number of selected LNamespaces when the rollout started.
+	// +optional
+	Total int32 `json:"total,omitempty"`
+	// Updated is the number of LNamespaces that passed the health gate on the new revision.
+	// +optional
+	Updated int32 `json:"updated,omitempty"`
+
+	// CurrentBatch holds the names of the LNamespaces of the batch in progress.
+	// +optional
+	CurrentBatch []string `json:"currentBatch,omitempty"`
+	// BatchStartTime is when the batch in progress was moved to the new revision.
+	// +optional
+	BatchStartTime *metav1.Time `json:"batchStartTime,omitempty"`
+	// WorkloadsRestarted is true once the workloads of the batch in progress were restarted.
+	// +optional
+	WorkloadsRestarted bool `json:"workloadsRestarted,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=irr
+// +kubebuilder:printcolumn:name="From",type=string,JSONPath=`.spec.from`
+// +kubebuilder:printcolumn:name="To",type=string,JSONPath=`.spec.to`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.updated`
+// +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.total`
+
+// IstioRevisionRollout moves LNamespaces from one istio revision to another in batches
+type IstioRevisionRollout struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IstioRevisionRolloutSpec   `json:"spec"`
+	Status IstioRevisionRolloutStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IstioRevisionRolloutList contains a list of IstioRevisionRollout
+type IstioRevisionRolloutList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IstioRevisionRollout `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IstioRevisionRollout{}, &IstioRevisionRolloutList{})
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package v1beta1
 
 import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -46,15 +49,466 @@ type LNamespaceSpec struct {
 
 	// Billing holds billing information.
 	Billing map[string]string `json:"billing,omitempty"`
+
+	// PodSecurity holds the Pod Security Admission levels applied to the
+	// namespace. Levels that are not set default to the cluster default.
+	// +optional
+	PodSecurity *PodSecurity `json:"podSecurity,omitempty"`
+
+	// Size selects the platform-defined ResourceQuota and LimitRange
+	// templates applied to the namespace. Use custom together with
+	// CustomSize to define them inline, or none to apply no quota. Sizes
+	// larger than the cluster default require platform admin approval, and
+	// only platform admins can set CustomSize or the none size.
+	// +optional
+	Size NamespaceSize `json:"size,omitempty"`
+
+	// CustomSize holds the quota and limits of a namespace whose Size is custom.
+	// +optional
+	CustomSize *CustomSize `json:"customSize,omitempty"`
+
+	// Network configures the NetworkPolicies managed in the namespace.
+	// +optional
+	Network *Network `json:"network,omitempty"`
+
+	// Mesh configures how the namespace joins the istio mesh.
+	// +optional
+	Mesh *Mesh `json:"mesh,omitempty"`
+
+	// Hosts claims hostnames such as shop.loblaw.ca, or wildcard domains such
+	// as *.shop.loblaw.ca, for the VirtualServices and Gateways of the
+	// namespace. A host can only be claimed by one LNamespace. Wildcard
+	// domains that are not below a self-service domain of the cluster can
+	// only be claimed by platform admins.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Environment classifies the namespace. Developers of prod namespaces get
+	// a restricted role and sudoers need an open sudo session. Defaults to dev.
+	// +optional
+	Environment Environment `json:"environment,omitempty"`
+
+	// SudoSessions are the time-bound sudo sessions opened for sudoers of a
+	// prod namespace. Sessions are ignored in other environments, where every
+	// sudoer may always sudo.
+	// +optional
+	SudoSessions []SudoSession `json:"sudoSessions,omitempty"`
+
+	// Suspended hibernates the namespace. Deployments and StatefulSets are
+	// scaled to zero, CronJobs are suspended and developers lose write
+	// access until the namespace is resumed.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// ExpiresAt is the time at which the LNamespace and everything in it is
+	// deleted. Managers can extend it. Cannot be set together with TTL.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// TTL is how long after its creation the LNamespace is deleted, e.g. 72h.
+	// Cannot be set together with ExpiresAt.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// DeletionProtection rejects the deletion of the LNamespace, which would
+	// delete the namespace and everything in it. Only sudoers and platform
+	// admins can remove the protection. Defaults to true in prod.
+	// +optional
+	DeletionProtection *bool `json:"deletionProtection,omitempty"`
+
+	// DeletionPolicy selects what happens to the namespace when the
+	// LNamespace is deleted. Defaults to Delete.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Parent is the name of an LNamespace that this namespace inherits its
+	// managers, sudoers, developers, billing and label overrides from.
+	// Subjects set here are added to the inherited ones, while billing and
+	// label override keys set here override the inherited ones. Only
+	// sudoers and managers of the parent and platform admins can set it.
+	// +optional
+	Parent string `json:"parent,omitempty"`
+
+	// Teams are the names of LTeams whose members are granted access to the
+	// namespace in addition to the subjects set here, and whose billing is
+	// the default billing of the namespace. Earlier teams take precedence.
+	// Only members of a team and platform admins can add it.
+	// +optional
+	Teams []string `json:"teams,omitempty"`
+
+	// Clusters are the names of the member clusters that the namespace and
+	// its RBAC are created in, in addition to the hub cluster. Requires the
+	// multi-cluster mode of the controller. Clusters can only be added by
+	// platform admins and the groups their cluster Secret allows.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+
+	// ClusterSelector selects member clusters by the labels of their cluster
+	// Secret, in addition to those named in Clusters. Only platform admins
+	// can set it.
+	// +optional
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+}
+
+const (
+	// LabelClusterSecret marks the Secrets that hold the kubeconfig of a
+	// member cluster. The cluster is named after the Secret.
+	LabelClusterSecret = "gial.lblw.dev/cluster"
+	// ClusterKubeconfigKey is the key of the kubeconfig in a cluster Secret.
+	ClusterKubeconfigKey = "kubeconfig"
+	// AnnotationClusterAllowedGroups lists, comma separated, the groups
+	// allowed to place namespaces in the cluster of a cluster Secret besides
+	// platform admins.
+	AnnotationClusterAllowedGroups = "gial.lblw.dev/allowed-groups"
+	// LabelLNamespace marks the namespaces of member clusters with the name of
+	// the LNamespace they are created from.
+	LabelLNamespace = "gial.lblw.dev/lnamespace"
+	// FinalizerClusters holds back the deletion of LNamespaces until their
+	// namespaces are removed from the member clusters.
+	FinalizerClusters = "gial.lblw.dev/clusters"
+)
+
+// ClusterStatus is the state of a namespace in a member cluster.
+type ClusterStatus struct {
+	// Name is the name of the member cluster.
+	Name string `json:"name"`
+	// Synced is true when the namespace and its RBAC are up to date in the cluster.
+	Synced bool `json:"synced"`
+	// Message describes why the namespace is not synced.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the last time Synced or Message changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// DeletionPolicy selects what happens to a namespace when its LNamespace is deleted.
+// +kubebuilder:validation:Enum=Delete;SoftDelete;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the namespace and everything in it together with the LNamespace.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicySoftDelete scales down the namespace and revokes access to
+	// it, and only deletes it once the restore window has passed. Managers can
+	// restore the LNamespace during the window.
+	DeletionPolicySoftDelete DeletionPolicy = "SoftDelete"
+	// DeletionPolicyRetain detaches the namespace and its RBAC from the
+	// LNamespace, so that they are kept after it is deleted.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+
+	// FinalizerDeletionPolicy holds back the deletion of LNamespaces until their deletion policy is applied.
+	FinalizerDeletionPolicy = "gial.lblw.dev/deletion-policy"
+	// AnnotationRestore is set by a manager on an LNamespace that is pending deletion to restore it.
+	AnnotationRestore = "gial.lblw.dev/restore"
+	// AnnotationRetainedFrom records the name and UID of the LNamespace a retained namespace was detached from.
+	AnnotationRetainedFrom = "gial.lblw.dev/retained-from"
+	// AnnotationSoftDeletedFrom records the name and UID of the LNamespace a
+	// soft deleted namespace was detached from, so that it is not garbage
+	// collected before its restore window has passed.
+	AnnotationSoftDeletedFrom = "gial.lblw.dev/soft-deleted-from"
+)
+
+// Environment classifies a namespace by the criticality of its workloads.
+// +kubebuilder:validation:Enum=dev;staging;prod
+type Environment string
+
+const (
+	// EnvironmentDev is used for development and sandbox namespaces.
+	EnvironmentDev Environment = "dev"
+	// EnvironmentStaging is used for pre-production namespaces.
+	EnvironmentStaging Environment = "staging"
+	// EnvironmentProd is used for production namespaces, with stricter RBAC.
+	EnvironmentProd Environment = "prod"
+
+	// LabelEnvironment is the namespace label holding the environment of the namespace.
+	LabelEnvironment = "gial.lblw.dev/environment"
+	// BillingEnvironment is the billing attribute holding the environment of the namespace.
+	BillingEnvironment = "environment"
+)
+
+// SudoSession allows a sudoer of a prod namespace to sudo until it expires.
+type SudoSession struct {
+	// Name is the name of the sudoer, as listed in Sudoers.
+	Name string `json:"name"`
+	// Expires is the time at which the session ends.
+	Expires metav1.Time `json:"expires"`
+}
+
+// Expiry returns the time at which the namespace expires, and false if it never does.
+func (ns *LNamespace) Expiry() (time.Time, bool) {
+	if ns.Spec.ExpiresAt != nil {
+		return ns.Spec.ExpiresAt.Time, true
+	}
+	if ns.Spec.TTL != nil {
+		return ns.CreationTimestamp.Add(ns.Spec.TTL.Duration), true
+	}
+	return time.Time{}, false
+}
+
+// Protected returns true if the namespace is protected from deletion, which
+// prod namespaces are by default.
+func (s *LNamespaceSpec) Protected() bool {
+	if s.DeletionProtection != nil {
+		return *s.DeletionProtection
+	}
+	return s.Env() == EnvironmentProd
+}
+
+// PendingDeletion returns true if the LNamespace was soft deleted and waits
+// for its restore window to pass.
+func (ns *LNamespace) PendingDeletion() bool {
+	return !ns.DeletionTimestamp.IsZero() && ns.Spec.DeletionPolicy == DeletionPolicySoftDelete
+}
+
+// Retained returns true if the LNamespace is being deleted and its namespace
+// is to be kept.
+func (ns *LNamespace) Retained() bool {
+	return !ns.DeletionTimestamp.IsZero() && ns.Spec.DeletionPolicy == DeletionPolicyRetain
+}
+
+// Env returns the environment of the namespace, defaulting to dev.
+func (s *LNamespaceSpec) Env() Environment {
+	if s.Environment == "" {
+		return EnvironmentDev
+	}
+	return s.Environment
+}
+
+// BillingAttributes returns the billing information of the namespace,
+// including its environment.
+func (s *LNamespaceSpec) BillingAttributes() map[string]string {
+	attributes := make(map[string]string, len(s.Billing)+1)
+	for k, v := range s.Billing {
+		attributes[k] = v
+	}
+	attributes[BillingEnvironment] = string(s.Env())
+	return attributes
+}
+
+// MeshMode selects how the workloads of a namespace join the istio mesh.
+// +kubebuilder:validation:Enum=sidecar;ambient;none
+type MeshMode string
+
+const (
+	// MeshSidecar injects sidecars from the control plane selected by IstioRevision.
+	MeshSidecar MeshMode = "sidecar"
+	// MeshAmbient captures traffic through the ambient data plane, without sidecars.
+	MeshAmbient MeshMode = "ambient"
+	// MeshNone keeps the namespace out of the mesh.
+	MeshNone MeshMode = "none"
+)
+
+// Mesh configures how a namespace joins the istio mesh.
+type Mesh struct {
+	// Mode selects the data plane of the namespace. Defaults to sidecar.
+	// +optional
+	Mode MeshMode `json:"mode,omitempty"`
+
+	// StrictMTLS manages a STRICT PeerAuthentication that rejects plain text
+	// traffic to the workloads of the namespace.
+	// +optional
+	StrictMTLS bool `json:"strictMTLS,omitempty"`
+
+	// DefaultDeny manages an AuthorizationPolicy that denies traffic to the
+	// namespace, except from the namespace itself, the platform namespaces
+	// and the trusted peers of spec.network.
+	// +optional
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+
+	// Egress manages a Sidecar that limits the services known to the sidecars
+	// of the namespace. Only applies to sidecar mode.
+	// +optional
+	Egress *MeshEgress `json:"egress,omitempty"`
+}
+
+// MeshEgress scopes the egress of the sidecars of a namespace.
+type MeshEgress struct {
+	// Hosts are additional services reachable from the namespace, in
+	// namespace/dnsName format. Services of the namespace itself and of the
+	// istio control plane are always reachable.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// MeshMode returns the mesh mode of the namespace, defaulting to sidecar.
+func (s *LNamespaceSpec) MeshMode() MeshMode {
+	if s.Mesh == nil || s.Mesh.Mode == "" {
+		return MeshSidecar
+	}
+	return s.Mesh.Mode
+}
+
+// IsolationMode selects which namespaces may send traffic to a namespace.
+// +kubebuilder:validation:Enum=tenant;strict;none
+type IsolationMode string
+
+const (
+	// IsolationTenant allows ingress from the namespace itself, the platform
+	// namespaces and trusted peers, and denies ingress from other tenants.
+	IsolationTenant IsolationMode = "tenant"
+	// IsolationStrict allows ingress from the namespace itself and trusted peers only.
+	IsolationStrict IsolationMode = "strict"
+	// IsolationNone does not manage any NetworkPolicies in the namespace.
+	IsolationNone IsolationMode = "none"
+)
+
+// Network configures the network isolation of a namespace.
+type Network struct {
+	// Isolation selects the isolation mode of the namespace. Defaults to tenant.
+	// +optional
+	Isolation IsolationMode `json:"isolation,omitempty"`
+	// TrustedPeers holds the names of LNamespaces allowed to send traffic to this namespace.
+	// +optional
+	TrustedPeers []string `json:"trustedPeers,omitempty"`
+}
+
+// NamespaceSize is a quota tier.
+// +kubebuilder:validation:Enum=small;medium;large;custom;none
+type NamespaceSize string
+
+const (
+	// SizeSmall is the smallest platform-defined quota tier.
+	SizeSmall NamespaceSize = "small"
+	// SizeMedium is the intermediate platform-defined quota tier.
+	SizeMedium NamespaceSize = "medium"
+	// SizeLarge is the largest platform-defined quota tier.
+	SizeLarge NamespaceSize = "large"
+	// SizeCustom takes the quota and limits from CustomSize.
+	SizeCustom NamespaceSize = "custom"
+	// SizeNone applies no ResourceQuota or LimitRange, e.g. to imported
+	// namespaces that had none. Only platform admins can set it.
+	SizeNone NamespaceSize = "none"
+
+	// AnnotationSizeApproval holds the largest platform-defined size that a
+	// platform admin has approved for the LNamespace.
+	AnnotationSizeApproval = "gial.lblw.dev/size-approval"
+)
+
+// sizeRanks orders the platform-defined sizes from the smallest.
+var sizeRanks = map[NamespaceSize]int{
+	SizeSmall:  0,
+	SizeMedium: 1,
+	SizeLarge:  2,
+}
+
+// Platform returns true if s is one of the platform-defined sizes.
+func (s NamespaceSize) Platform() bool {
+	_, ok := sizeRanks[s]
+	return ok
+}
+
+// LargerThan returns true if both sizes are platform-defined and s is larger than o.
+func (s NamespaceSize) LargerThan(o NamespaceSize) bool {
+	return s.Platform() && o.Platform() && sizeRanks[s] > sizeRanks[o]
+}
+
+// CustomSize holds an inline quota and limits.
+type CustomSize struct {
+	// Hard is the set of hard limits of the namespace ResourceQuota.
+	// +optional
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+	// Limits are the limits of the namespace LimitRange.
+	// +optional
+	Limits []corev1.LimitRangeItem `json:"limits,omitempty"`
+}
+
+// PodSecurityLevel is a Pod Security Standards level.
+// +kubebuilder:validation:Enum=privileged;baseline;restricted
+type PodSecurityLevel string
+
+const (
+	// PodSecurityPrivileged is the unrestricted Pod Security Standards level.
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+	// PodSecurityBaseline is the minimally restrictive Pod Security Standards level.
+	PodSecurityBaseline PodSecurityLevel = "baseline"
+	// PodSecurityRestricted is the heavily restricted Pod Security Standards level.
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+
+	// PodSecurityLabelPrefix prefixes the namespace labels read by Pod Security Admission.
+	PodSecurityLabelPrefix = "pod-security.kubernetes.io/"
+	// AnnotationPodSecurityApproval holds the loosest Pod Security level that
+	// a platform admin has approved for the LNamespace.
+	AnnotationPodSecurityApproval = "gial.lblw.dev/pod-security-approval"
+	// AnnotationOwnershipLimitApproval is set to true by a platform admin to
+	// let the LNamespace exceed the ownership limits of its owners.
+	AnnotationOwnershipLimitApproval = "gial.lblw.dev/ownership-limit-approval"
+)
+
+var podSecurityRanks = map[PodSecurityLevel]int{
+	PodSecurityPrivileged: 0,
+	PodSecurityBaseline:   1,
+	PodSecurityRestricted: 2,
+}
+
+// Valid returns true if l is a known Pod Security Standards level.
+func (l PodSecurityLevel) Valid() bool {
+	_, ok := podSecurityRanks[l]
+	return ok
+}
+
+// LooserThan returns true if l allows more than o. Unknown levels are never looser.
+func (l PodSecurityLevel) LooserThan(o PodSecurityLevel) bool {
+	return l.Valid() && o.Valid() && podSecurityRanks[l] < podSecurityRanks[o]
+}
+
+// PodSecurity holds the Pod Security Admission levels of a namespace.
+type PodSecurity struct {
+	// Enforce is the level that pods must satisfy to be admitted.
+	// +optional
+	Enforce PodSecurityLevel `json:"enforce,omitempty"`
+	// Audit is the level above which violations are recorded in the audit log.
+	// +optional
+	Audit PodSecurityLevel `json:"audit,omitempty"`
+	// Warn is the level above which violations are returned as warnings to the user.
+	// +optional
+	Warn PodSecurityLevel `json:"warn,omitempty"`
+}
+
+// Levels returns the levels of p keyed by Pod Security Admission mode.
+func (p *PodSecurity) Levels() map[string]PodSecurityLevel {
+	return map[string]PodSecurityLevel{
+		"enforce": p.Enforce,
+		"audit":   p.Audit,
+		"warn":    p.Warn,
+	}
 }
 
 // LNamespaceStatus defines the observed state of LNamespace
 type LNamespaceStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions represent the latest available observations of the LNamespace.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
+	// Quota is the enforced hard quota and current usage of the namespace.
+	// +optional
+	Quota *corev1.ResourceQuotaStatus `json:"quota,omitempty"`
+
+	// LastExpiryWarning is the last time a warning was emitted about the
+	// upcoming expiry of the LNamespace.
+	// +optional
+	LastExpiryWarning *metav1.Time `json:"lastExpiryWarning,omitempty"`
+
+	// Clusters is the state of the namespace in each member cluster it is placed in.
+	// +optional
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
 }
 
+const (
+	// ConditionIstioRevisionValid is true when spec.istioRevision names an
+	// installed istio control plane. Its message lists the valid revisions.
+	ConditionIstioRevisionValid = "IstioRevisionValid"
+	// ConditionHostsClaimed is true when no claim of spec.hosts overlaps the
+	// claim of an older LNamespace. Its message lists the conflicts.
+	ConditionHostsClaimed = "HostsClaimed"
+	// ConditionSuspended is true while the workloads of a suspended namespace
+	// are scaled down. Its message counts the suspended workloads.
+	ConditionSuspended = "Suspended"
+	// ConditionPendingDeletion is true while a soft deleted LNamespace waits
+	// for its restore window to pass. Its message holds the deletion time.
+	ConditionPendingDeletion = "PendingDeletion"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster,shortName=lns
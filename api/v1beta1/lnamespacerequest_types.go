@@ -0,0 +1,118 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LNamespaceRequestSpec defines the LNamespace that is requested
+type LNamespaceRequestSpec struct {
+	// Name is the name of the LNamespace to create once the request is approved.
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Template is the spec of the LNamespace to create. It cannot change once
+	// the request is decided.
+	Template LNamespaceSpec `json:"template"`
+
+	// Justification tells approvers why the LNamespace is needed.
+	// +optional
+	Justification string `json:"justification,omitempty"`
+
+	// Decision is set by an approver to approve or deny the request. It
+	// cannot change once set. Approving the request also approves what the
+	// template would otherwise need platform admin approval for, such as
+	// looser pod security levels, larger sizes or member clusters.
+	// +optional
+	Decision RequestDecision `json:"decision,omitempty"`
+
+	// DecisionReason is why the approver approved or denied the request.
+	// +optional
+	DecisionReason string `json:"decisionReason,omitempty"`
+}
+
+// AnnotationRequest marks the LNamespaces created for an LNamespaceRequest
+// with the namespace and name of the request.
+const AnnotationRequest = "gial.lblw.dev/request"
+
+// RequestDecision is the decision of an approver on an LNamespaceRequest.
+// +kubebuilder:validation:Enum=Approved;Denied
+type RequestDecision string
+
+const (
+	// DecisionApproved lets the controller create the requested LNamespace.
+	DecisionApproved RequestDecision = "Approved"
+	// DecisionDenied closes the request without creating the LNamespace.
+	DecisionDenied RequestDecision = "Denied"
+)
+
+// RequestPhase is the outcome of an LNamespaceRequest.
+type RequestPhase string
+
+const (
+	// RequestPending means that the request is waiting for a decision.
+	RequestPending RequestPhase = "Pending"
+	// RequestApproved means that the request was approved and the LNamespace was created.
+	RequestApproved RequestPhase = "Approved"
+	// RequestDenied means that the request was denied.
+	RequestDenied RequestPhase = "Denied"
+	// RequestFailed means that the request was approved, but the LNamespace
+	// could not be created, e.g. because its name is taken.
+	RequestFailed RequestPhase = "Failed"
+)
+
+// LNamespaceRequestStatus defines the observed state of LNamespaceRequest
+type LNamespaceRequestStatus struct {
+	// Phase is the outcome of the request.
+	// +optional
+	Phase RequestPhase `json:"phase,omitempty"`
+	// Message describes the reason for the current phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=lnsr
+// +kubebuilder:printcolumn:name="LNamespace",type=string,JSONPath=`.spec.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`,priority=1
+
+// LNamespaceRequest asks platform approvers for an LNamespace, which the
+// controller creates once the request is approved
+type LNamespaceRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LNamespaceRequestSpec   `json:"spec"`
+	Status LNamespaceRequestStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LNamespaceRequestList contains a list of LNamespaceRequest
+type LNamespaceRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LNamespaceRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LNamespaceRequest{}, &LNamespaceRequestList{})
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LTeamSpec defines the members of an LTeam
+type LTeamSpec struct {
+	// Managers are granted manager access to every LNamespace of the team.
+	// +optional
+	Managers []rbacv1.Subject `json:"managers,omitempty"`
+
+	// Sudoers are allowed to sudo in every LNamespace of the team.
+	// +optional
+	Sudoers []rbacv1.Subject `json:"sudoers,omitempty"`
+
+	// Developers are allowed to edit common resources in every LNamespace of the team.
+	// +optional
+	Developers []rbacv1.Subject `json:"users,omitempty"`
+
+	// Billing holds the default billing information of the LNamespaces of
+	// the team. LNamespaces can override it key by key.
+	// +optional
+	Billing map[string]string `json:"billing,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=lt
+
+// LTeam holds the members and default billing shared by the LNamespaces that reference it
+type LTeam struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LTeamSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LTeamList contains a list of LTeam
+type LTeamList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LTeam `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LTeam{}, &LTeamList{})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// lns-export writes every LNamespace to a directory as one normalized YAML
+// file per tenant, without status and server-set metadata, so that tenant
+// configuration can be reviewed and backed up in git. With --interval, it
+// exports periodically, e.g. to a mounted volume. With --diff, it compares
+// the directory to the cluster instead and exits with status 1 if they differ.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/manifest"
+)
+
+func main() {
+	var dir string
+	var interval time.Duration
+	var diff bool
+	flag.StringVar(&dir, "dir", "lnamespaces", "Directory holding one file per LNamespace.")
+	flag.DurationVar(&interval, "interval", 0, "Export every interval until interrupted, instead of once.")
+	flag.BoolVar(&diff, "diff", false, "Compare the directory to the cluster instead of exporting.")
+	flag.Parse()
+
+	scheme := runtime.NewScheme()
+	if err := gialv1beta1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+		os.Exit(2)
+	}
+	ctx := ctrl.SetupSignalHandler()
+
+	if diff {
+		differences, err := manifest.Diff(ctx, c, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to compare %s: %v\n", dir, err)
+			os.Exit(2)
+		}
+		if len(differences) == 0 {
+			fmt.Printf("%s matches the cluster.\n", dir)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDIFFERENCE")
+		for _, v := range differences {
+			fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Reason)
+		}
+		w.Flush()
+		os.Exit(1)
+	}
+
+	if interval == 0 {
+		if err := export(ctx, c, dir); err != nil {
+			os.Exit(2)
+		}
+		return
+	}
+	for {
+		// a failed export is retried at the next interval
+		_ = export(ctx, c, dir)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func export(ctx context.Context, c client.Reader, dir string) error {
+	n, err := manifest.Export(ctx, c, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to export to %s: %v\n", dir, err)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d LNamespaces to %s\n", n, dir)
+	return nil
+}
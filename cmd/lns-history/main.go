@@ -0,0 +1,161 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// lns-history lists the spec revisions recorded for an LNamespace, shows the
+// spec of one revision with --show, and restores it with --rollback-to. A
+// rollback updates the LNamespace with the credentials of the caller, so it
+// goes through the same admission webhooks as any other change, and keeps the
+// current sudo sessions.
+//
+// Revisions are recorded in the namespace of the manager, which tenants cannot
+// read, so listing and rolling back revisions is an admin-only operation.
+// lns-history is not part of the manager image, and is built from source with
+// go build ./cmd/lns-history.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/revision"
+)
+
+func main() {
+	namespace := os.Getenv("NC_REVISION_NAMESPACE")
+	if namespace == "" {
+		namespace = "namespace-controller-system"
+	}
+	var show, rollbackTo int64
+	flag.StringVar(&namespace, "namespace", namespace, "Namespace the revisions are recorded in. Defaults to NC_REVISION_NAMESPACE.")
+	flag.Int64Var(&show, "show", 0, "Print the spec of this revision.")
+	flag.Int64Var(&rollbackTo, "rollback-to", 0, "Restore the spec of this revision.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] NAME\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Revisions are only readable by admins of the namespace they are recorded in.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (show != 0 && rollbackTo != 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+
+	scheme := runtime.NewScheme()
+	if err := gialv1beta1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+		os.Exit(2)
+	}
+	ctx := context.Background()
+
+	revisions, err := revision.List(ctx, c, namespace, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if len(revisions) == 0 {
+		fmt.Fprintf(os.Stderr, "no revisions of %s in %s\n", name, namespace)
+		os.Exit(1)
+	}
+
+	switch {
+	case show != 0:
+		spec := find(revisions, show)
+		b, err := yaml.Marshal(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to encode revision %d: %v\n", show, err)
+			os.Exit(2)
+		}
+		fmt.Print(string(b))
+	case rollbackTo != 0:
+		spec := find(revisions, rollbackTo)
+		ns := &gialv1beta1.LNamespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to get %s: %v\n", name, err)
+			os.Exit(2)
+		}
+		spec.SudoSessions = ns.Spec.SudoSessions
+		ns.Spec = *spec
+		if err := c.Update(ctx, ns, client.FieldOwner("lns-history")); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to roll %s back to revision %d: %v\n", name, rollbackTo, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s rolled back to revision %d.\n", name, rollbackTo)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REVISION\tAUTHOR\tAGE\tCHANGES")
+		var previous *gialv1beta1.LNamespaceSpec
+		for i := range revisions {
+			rev := &revisions[i]
+			spec, err := revision.Spec(rev)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(2)
+			}
+			changes := "<initial>"
+			if previous != nil {
+				changes = strings.Join(revision.Changes(previous, spec), ",")
+			}
+			author := rev.Annotations[revision.AnnotationAuthor]
+			if author == "" {
+				author = "<unknown>"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", rev.Revision, author, duration.HumanDuration(time.Since(rev.CreationTimestamp.Time)), changes)
+			previous = spec
+		}
+		w.Flush()
+	}
+}
+
+// find returns the spec of the revision numbered n, or exits if there is none.
+func find(revisions []appsv1.ControllerRevision, n int64) *gialv1beta1.LNamespaceSpec {
+	for i := range revisions {
+		if revisions[i].Revision != n {
+			continue
+		}
+		spec, err := revision.Spec(&revisions[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+		return spec
+	}
+	fmt.Fprintf(os.Stderr, "no revision %d\n", n)
+	os.Exit(1)
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// lns-import generates LNamespaces for the existing namespaces of a cluster
+// that no LNamespace manages yet, from their RoleBindings to cluster-admin and
+// admin, their billing annotations and their labels. The manifests are written
+// to stdout and the conflict report to stderr. With --apply, the LNamespaces
+// are created and adopt the existing namespaces. It exits with status 1 if an
+// LNamespace could not be created.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/importer"
+	"github.com/loblaw-sre/namespace-controller/pkg/manifest"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+)
+
+func main() {
+	var billing, exclude, defaultPodSecurityLevel string
+	var apply bool
+	flag.StringVar(&billing, "billing", "", "Comma separated billing keys, or key=annotation pairs, read from the annotations of the namespaces.")
+	flag.StringVar(&exclude, "exclude", strings.Join(importer.DefaultExclude, ","), "Comma separated shell patterns of namespaces that are not imported.")
+	flag.StringVar(&defaultPodSecurityLevel, "default-pod-security-level", os.Getenv("NC_DEFAULT_POD_SECURITY_LEVEL"), "Cluster default pod security level. Looser levels of imported namespaces are approved. Defaults to restricted.")
+	flag.BoolVar(&apply, "apply", false, "Create the LNamespaces, which adopt the existing namespaces.")
+	flag.Parse()
+
+	opts := importer.Options{
+		Exclude:                 utils.SplitList(exclude),
+		DefaultPodSecurityLevel: gialv1beta1.PodSecurityLevel(defaultPodSecurityLevel),
+	}
+	if opts.DefaultPodSecurityLevel == "" {
+		opts.DefaultPodSecurityLevel = gialv1beta1.PodSecurityRestricted
+	} else if !opts.DefaultPodSecurityLevel.Valid() {
+		fmt.Fprintf(os.Stderr, "unknown pod security level %q, use privileged, baseline or restricted\n", defaultPodSecurityLevel)
+		os.Exit(2)
+	}
+	var err error
+	opts.Billing, err = importer.ParseBilling(billing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse billing: %v\n", err)
+		os.Exit(2)
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	if err := gialv1beta1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	res, err := importer.Import(ctx, c, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to import namespaces: %v\n", err)
+		os.Exit(2)
+	}
+
+	for _, v := range res.LNamespaces {
+		b, err := manifest.Marshal(&v)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Printf("---\n%s", b)
+	}
+
+	failed := false
+	if apply {
+		for i := range res.LNamespaces {
+			lns := &res.LNamespaces[i]
+			err := c.Create(ctx, lns)
+			switch {
+			case err == nil:
+				fmt.Fprintf(os.Stderr, "adopted namespace %s\n", lns.Name)
+			case apierrors.IsAlreadyExists(err):
+				res.Conflicts = append(res.Conflicts, importer.Conflict{Namespace: lns.Name, Reason: "an LNamespace was created meanwhile", Skipped: true})
+			case apierrors.IsForbidden(err), apierrors.IsInvalid(err):
+				failed = true
+				res.Conflicts = append(res.Conflicts, importer.Conflict{Namespace: lns.Name, Reason: "rejected: " + err.Error(), Skipped: true})
+			default:
+				fmt.Fprintf(os.Stderr, "unable to create LNamespace %s: %v\n", lns.Name, err)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if len(res.Conflicts) > 0 {
+		w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tSKIPPED\tCONFLICT")
+		for _, v := range res.Conflicts {
+			fmt.Fprintf(w, "%s\t%t\t%s\n", v.Namespace, v.Skipped, v.Reason)
+		}
+		w.Flush()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
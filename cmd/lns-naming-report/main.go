@@ -0,0 +1,76 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// lns-naming-report lists the LNamespaces whose names violate the naming
+// policy, e.g. because they were created before the policy was enforced. It
+// exits with status 1 if any name violates the policy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+)
+
+func main() {
+	var pattern, prefixes string
+	flag.StringVar(&pattern, "pattern", os.Getenv("NC_NAME_PATTERN"), "Shell pattern that names must match. {key} is replaced with the value of key in the billing of the namespace.")
+	flag.StringVar(&prefixes, "reserved-prefixes", os.Getenv("NC_RESERVED_NAME_PREFIXES"), "Comma separated prefix=group pairs reserving name prefixes for groups.")
+	flag.Parse()
+
+	policy := naming.Policy{Pattern: pattern}
+	var err error
+	policy.Prefixes, err = naming.ParsePrefixes(prefixes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse reserved prefixes: %v\n", err)
+		os.Exit(2)
+	}
+	scheme := runtime.NewScheme()
+	if err := gialv1beta1.AddToScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to register the API types: %v\n", err)
+		os.Exit(2)
+	}
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+		os.Exit(2)
+	}
+	violations, err := naming.Violations(context.Background(), c, policy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to check names: %v\n", err)
+		os.Exit(2)
+	}
+	if len(violations) == 0 {
+		fmt.Println("No LNamespace violates the naming policy.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVIOLATION")
+	for _, v := range violations {
+		fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Reason)
+	}
+	w.Flush()
+	os.Exit(1)
+}
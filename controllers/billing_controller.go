@@ -7,11 +7,16 @@ import (
 	"github.com/go-logr/logr"
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
 	"github.com/loblaw-sre/namespace-controller/pkg/bq"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
 	"github.com/loblaw-sre/namespace-controller/pkg/types"
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type BillingReconciler struct {
@@ -39,6 +44,11 @@ func (r *BillingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{}, err
 		}
 	}
+	// billing inherited from parent namespaces is recorded as well
+	resolved, err := hierarchy.Resolve(ctx, r, ns)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "unable to resolve parent namespaces")
+	}
 	// see if ns labels are already created
 	// TODO: this should be parameterized, but cannot be supported via the current
 	// bq util. SQL inject should be low risk here, since namespace names are
@@ -56,7 +66,7 @@ func (r *BillingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	entriesToUpdate := []types.NSLabelEntry{}
-	for k, v := range ns.Spec.Billing {
+	for k, v := range resolved.Spec.BillingAttributes() {
 		value, ok := unpackedLabels[k]
 		update := value != v || !ok
 		if update {
@@ -91,5 +101,11 @@ func (r *BillingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 func (r *BillingReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gialv1beta1.LNamespace{}).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LNamespace{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForDescendants(r, r.Log)), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LTeam{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForTeam(r, r.Log))).
 		Complete(r)
 }
@@ -96,6 +96,10 @@ var _ = Describe("Billing Controller", func() {
 						"NSName": Equal(DefaultName),
 						"Name":   Equal("budget"),
 						"Value":  Equal("1.0"),
+					}), MatchAllFields(Fields{
+						"NSName": Equal(DefaultName),
+						"Name":   Equal(gialv1beta1.BillingEnvironment),
+						"Value":  Equal("dev"),
 					})),
 				})))
 				close(done)
@@ -103,6 +107,7 @@ var _ = Describe("Billing Controller", func() {
 			It("no-ops if ns label entries already exist", func(done Done) {
 				db.output = []map[string]string{
 					{"ns_name": DefaultName, "name": "budget", "value": "1.0"},
+					{"ns_name": DefaultName, "name": gialv1beta1.BillingEnvironment, "value": "dev"},
 				}
 				_, err := br.Reconcile(ctx, controllerruntime.Request{
 					NamespacedName: types.NamespacedName{
@@ -0,0 +1,489 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+)
+
+// DefaultClusterResyncInterval is how often namespaces are synced into member
+// clusters, which are not watched, to undo changes made there.
+const DefaultClusterResyncInterval = 10 * time.Minute
+
+// ClusterReconciler reconciles the namespaces and RBAC of an LNamespace in
+// the member clusters it is placed in. Member clusters are registered as
+// Secrets holding their kubeconfig, labelled with gialv1beta1.LabelClusterSecret.
+type ClusterReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// SecretNamespace is the namespace of the cluster Secrets.
+	SecretNamespace string
+	// SecretCache caches the Secrets of SecretNamespace, so that the Secrets
+	// of other namespaces are neither watched nor readable. It is required by
+	// SetupWithManager, while Reconcile falls back to the client without it.
+	SecretCache cache.Cache
+	// ProtectedLabels are namespace labels that NamespaceLabelOverrides may not replace.
+	ProtectedLabels utils.KeyMatcher
+	// ProdDeveloperRole is the ClusterRole bound to the developers of prod
+	// namespaces instead of admin. Defaults to view.
+	ProdDeveloperRole string
+	// ResyncInterval defaults to DefaultClusterResyncInterval.
+	ResyncInterval time.Duration
+	// NewClient returns a client for the cluster of a kubeconfig. Defaults to
+	// a client using the scheme of the reconciler.
+	NewClient func(kubeconfig []byte) (client.Client, error)
+
+	mu      sync.Mutex
+	clients map[string]cachedClient
+}
+
+// cachedClient is a client built from the kubeconfig of a cluster Secret,
+// kept until the Secret changes.
+type cachedClient struct {
+	resourceVersion string
+	client          client.Client
+}
+
+// errUnmanagedNamespace is returned when a member cluster already has a
+// namespace of the same name that was not created by the controller.
+var errUnmanagedNamespace = errors.New("namespace already exists and is not managed by the controller")
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// The cluster Secrets are read through the Role in deploy/rbac/cluster_secret_role.yaml,
+// which is bound in the cluster secret namespace only.
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without updating member clusters.")
+			return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+		}
+	}
+	secrets, err := r.clusterSecrets(ctx)
+	if err != nil {
+		log.Error(err, "unable to list cluster secrets")
+		return ctrl.Result{}, err
+	}
+	if !ns.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, log, ns, secrets)
+	}
+	clusters, err := placement(ns, secrets)
+	if err != nil {
+		log.Error(err, "unable to select clusters")
+		return ctrl.Result{}, err
+	}
+	if err := r.setFinalizer(ctx, ns, len(clusters) > 0 || len(ns.Status.Clusters) > 0); err != nil {
+		log.Error(err, "unable to update finalizer")
+		return ctrl.Result{}, err
+	}
+	if len(clusters) == 0 && len(ns.Status.Clusters) == 0 {
+		return ctrl.Result{}, nil
+	}
+	return r.sync(ctx, log, ns, clusters, secrets)
+}
+
+// sync reconciles ns into clusters, removes it from the clusters it is no
+// longer placed in and records the outcome in the status of ns.
+func (r *ClusterReconciler) sync(ctx context.Context, log logr.Logger, ns *gialv1beta1.LNamespace, clusters []string, secrets map[string]*corev1.Secret) (ctrl.Result, error) {
+	resolved, err := hierarchy.Resolve(ctx, r, ns)
+	if err != nil {
+		log.Error(err, "unable to resolve parent namespaces")
+		return ctrl.Result{}, err
+	}
+	overrides, _ := labelOverrides(resolved, r.ProtectedLabels)
+
+	placed := make(map[string]bool, len(clusters))
+	statuses := make([]gialv1beta1.ClusterStatus, 0, len(clusters))
+	for _, name := range clusters {
+		placed[name] = true
+		status := gialv1beta1.ClusterStatus{Name: name, Synced: true}
+		if err := r.syncCluster(ctx, ns, resolved, overrides, name, secrets[name]); err != nil {
+			log.Error(err, "unable to sync namespace to cluster", "cluster", name)
+			status.Synced = false
+			status.Message = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	for _, v := range ns.Status.Clusters {
+		if placed[v.Name] {
+			continue
+		}
+		if err := r.removeFromCluster(ctx, ns, v.Name, secrets[v.Name]); err != nil {
+			log.Error(err, "unable to remove namespace from cluster", "cluster", v.Name)
+			statuses = append(statuses, gialv1beta1.ClusterStatus{Name: v.Name, Message: "unable to remove namespace: " + err.Error()})
+			continue
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	previous := make(map[string]gialv1beta1.ClusterStatus, len(ns.Status.Clusters))
+	for _, v := range ns.Status.Clusters {
+		previous[v.Name] = v
+	}
+	if setClusterStatuses(&ns.Status.Clusters, statuses, metav1.Now()) {
+		for _, v := range statuses {
+			if p, ok := previous[v.Name]; !v.Synced && (!ok || p.Synced || p.Message != v.Message) {
+				r.Recorder.Eventf(ns, "Warning", "ClusterSyncFailed", "Namespace %s is not synced to cluster %s: %s", ns.Name, v.Name, v.Message)
+			}
+		}
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to update cluster status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	requeue := r.ResyncInterval
+	if requeue == 0 {
+		requeue = DefaultClusterResyncInterval
+	}
+	// revoke sudo once the next session expires
+	if _, next := activeSudoers(resolved, time.Now()); next > 0 && next < requeue {
+		requeue = next
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// finalize removes ns from its member clusters once it is deleted, unless its
+// namespace is retained or restored. A soft deleted namespace keeps being
+// synced until its restore window passes, so that its RBAC is revoked there too.
+func (r *ClusterReconciler) finalize(ctx context.Context, log logr.Logger, ns *gialv1beta1.LNamespace, secrets map[string]*corev1.Secret) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(ns, gialv1beta1.FinalizerClusters) {
+		return ctrl.Result{}, nil
+	}
+	// the other finalizers decide whether the namespace is deleted, so they
+	// are waited for.
+	if len(ns.Finalizers) > 1 {
+		if !ns.PendingDeletion() {
+			return ctrl.Result{}, nil
+		}
+		clusters, err := placement(ns, secrets)
+		if err != nil {
+			log.Error(err, "unable to select clusters")
+			return ctrl.Result{}, err
+		}
+		return r.sync(ctx, log, ns, clusters, secrets)
+	}
+	if ns.Retained() || ns.Annotations[gialv1beta1.AnnotationRestore] == "true" {
+		log.Info("namespace is kept. Continuing without removing it from member clusters.")
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+	}
+	for _, v := range ns.Status.Clusters {
+		if err := r.removeFromCluster(ctx, ns, v.Name, secrets[v.Name]); err != nil {
+			log.Error(err, "unable to remove namespace from cluster", "cluster", v.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+}
+
+// syncCluster creates or updates the namespace of ns and its RBAC in the
+// cluster of secret. Unlike in the hub cluster, the sudoers of ns are not
+// allowed to edit the LNamespace there, as it only exists in the hub.
+func (r *ClusterReconciler) syncCluster(ctx context.Context, ns, resolved *gialv1beta1.LNamespace, overrides map[string]string, name string, secret *corev1.Secret) error {
+	c, err := r.clientFor(name, secret)
+	if err != nil {
+		return err
+	}
+
+	cns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ns.Name,
+		},
+	}
+	opRes, err := controllerutil.CreateOrPatch(ctx, c, cns, func() error {
+		if cns.ResourceVersion != "" && cns.Labels[gialv1beta1.LabelLNamespace] != ns.Name {
+			return errUnmanagedNamespace
+		}
+		setNamespaceMetadata(cns, resolved, overrides, map[string]string{gialv1beta1.LabelLNamespace: ns.Name})
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create or update namespace")
+	}
+	if opRes == controllerutil.OperationResultCreated {
+		r.Recorder.Eventf(ns, "Normal", "Create", "Created namespace %s in cluster %s", ns.Name, name)
+	}
+
+	// self impersonators are shared by every namespace of a sudoer, so they
+	// are not labelled with ns and are left behind when it is removed.
+	for _, v := range resolved.Spec.Sudoers {
+		cr, crb := selfImpersonator(v)
+		for _, o := range []client.Object{cr, crb} {
+			if _, err := applyRBAC(ctx, c, o, func(client.Object) error { return nil }); err != nil {
+				return err
+			}
+		}
+	}
+	owned := func(o client.Object) error {
+		labelMember(o, ns.Name)
+		return nil
+	}
+	sgr, sgrb := sudoerGroupImpersonator(resolved, time.Now())
+	for _, o := range []client.Object{sgr, sgrb, sudoerRoleBinding(resolved), developerRoleBinding(resolved, r.ProdDeveloperRole)} {
+		if _, err := applyRBAC(ctx, c, o, owned); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromCluster deletes the namespace of ns and its sudoer group
+// impersonator from the cluster of secret. Objects that are not labelled with
+// ns are left alone. A cluster that is no longer registered is skipped, since
+// it cannot be reached.
+func (r *ClusterReconciler) removeFromCluster(ctx context.Context, ns *gialv1beta1.LNamespace, name string, secret *corev1.Secret) error {
+	if secret == nil {
+		r.Recorder.Eventf(ns, "Warning", "ClusterNotFound", "Unable to remove namespace %s from cluster %s, which is no longer registered", ns.Name, name)
+		return nil
+	}
+	c, err := r.clientFor(name, secret)
+	if err != nil {
+		return err
+	}
+	for _, o := range []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns.Name}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: ns.GetSudoersGroupName()}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: ns.GetSudoersGroupName()}},
+	} {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(o), o); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to get %s", o.GetName())
+		}
+		if o.GetLabels()[gialv1beta1.LabelLNamespace] != ns.Name {
+			continue
+		}
+		if err := c.Delete(ctx, o); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "unable to delete %s", o.GetName())
+		}
+	}
+	r.Recorder.Eventf(ns, "Normal", "Delete", "Removed namespace %s from cluster %s", ns.Name, name)
+	return nil
+}
+
+// labelMember labels an object of a member cluster with the LNamespace it
+// belongs to, so that it is removed along with the namespace.
+func labelMember(o client.Object, name string) {
+	l := o.GetLabels()
+	if l == nil {
+		l = make(map[string]string)
+	}
+	l[gialv1beta1.LabelLNamespace] = name
+	o.SetLabels(l)
+}
+
+// clientFor returns a client for the cluster of secret.
+func (r *ClusterReconciler) clientFor(name string, secret *corev1.Secret) (client.Client, error) {
+	if secret == nil {
+		return nil, fmt.Errorf("cluster %s is not registered", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.clients[name]; ok && c.resourceVersion == secret.ResourceVersion {
+		return c.client, nil
+	}
+	kubeconfig, ok := secret.Data[gialv1beta1.ClusterKubeconfigKey]
+	if !ok {
+		return nil, fmt.Errorf("cluster secret %s has no %s key", name, gialv1beta1.ClusterKubeconfigKey)
+	}
+	newClient := r.NewClient
+	if newClient == nil {
+		newClient = r.newClient
+	}
+	c, err := newClient(kubeconfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to cluster %s", name)
+	}
+	if r.clients == nil {
+		r.clients = make(map[string]cachedClient)
+	}
+	r.clients[name] = cachedClient{resourceVersion: secret.ResourceVersion, client: c}
+	return c, nil
+}
+
+func (r *ClusterReconciler) newClient(kubeconfig []byte) (client.Client, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse kubeconfig")
+	}
+	return client.New(config, client.Options{Scheme: r.Scheme()})
+}
+
+// clusterSecrets returns the cluster Secrets by the name of their cluster.
+func (r *ClusterReconciler) clusterSecrets(ctx context.Context) (map[string]*corev1.Secret, error) {
+	var reader client.Reader = r.Client
+	if r.SecretCache != nil {
+		reader = r.SecretCache
+	}
+	l := &corev1.SecretList{}
+	if err := reader.List(ctx, l, client.InNamespace(r.SecretNamespace), client.HasLabels{gialv1beta1.LabelClusterSecret}); err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]*corev1.Secret, len(l.Items))
+	for i := range l.Items {
+		secrets[l.Items[i].Name] = &l.Items[i]
+	}
+	return secrets, nil
+}
+
+// placement returns the sorted names of the clusters that ns is placed in,
+// which are those it names and those whose Secret matches its selector.
+func placement(ns *gialv1beta1.LNamespace, secrets map[string]*corev1.Secret) ([]string, error) {
+	placed := make(map[string]bool)
+	for _, v := range ns.Spec.Clusters {
+		placed[v] = true
+	}
+	if ns.Spec.ClusterSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(ns.Spec.ClusterSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid cluster selector")
+		}
+		for name, v := range secrets {
+			if selector.Matches(labels.Set(v.Labels)) {
+				placed[name] = true
+			}
+		}
+	}
+	clusters := make([]string, 0, len(placed))
+	for k := range placed {
+		clusters = append(clusters, k)
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// setClusterStatuses sets current to desired, keeping the transition time of
+// the clusters whose state did not change. It returns false if nothing changed.
+func setClusterStatuses(current *[]gialv1beta1.ClusterStatus, desired []gialv1beta1.ClusterStatus, now metav1.Time) bool {
+	previous := make(map[string]gialv1beta1.ClusterStatus, len(*current))
+	for _, v := range *current {
+		previous[v.Name] = v
+	}
+	changed := len(*current) != len(desired)
+	for i := range desired {
+		if p, ok := previous[desired[i].Name]; ok && p.Synced == desired[i].Synced && p.Message == desired[i].Message {
+			desired[i].LastTransitionTime = p.LastTransitionTime
+			continue
+		}
+		desired[i].LastTransitionTime = now.DeepCopy()
+		changed = true
+	}
+	if len(desired) == 0 {
+		desired = nil
+	}
+	*current = desired
+	return changed
+}
+
+// setFinalizer adds or removes the clusters finalizer of ns.
+func (r *ClusterReconciler) setFinalizer(ctx context.Context, ns *gialv1beta1.LNamespace, present bool) error {
+	if controllerutil.ContainsFinalizer(ns, gialv1beta1.FinalizerClusters) == present {
+		return nil
+	}
+	if present {
+		controllerutil.AddFinalizer(ns, gialv1beta1.FinalizerClusters)
+	} else {
+		controllerutil.RemoveFinalizer(ns, gialv1beta1.FinalizerClusters)
+	}
+	return r.Update(ctx, ns)
+}
+
+// requestsForClusterSecret maps a cluster Secret to every LNamespace, so that
+// they are placed in clusters as they are registered and relabelled.
+func (r *ClusterReconciler) requestsForClusterSecret(o client.Object) []reconcile.Request {
+	if _, ok := o.GetLabels()[gialv1beta1.LabelClusterSecret]; !ok || o.GetNamespace() != r.SecretNamespace {
+		return nil
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(context.Background(), l); err != nil {
+		r.Log.Error(err, "unable to list namespaces for cluster secret", "name", o.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(l.Items))
+	for _, v := range l.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v.Name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the ClusterReconciler with the provided manager
+func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.SecretCache == nil {
+		return errors.New("a cache of the cluster secret namespace is required")
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Watches(source.NewKindWithCache(&corev1.Secret{}, r.SecretCache), handler.EnqueueRequestsFromMapFunc(r.requestsForClusterSecret)).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LNamespace{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForDescendants(r, r.Log)), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LTeam{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForTeam(r, r.Log))).
+		Complete(r)
+}
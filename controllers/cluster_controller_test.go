@@ -0,0 +1,248 @@
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+)
+
+const ClusterSecretNamespace = "clusters"
+
+// clusterSecret registers a member cluster whose kubeconfig is its name.
+func clusterSecret(name string, labels map[string]string) *corev1.Secret {
+	l := map[string]string{gialv1beta1.LabelClusterSecret: ""}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ClusterSecretNamespace, Labels: l},
+		Data:       map[string][]byte{gialv1beta1.ClusterKubeconfigKey: []byte(name)},
+	}
+}
+
+var _ = Describe("Cluster Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var cr *controllers.ClusterReconciler
+	var recorder *record.FakeRecorder
+	var k8sClient client.Client
+	var members map[string]client.Client
+	var result controllerruntime.Result
+
+	var reconcile = func() {
+		var err error
+		result, err = cr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: DefaultName},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	var get = func() *gialv1beta1.LNamespace {
+		lns := &gialv1beta1.LNamespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		return lns
+	}
+
+	var memberNamespace = func(cluster string) (*corev1.Namespace, error) {
+		cns := &corev1.Namespace{}
+		err := members[cluster].Get(ctx, types.NamespacedName{Name: DefaultName}, cns)
+		return cns, err
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			clusterSecret("east", map[string]string{"region": "east"}),
+			clusterSecret("west", map[string]string{"region": "west"}),
+		).Build()
+		members = map[string]client.Client{
+			"east": fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+			"west": fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+		}
+		recorder = record.NewFakeRecorder(64)
+		cr = &controllers.ClusterReconciler{
+			Client:          k8sClient,
+			Log:             logf.Log,
+			Recorder:        recorder,
+			SecretNamespace: ClusterSecretNamespace,
+			ResyncInterval:  time.Hour,
+			NewClient: func(kubeconfig []byte) (client.Client, error) {
+				c, ok := members[string(kubeconfig)]
+				if !ok {
+					return nil, fmt.Errorf("unknown cluster %s", kubeconfig)
+				}
+				return c, nil
+			},
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Sudoers:     []rbacv1.Subject{{Kind: "User", Name: john}},
+				Developers:  []rbacv1.Subject{{Kind: "User", Name: alice}},
+				Billing:     map[string]string{"budget": "1.0"},
+				Environment: gialv1beta1.EnvironmentDev,
+				Clusters:    []string{"east"},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("should create the namespace and its RBAC in the clusters it names", func(done Done) {
+		cns, err := memberNamespace("east")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cns.Labels).To(HaveKeyWithValue(gialv1beta1.LabelLNamespace, DefaultName))
+		Expect(cns.Labels).To(HaveKeyWithValue(gialv1beta1.LabelEnvironment, string(gialv1beta1.EnvironmentDev)))
+		Expect(cns.Annotations).To(HaveKeyWithValue("budget", "1.0"))
+
+		rb := &rbacv1.RoleBinding{}
+		Expect(members["east"].Get(ctx, types.NamespacedName{Namespace: DefaultName, Name: "developer"}, rb)).ToNot(HaveOccurred())
+		Expect(rb.RoleRef.Name).To(Equal("admin"))
+		Expect(rb.Subjects).To(ConsistOf(rbacv1.Subject{Kind: "User", Name: alice}))
+		sgrb := &rbacv1.ClusterRoleBinding{}
+		Expect(members["east"].Get(ctx, types.NamespacedName{Name: ns.GetSudoersGroupName()}, sgrb)).ToNot(HaveOccurred())
+		Expect(sgrb.Subjects).To(ConsistOf(rbacv1.Subject{Kind: "User", Name: john}))
+
+		_, err = memberNamespace("west")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Namespace should not be placed in west.")
+
+		lns := get()
+		Expect(lns.Finalizers).To(ContainElement(gialv1beta1.FinalizerClusters))
+		Expect(lns.Status.Clusters).To(HaveLen(1))
+		Expect(lns.Status.Clusters[0].Name).To(Equal("east"))
+		Expect(lns.Status.Clusters[0].Synced).To(BeTrue())
+		Expect(result.RequeueAfter).To(Equal(time.Hour))
+		Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("in cluster east")))
+		close(done)
+	}, TestTimeout)
+
+	Context("when clusters are selected by label", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.ClusterSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"region": "west"}}
+			close(done)
+		}, TestTimeout)
+
+		It("should place the namespace in the selected clusters as well", func(done Done) {
+			for _, cluster := range []string{"east", "west"} {
+				_, err := memberNamespace(cluster)
+				Expect(err).ToNot(HaveOccurred(), "Namespace should be placed in %s.", cluster)
+			}
+			Expect(get().Status.Clusters).To(HaveLen(2))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Context("when a cluster is not registered", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Clusters = []string{"north"}
+			close(done)
+		}, TestTimeout)
+
+		It("should report it as not synced", func(done Done) {
+			status := get().Status.Clusters
+			Expect(status).To(HaveLen(1))
+			Expect(status[0].Synced).To(BeFalse())
+			Expect(status[0].Message).To(ContainSubstring("not registered"))
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("ClusterSyncFailed")))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Context("when the member cluster has an unmanaged namespace of the same name", func() {
+		BeforeEach(func(done Done) {
+			Expect(members["east"].Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			})).ToNot(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+
+		It("should leave it alone", func(done Done) {
+			cns, err := memberNamespace("east")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cns.Labels).ToNot(HaveKey(gialv1beta1.LabelLNamespace))
+			status := get().Status.Clusters
+			Expect(status).To(HaveLen(1))
+			Expect(status[0].Synced).To(BeFalse())
+			Expect(status[0].Message).To(ContainSubstring("not managed"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	It("should remove the namespace from clusters it is no longer placed in", func(done Done) {
+		lns := get()
+		lns.Spec.Clusters = []string{"west"}
+		Expect(k8sClient.Update(ctx, lns)).ToNot(HaveOccurred())
+		reconcile()
+
+		_, err := memberNamespace("east")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Namespace should be removed from east.")
+		_, err = memberNamespace("west")
+		Expect(err).ToNot(HaveOccurred())
+		status := get().Status.Clusters
+		Expect(status).To(HaveLen(1))
+		Expect(status[0].Name).To(Equal("west"))
+		close(done)
+	}, TestTimeout)
+
+	It("should keep the transition time of unchanged clusters", func(done Done) {
+		before := get().Status.Clusters[0].LastTransitionTime
+		reconcile()
+		Expect(get().Status.Clusters[0].LastTransitionTime).To(Equal(before))
+		close(done)
+	}, TestTimeout)
+
+	Context("when the LNamespace is deleted", func() {
+		var markDeleted = func() {
+			lns := get()
+			deleted := metav1.Now()
+			lns.DeletionTimestamp = &deleted
+			Expect(k8sClient.Update(ctx, lns)).ToNot(HaveOccurred())
+			reconcile()
+		}
+
+		It("should remove the namespace from the member clusters", func(done Done) {
+			markDeleted()
+			_, err := memberNamespace("east")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Namespace should be removed from east.")
+			Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerClusters))
+			close(done)
+		}, TestTimeout)
+
+		Context("with the retain deletion policy", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.DeletionPolicy = gialv1beta1.DeletionPolicyRetain
+				close(done)
+			}, TestTimeout)
+
+			It("should keep the namespace in the member clusters", func(done Done) {
+				markDeleted()
+				_, err := memberNamespace("east")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerClusters))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+})
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+)
+
+// kubeconfigFor returns a kubeconfig that connects to the API server of cfg.
+func kubeconfigFor(cfg *rest.Config) []byte {
+	c := clientcmdapi.NewConfig()
+	c.Clusters["envtest"] = &clientcmdapi.Cluster{
+		Server:                   cfg.Host,
+		CertificateAuthorityData: cfg.CAData,
+	}
+	c.AuthInfos["envtest"] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: cfg.CertData,
+		ClientKeyData:         cfg.KeyData,
+		Token:                 cfg.BearerToken,
+		Username:              cfg.Username,
+		Password:              cfg.Password,
+	}
+	c.Contexts["envtest"] = &clientcmdapi.Context{Cluster: "envtest", AuthInfo: "envtest"}
+	c.CurrentContext = "envtest"
+	b, err := clientcmd.Write(*c)
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+// These specs run a hub and a member API server, and are skipped unless the
+// envtest binaries are installed, e.g. through make integration-test.
+var _ = Describe("Cluster Controller with member API servers", func() {
+	var ctx context.Context
+	var hub, member *envtest.Environment
+	var hubClient, memberClient client.Client
+	var cr *controllers.ClusterReconciler
+
+	var reconcile = func() {
+		_, err := cr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: DefaultName},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	BeforeEach(func(done Done) {
+		if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+			Skip("KUBEBUILDER_ASSETS is not set")
+		}
+		ctx = context.Background()
+		hub = &envtest.Environment{
+			CRDDirectoryPaths: []string{filepath.Join("..", "deploy", "crd", "bases")},
+		}
+		hubConfig, err := hub.Start()
+		Expect(err).ToNot(HaveOccurred(), "Starting the hub API server should not have errored.")
+		member = &envtest.Environment{}
+		memberConfig, err := member.Start()
+		Expect(err).ToNot(HaveOccurred(), "Starting the member API server should not have errored.")
+
+		hubClient, err = client.New(hubConfig, client.Options{Scheme: scheme.Scheme})
+		Expect(err).ToNot(HaveOccurred())
+		memberClient, err = client.New(memberConfig, client.Options{Scheme: scheme.Scheme})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(hubClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: ClusterSecretNamespace},
+		})).ToNot(HaveOccurred())
+		Expect(hubClient.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "member",
+				Namespace: ClusterSecretNamespace,
+				Labels:    map[string]string{gialv1beta1.LabelClusterSecret: ""},
+			},
+			Data: map[string][]byte{gialv1beta1.ClusterKubeconfigKey: kubeconfigFor(memberConfig)},
+		})).ToNot(HaveOccurred())
+		Expect(hubClient.Create(ctx, &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Sudoers:    []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: "User", Name: john}},
+				Developers: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: "User", Name: alice}},
+				Clusters:   []string{"member"},
+			},
+		})).ToNot(HaveOccurred())
+
+		cr = &controllers.ClusterReconciler{
+			Client:          hubClient,
+			Log:             logf.Log,
+			Recorder:        record.NewFakeRecorder(64),
+			SecretNamespace: ClusterSecretNamespace,
+		}
+		reconcile()
+		close(done)
+	}, StartupTimeout)
+
+	AfterEach(func(done Done) {
+		if hub != nil {
+			Expect(hub.Stop()).ToNot(HaveOccurred())
+		}
+		if member != nil {
+			Expect(member.Stop()).ToNot(HaveOccurred())
+		}
+		close(done)
+	}, StartupTimeout)
+
+	It("should sync the namespace and its RBAC to the member API server", func(done Done) {
+		cns := &corev1.Namespace{}
+		Expect(memberClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+		Expect(cns.Labels).To(HaveKeyWithValue(gialv1beta1.LabelLNamespace, DefaultName))
+		rb := &rbacv1.RoleBinding{}
+		Expect(memberClient.Get(ctx, types.NamespacedName{Namespace: DefaultName, Name: "developer"}, rb)).ToNot(HaveOccurred())
+		Expect(rb.Subjects).To(HaveLen(1))
+
+		lns := &gialv1beta1.LNamespace{}
+		Expect(hubClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		Expect(lns.Status.Clusters).To(HaveLen(1))
+		Expect(lns.Status.Clusters[0].Synced).To(BeTrue(), lns.Status.Clusters[0].Message)
+		close(done)
+	}, TestTimeout)
+
+	It("should remove the namespace from the member API server once deleted", func(done Done) {
+		lns := &gialv1beta1.LNamespace{}
+		Expect(hubClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		Expect(hubClient.Delete(ctx, lns)).ToNot(HaveOccurred())
+		reconcile()
+
+		// envtest runs no namespace controller, so the namespace stays terminating
+		cns := &corev1.Namespace{}
+		Expect(memberClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+		Expect(cns.DeletionTimestamp).ToNot(BeNil())
+		sgr := &rbacv1.ClusterRole{}
+		err := memberClient.Get(ctx, types.NamespacedName{Name: lns.GetSudoersGroupName()}, sgr)
+		Expect(err).To(HaveOccurred(), "Sudoer group impersonator should be removed.")
+		close(done)
+	}, TestTimeout)
+})
@@ -0,0 +1,382 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/restore"
+)
+
+const (
+	// DefaultSoftDeleteRetention is how long soft deleted namespaces are kept by default
+	DefaultSoftDeleteRetention = 7 * 24 * time.Hour
+)
+
+// DeletionReconciler applies the deletion policy of LNamespaces, soft deleting
+// or retaining their namespaces
+type DeletionReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// SoftDeleteRetention is how long a soft deleted namespace is kept before it is deleted.
+	SoftDeleteRetention time.Duration
+	// RestoreNamespace is the namespace that restored LNamespaces are
+	// recorded in until they are recreated, out of reach of their sudoers.
+	// Soft deleted namespaces cannot be restored without it.
+	RestoreNamespace string
+	// RestoreRecords reads the restore records of RestoreNamespace. Client is
+	// used if nil.
+	RestoreRecords client.Reader
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings;rolebindings,verbs=get;list;watch;update;patch
+
+// The restore records are written through the Role in
+// deploy/rbac/restore_role.yaml, which is bound in the restore namespace only.
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *DeletionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		if err := r.recreate(ctx, log, req.Name); err != nil {
+			log.Error(err, "unable to recreate restored namespace")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without applying the deletion policy.")
+			return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+		}
+	}
+	if ns.DeletionTimestamp.IsZero() {
+		policy := ns.Spec.DeletionPolicy
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, policy == gialv1beta1.DeletionPolicySoftDelete || policy == gialv1beta1.DeletionPolicyRetain)
+	}
+	if ns.Retained() {
+		if err := r.retain(ctx, ns); err != nil {
+			log.Error(err, "unable to detach retained namespace")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Retain", "Detached namespace %s, which is kept after the LNamespace is deleted", ns.Name)
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+	}
+	if !ns.PendingDeletion() {
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+	}
+
+	if ns.Annotations[gialv1beta1.AnnotationRestore] == "true" && r.RestoreNamespace == "" {
+		r.Recorder.Eventf(ns, "Warning", "Restore", "Namespace %s cannot be restored, no restore namespace is configured", ns.Name)
+	} else if ns.Annotations[gialv1beta1.AnnotationRestore] == "true" {
+		if err := r.restore(ctx, ns); err != nil {
+			log.Error(err, "unable to restore namespace")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Restore", "Restoring namespace %s", ns.Name)
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+	}
+
+	retention := r.SoftDeleteRetention
+	if retention == 0 {
+		retention = DefaultSoftDeleteRetention
+	}
+	deleteAt := ns.DeletionTimestamp.Add(retention)
+	if !time.Now().Before(deleteAt) {
+		log.Info("restore window has passed, deleting namespace")
+		if err := r.deleteSoftDeleted(ctx, ns); err != nil {
+			log.Error(err, "unable to delete soft deleted namespace")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Delete", "Restore window of namespace %s has passed, deleting it", ns.Name)
+		return ctrl.Result{}, r.setFinalizer(ctx, ns, false)
+	}
+	// the namespace is detached, so that a foreground deletion of the
+	// LNamespace does not garbage collect it during the restore window
+	if err := r.softDelete(ctx, ns); err != nil {
+		log.Error(err, "unable to detach soft deleted namespace")
+		return ctrl.Result{}, err
+	}
+	if setCondition(&ns.Status.Conditions, metav1.Condition{
+		Type:    gialv1beta1.ConditionPendingDeletion,
+		Status:  metav1.ConditionTrue,
+		Reason:  "SoftDeleted",
+		Message: "namespace is deleted at " + deleteAt.Format(time.RFC3339) + " unless it is restored",
+	}) {
+		r.Recorder.Eventf(ns, "Warning", "PendingDeletion", "Namespace %s is scaled down and deleted at %s. Managers can restore it by setting the %s annotation to true", ns.Name, deleteAt.Format(time.RFC3339), gialv1beta1.AnnotationRestore)
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to update pending deletion condition")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: time.Until(deleteAt)}, nil
+}
+
+// setFinalizer adds or removes the deletion policy finalizer of ns.
+func (r *DeletionReconciler) setFinalizer(ctx context.Context, ns *gialv1beta1.LNamespace, present bool) error {
+	if controllerutil.ContainsFinalizer(ns, gialv1beta1.FinalizerDeletionPolicy) == present {
+		return nil
+	}
+	if present {
+		controllerutil.AddFinalizer(ns, gialv1beta1.FinalizerDeletionPolicy)
+	} else {
+		controllerutil.RemoveFinalizer(ns, gialv1beta1.FinalizerDeletionPolicy)
+	}
+	return r.Update(ctx, ns)
+}
+
+// softDelete detaches the namespace from the soft deleted LNamespace and
+// records where it came from, so that it is only deleted once the restore
+// window has passed.
+func (r *DeletionReconciler) softDelete(ctx context.Context, ns *gialv1beta1.LNamespace) error {
+	cns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ns.Name}, cns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	from := ns.Name + "/" + string(ns.UID)
+	refs := withoutOwner(cns.OwnerReferences, ns.UID)
+	if len(refs) == len(cns.OwnerReferences) && cns.Annotations[gialv1beta1.AnnotationSoftDeletedFrom] == from {
+		return nil
+	}
+	patch := client.MergeFrom(cns.DeepCopy())
+	cns.OwnerReferences = refs
+	if cns.Annotations == nil {
+		cns.Annotations = make(map[string]string)
+	}
+	cns.Annotations[gialv1beta1.AnnotationSoftDeletedFrom] = from
+	return r.Patch(ctx, cns, patch)
+}
+
+// deleteSoftDeleted deletes the namespace detached by softDelete, which is no
+// longer garbage collected with the LNamespace.
+func (r *DeletionReconciler) deleteSoftDeleted(ctx context.Context, ns *gialv1beta1.LNamespace) error {
+	cns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ns.Name}, cns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if cns.Annotations[gialv1beta1.AnnotationSoftDeletedFrom] != ns.Name+"/"+string(ns.UID) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, cns))
+}
+
+// restore detaches the namespace from the soft deleted LNamespace, so that it
+// survives its deletion, and records the LNamespace in RestoreNamespace so
+// that it is recreated once the old one is gone. An expiry that has already passed
+// is cleared, since the namespace would otherwise expire again right away.
+func (r *DeletionReconciler) restore(ctx context.Context, ns *gialv1beta1.LNamespace) error {
+	restored := &gialv1beta1.LNamespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ns.Name,
+			Labels:      ns.Labels,
+			Annotations: make(map[string]string),
+		},
+		Spec: *ns.Spec.DeepCopy(),
+	}
+	for k, v := range ns.Annotations {
+		if k != gialv1beta1.AnnotationRestore {
+			restored.Annotations[k] = v
+		}
+	}
+	if expiresAt, expires := ns.Expiry(); expires && !expiresAt.After(time.Now()) {
+		restored.Spec.ExpiresAt = nil
+		restored.Spec.TTL = nil
+		r.Recorder.Eventf(ns, "Warning", "Restore", "Namespace %s expired at %s, its expiry is cleared on restore", ns.Name, expiresAt.Format(time.RFC3339))
+	}
+	record, err := restore.Record(r.RestoreNamespace, restored)
+	if err != nil {
+		return err
+	}
+	if err := r.Create(ctx, record); apierrors.IsAlreadyExists(err) {
+		existing := &corev1.ConfigMap{}
+		if err := r.restoreRecords().Get(ctx, client.ObjectKeyFromObject(record), existing); err != nil {
+			return err
+		}
+		existing.Data = record.Data
+		if err := r.Update(ctx, existing); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	cns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ns.Name}, cns); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	patch := client.MergeFrom(cns.DeepCopy())
+	cns.OwnerReferences = withoutOwner(cns.OwnerReferences, ns.UID)
+	delete(cns.Annotations, gialv1beta1.AnnotationSoftDeletedFrom)
+	return r.Patch(ctx, cns, patch)
+}
+
+// retain detaches the namespace, its RoleBindings and the cluster-scoped RBAC
+// of ns from it, so that they are not garbage collected with it. The namespace
+// records where it came from, so that it can be re-adopted later.
+func (r *DeletionReconciler) retain(ctx context.Context, ns *gialv1beta1.LNamespace) error {
+	detach := func(o client.Object) error {
+		refs := withoutOwner(o.GetOwnerReferences(), ns.UID)
+		if len(refs) == len(o.GetOwnerReferences()) {
+			return nil
+		}
+		patch := client.MergeFrom(o.DeepCopyObject().(client.Object))
+		o.SetOwnerReferences(refs)
+		return r.Patch(ctx, o, patch)
+	}
+
+	cns := &corev1.Namespace{}
+	err := r.Get(ctx, client.ObjectKey{Name: ns.Name}, cns)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		patch := client.MergeFrom(cns.DeepCopy())
+		cns.OwnerReferences = withoutOwner(cns.OwnerReferences, ns.UID)
+		if cns.Annotations == nil {
+			cns.Annotations = make(map[string]string)
+		}
+		cns.Annotations[gialv1beta1.AnnotationRetainedFrom] = ns.Name + "/" + string(ns.UID)
+		if err := r.Patch(ctx, cns, patch); err != nil {
+			return err
+		}
+	}
+
+	rbl := &rbacv1.RoleBindingList{}
+	if err := r.List(ctx, rbl, client.InNamespace(ns.Name), client.HasLabels{LabelKey}); err != nil {
+		return err
+	}
+	for i := range rbl.Items {
+		if err := detach(&rbl.Items[i]); err != nil {
+			return err
+		}
+	}
+	crbl := &rbacv1.ClusterRoleBindingList{}
+	if err := r.List(ctx, crbl, client.HasLabels{LabelKey}); err != nil {
+		return err
+	}
+	for i := range crbl.Items {
+		if err := detach(&crbl.Items[i]); err != nil {
+			return err
+		}
+	}
+	crl := &rbacv1.ClusterRoleList{}
+	if err := r.List(ctx, crl, client.HasLabels{LabelKey}); err != nil {
+		return err
+	}
+	for i := range crl.Items {
+		if err := detach(&crl.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutOwner returns refs without the references to the owner with uid.
+func withoutOwner(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	var res []metav1.OwnerReference
+	for _, v := range refs {
+		if v.UID != uid {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// recreate creates the LNamespace recorded for a restored namespace, once the
+// soft deleted LNamespace is gone.
+func (r *DeletionReconciler) recreate(ctx context.Context, log logr.Logger, name string) error {
+	if r.RestoreNamespace == "" {
+		return nil
+	}
+	ns, err := restore.Get(ctx, r.restoreRecords(), r.RestoreNamespace, name)
+	if err != nil || ns == nil {
+		return err
+	}
+	cns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, cns); apierrors.IsNotFound(err) {
+		cns = nil
+	} else if err != nil {
+		return err
+	}
+	if err := r.Create(ctx, ns); apierrors.IsAlreadyExists(err) {
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	log.Info("recreated restored namespace")
+	r.Recorder.Eventf(ns, "Normal", "Restore", "Restored namespace %s", ns.Name)
+	if cns != nil {
+		patch := client.MergeFrom(cns.DeepCopy())
+		if err := controllerutil.SetControllerReference(ns, cns, r.Scheme()); err != nil {
+			return err
+		}
+		if err := r.Patch(ctx, cns, patch); err != nil {
+			return err
+		}
+	}
+	record := &corev1.ConfigMap{}
+	record.Namespace = r.RestoreNamespace
+	record.Name = restore.Name(name)
+	return client.IgnoreNotFound(r.Delete(ctx, record))
+}
+
+// restoreRecords returns the reader of the restore records.
+func (r *DeletionReconciler) restoreRecords() client.Reader {
+	if r.RestoreRecords != nil {
+		return r.RestoreRecords
+	}
+	return r.Client
+}
+
+// SetupWithManager sets up the DeletionReconciler with the provided manager
+func (r *DeletionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Complete(r)
+}
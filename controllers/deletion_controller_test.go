@@ -0,0 +1,288 @@
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/pkg/restore"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deletion Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var dr *controllers.DeletionReconciler
+	var recorder *record.FakeRecorder
+	var k8sClient client.Client
+	var result controllerruntime.Result
+
+	var reconcile = func() {
+		var err error
+		result, err = dr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: DefaultName},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	var get = func() *gialv1beta1.LNamespace {
+		lns := &gialv1beta1.LNamespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		return lns
+	}
+
+	// markDeleted marks ns as deleted d ago, as the API server would with the
+	// finalizer in place
+	var markDeleted = func(d time.Duration) {
+		deleted := metav1.NewTime(time.Now().Add(-d))
+		ns.DeletionTimestamp = &deleted
+		ns.Finalizers = []string{gialv1beta1.FinalizerDeletionPolicy}
+	}
+
+	// createNamespace creates the namespace of ns, owned by it
+	var createNamespace = func(annotations map[string]string) {
+		controller := true
+		Expect(k8sClient.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        DefaultName,
+				Annotations: annotations,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: gialv1beta1.GroupVersion.String(),
+					Kind:       "LNamespace",
+					Name:       DefaultName,
+					UID:        ns.UID,
+					Controller: &controller,
+				}},
+			},
+		})).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		dr = &controllers.DeletionReconciler{
+			Client:              k8sClient,
+			Log:                 logf.Log,
+			Recorder:            recorder,
+			SoftDeleteRetention: 24 * time.Hour,
+			RestoreNamespace:    "namespace-controller-system",
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName, UID: "lns-uid"},
+			Spec: gialv1beta1.LNamespaceSpec{
+				DeletionPolicy: gialv1beta1.DeletionPolicySoftDelete,
+				Billing:        map[string]string{"budget": "1.0"},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("adds the deletion policy finalizer to soft deleted namespaces", func(done Done) {
+		Expect(get().Finalizers).To(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+		close(done)
+	}, TestTimeout)
+
+	When("the namespace is deleted right away", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.DeletionPolicy = gialv1beta1.DeletionPolicyDelete
+			ns.Finalizers = []string{gialv1beta1.FinalizerDeletionPolicy}
+			close(done)
+		}, TestTimeout)
+
+		It("removes the deletion policy finalizer", func(done Done) {
+			Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace was soft deleted", func() {
+		BeforeEach(func(done Done) {
+			markDeleted(time.Hour)
+			createNamespace(nil)
+			close(done)
+		}, TestTimeout)
+
+		It("detaches the namespace, so that it is not garbage collected in the meantime", func(done Done) {
+			cns := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+			Expect(cns.OwnerReferences).To(BeEmpty())
+			Expect(cns.Annotations).To(HaveKeyWithValue(gialv1beta1.AnnotationSoftDeletedFrom, DefaultName+"/lns-uid"))
+			close(done)
+		}, TestTimeout)
+
+		It("keeps the namespace until the restore window passes", func(done Done) {
+			lns := get()
+			Expect(lns.Finalizers).To(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+			Expect(meta.IsStatusConditionTrue(lns.Status.Conditions, gialv1beta1.ConditionPendingDeletion)).To(BeTrue())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 23*time.Hour, time.Minute))
+			close(done)
+		}, TestTimeout)
+
+		It("warns about the pending deletion once", func(done Done) {
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("PendingDeletion")))
+			reconcile()
+			Expect(recorder.Events).To(BeEmpty())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the restore window has passed", func() {
+		BeforeEach(func(done Done) {
+			markDeleted(25 * time.Hour)
+			createNamespace(map[string]string{gialv1beta1.AnnotationSoftDeletedFrom: DefaultName + "/lns-uid"})
+			close(done)
+		}, TestTimeout)
+
+		It("removes the finalizer, deleting the namespace", func(done Done) {
+			Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, &corev1.Namespace{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue(), "Detached namespace should be deleted.")
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Delete")))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace is restored", func() {
+		var cns *corev1.Namespace
+
+		BeforeEach(func(done Done) {
+			markDeleted(time.Hour)
+			ns.Annotations = map[string]string{gialv1beta1.AnnotationRestore: "true"}
+			createNamespace(nil)
+			close(done)
+		}, TestTimeout)
+
+		It("detaches the namespace from the deleted LNamespace", func(done Done) {
+			cns = &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+			Expect(cns.OwnerReferences).To(BeEmpty())
+			Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+			close(done)
+		}, TestTimeout)
+
+		It("records the LNamespace in the restore namespace", func(done Done) {
+			recorded, err := restore.Get(ctx, k8sClient, "namespace-controller-system", DefaultName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recorded).ToNot(BeNil())
+			Expect(recorded.Spec.Billing).To(HaveKeyWithValue("budget", "1.0"))
+			close(done)
+		}, TestTimeout)
+
+		It("ignores a record written on the namespace", func(done Done) {
+			cns = &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+			cns.Annotations = map[string]string{"gial.lblw.dev/restore-spec": `{"metadata":{"name":"` + DefaultName + `"},"spec":{"clusterSelector":{}}}`}
+			Expect(k8sClient.Update(ctx, cns)).ToNot(HaveOccurred())
+			Expect(k8sClient.Delete(ctx, get())).ToNot(HaveOccurred())
+			reconcile()
+			Expect(get().Spec.ClusterSelector).To(BeNil())
+			close(done)
+		}, TestTimeout)
+
+		It("recreates the LNamespace once the deleted one is gone", func(done Done) {
+			Expect(k8sClient.Delete(ctx, get())).ToNot(HaveOccurred())
+			reconcile()
+			lns := get()
+			Expect(lns.DeletionTimestamp).To(BeNil())
+			Expect(lns.Spec.Billing).To(HaveKeyWithValue("budget", "1.0"))
+			Expect(lns.Annotations).ToNot(HaveKey(gialv1beta1.AnnotationRestore))
+			cns = &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+			Expect(cns.OwnerReferences).To(HaveLen(1), "Namespace should be adopted by the recreated LNamespace.")
+			Expect(cns.OwnerReferences[0].UID).To(Equal(lns.UID))
+			recorded, err := restore.Get(ctx, k8sClient, "namespace-controller-system", DefaultName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recorded).To(BeNil(), "Restore record should be deleted once the LNamespace is recreated.")
+			close(done)
+		}, TestTimeout)
+
+		Context("after it has expired", func() {
+			BeforeEach(func(done Done) {
+				expired := metav1.NewTime(time.Now().Add(-time.Minute))
+				ns.Spec.ExpiresAt = &expired
+				close(done)
+			}, TestTimeout)
+
+			It("clears the expiry of the recreated LNamespace", func(done Done) {
+				Expect(k8sClient.Delete(ctx, get())).ToNot(HaveOccurred())
+				reconcile()
+				Expect(get().Spec.ExpiresAt).To(BeNil())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the namespace is retained", func() {
+		var owner []metav1.OwnerReference
+
+		BeforeEach(func(done Done) {
+			ns.Spec.DeletionPolicy = gialv1beta1.DeletionPolicyRetain
+			markDeleted(0)
+			controller := true
+			owner = []metav1.OwnerReference{{
+				APIVersion: gialv1beta1.GroupVersion.String(),
+				Kind:       "LNamespace",
+				Name:       DefaultName,
+				UID:        ns.UID,
+				Controller: &controller,
+			}}
+			labels := map[string]string{controllers.LabelKey: controllers.LabelDeveloperPermissions}
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName, OwnerReferences: owner},
+			})).ToNot(HaveOccurred())
+			Expect(k8sClient.Create(ctx, &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: "developer", Namespace: DefaultName, Labels: labels, OwnerReferences: owner},
+			})).ToNot(HaveOccurred())
+			Expect(k8sClient.Create(ctx, &rbacv1.ClusterRoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName + "-manager", Labels: labels, OwnerReferences: owner},
+			})).ToNot(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+
+		It("detaches the namespace and records where it came from", func(done Done) {
+			cns := &corev1.Namespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, cns)).ToNot(HaveOccurred())
+			Expect(cns.OwnerReferences).To(BeEmpty())
+			Expect(cns.Annotations).To(HaveKeyWithValue(gialv1beta1.AnnotationRetainedFrom, DefaultName+"/lns-uid"))
+			close(done)
+		}, TestTimeout)
+
+		It("detaches the RBAC of the namespace", func(done Done) {
+			rb := &rbacv1.RoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "developer", Namespace: DefaultName}, rb)).ToNot(HaveOccurred())
+			Expect(rb.OwnerReferences).To(BeEmpty())
+			crb := &rbacv1.ClusterRoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName + "-manager"}, crb)).ToNot(HaveOccurred())
+			Expect(crb.OwnerReferences).To(BeEmpty())
+			close(done)
+		}, TestTimeout)
+
+		It("removes the finalizer", func(done Done) {
+			Expect(get().Finalizers).ToNot(ContainElement(gialv1beta1.FinalizerDeletionPolicy))
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Retain")))
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -0,0 +1,150 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+)
+
+// DefaultExpiryWarnings are how long before its expiry a namespace is warned about by default
+var DefaultExpiryWarnings = []time.Duration{72 * time.Hour, 24 * time.Hour, time.Hour}
+
+// ExpiryReconciler deletes LNamespaces once they expire, warning about the
+// upcoming expiry beforehand
+type ExpiryReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// Warnings are how long before its expiry a warning event is emitted for a namespace.
+	Warnings []time.Duration
+	// Notifier, if set, is notified alongside every warning event, so that
+	// managers hear about the expiry without watching events.
+	Notifier expiry.Notifier
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *ExpiryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	if !ns.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	expiresAt, ok := ns.Expiry()
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	now := time.Now()
+	remaining := expiresAt.Sub(now)
+	if remaining <= 0 {
+		if ns.Spec.Protected() {
+			log.Info("namespace expired, but is protected from deletion", "expiry", expiresAt)
+			r.Recorder.Eventf(ns, "Warning", "ExpiryBlocked", "Namespace %s expired at %s, but is protected from deletion", ns.Name, expiresAt.Format(time.RFC3339))
+			return ctrl.Result{}, nil
+		}
+		log.Info("namespace expired, deleting", "expiry", expiresAt)
+		if err := r.Delete(ctx, ns); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete expired namespace")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Expired", "Deleted namespace %s, which expired at %s", ns.Name, expiresAt.Format(time.RFC3339))
+		return ctrl.Result{}, nil
+	}
+
+	warnings := r.Warnings
+	if warnings == nil {
+		warnings = DefaultExpiryWarnings
+	}
+	// window is the shortest warning that is due, next is the time until the
+	// next warning falls due, or until the expiry
+	var window, next time.Duration
+	next = remaining
+	for _, v := range warnings {
+		if remaining <= v && (window == 0 || v < window) {
+			window = v
+		}
+		if remaining > v && remaining-v < next {
+			next = remaining - v
+		}
+	}
+	last := ns.Status.LastExpiryWarning
+	// LastExpiryWarning is stored with second precision
+	if window > 0 && (last == nil || last.Time.Before(expiresAt.Add(-window).Truncate(time.Second))) {
+		message := fmt.Sprintf("Namespace %s expires at %s and will be deleted with everything in it. Managers can extend it through spec.expiresAt or spec.ttl", ns.Name, expiresAt.Format(time.RFC3339))
+		if r.Notifier != nil {
+			// notify before recording the warning, so that a failed
+			// notification is retried
+			if err := r.Notifier.Notify(ctx, expiry.Notification{
+				Namespace: ns.Name,
+				ExpiresAt: expiresAt,
+				Message:   message,
+				Managers:  ns.Spec.Managers,
+				Sudoers:   ns.Spec.Sudoers,
+			}); err != nil {
+				log.Error(err, "unable to send expiry notification")
+				r.Recorder.Eventf(ns, "Warning", "NotificationFailed", "Unable to send expiry notification: %v", err)
+				return ctrl.Result{}, err
+			}
+		}
+		r.Recorder.Event(ns, "Warning", "ExpiringSoon", message)
+		warned := metav1.NewTime(now)
+		ns.Status.LastExpiryWarning = &warned
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to record expiry warning")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: next}, nil
+}
+
+// SetupWithManager sets up the ExpiryReconciler with the provided manager
+func (r *ExpiryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Complete(r)
+}
@@ -0,0 +1,187 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+	. "github.com/onsi/gomega"
+)
+
+type fakeNotifier struct {
+	notifications []expiry.Notification
+	err           error
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n expiry.Notification) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.notifications = append(f.notifications, n)
+	return nil
+}
+
+var _ = Describe("Expiry Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var er *controllers.ExpiryReconciler
+	var recorder *record.FakeRecorder
+	var notifier *fakeNotifier
+	var k8sClient client.Client
+	var result controllerruntime.Result
+
+	var reconcile = func() {
+		var err error
+		result, err = er.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: DefaultName},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	var fromNow = func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(time.Now().Add(d))
+		return &t
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		notifier = &fakeNotifier{}
+		er = &controllers.ExpiryReconciler{
+			Client:   k8sClient,
+			Log:      logf.Log,
+			Recorder: recorder,
+			Warnings: []time.Duration{24 * time.Hour, time.Hour},
+			Notifier: notifier,
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Managers: []rbacv1.Subject{{Kind: "User", Name: john}},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	When("the namespace does not expire", func() {
+		It("leaves it alone", func(done Done) {
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(recorder.Events).To(BeEmpty())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace expires later", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.ExpiresAt = fromNow(48 * time.Hour)
+			close(done)
+		}, TestTimeout)
+
+		It("requeues when the first warning is due", func(done Done) {
+			Expect(recorder.Events).To(BeEmpty())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 24*time.Hour, time.Minute))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace expires soon", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.ExpiresAt = fromNow(12 * time.Hour)
+			close(done)
+		}, TestTimeout)
+
+		It("warns about the expiry once", func(done Done) {
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("ExpiringSoon")))
+			reconcile()
+			Expect(recorder.Events).To(BeEmpty())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 11*time.Hour, time.Minute))
+			close(done)
+		}, TestTimeout)
+
+		It("notifies the managers once", func(done Done) {
+			reconcile()
+			Expect(notifier.notifications).To(HaveLen(1))
+			Expect(notifier.notifications[0].Namespace).To(Equal(DefaultName))
+			Expect(notifier.notifications[0].ExpiresAt).To(BeTemporally("~", ns.Spec.ExpiresAt.Time, time.Second))
+			Expect(notifier.notifications[0].Managers).To(ConsistOf(rbacv1.Subject{Kind: "User", Name: john}))
+			close(done)
+		}, TestTimeout)
+
+		It("retries a failed notification", func(done Done) {
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, ns)).ToNot(HaveOccurred())
+			ns.Status.LastExpiryWarning = nil
+			Expect(k8sClient.Status().Update(ctx, ns)).ToNot(HaveOccurred())
+			notifier.err = errors.New("unavailable")
+			_, err := er.Reconcile(ctx, controllerruntime.Request{
+				NamespacedName: types.NamespacedName{Name: DefaultName},
+			})
+			Expect(err).To(HaveOccurred())
+			notifier.err = nil
+			reconcile()
+			Expect(notifier.notifications).To(HaveLen(2))
+			close(done)
+		}, TestTimeout)
+
+		It("warns again once the next warning is due", func(done Done) {
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("ExpiringSoon")))
+			// the namespace was warned 11h ago and now expires in 30m
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, ns)).ToNot(HaveOccurred())
+			ns.Spec.ExpiresAt = fromNow(30 * time.Minute)
+			Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+			ns.Status.LastExpiryWarning = fromNow(-11 * time.Hour)
+			Expect(k8sClient.Status().Update(ctx, ns)).ToNot(HaveOccurred())
+			reconcile()
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("ExpiringSoon")))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace has expired", func() {
+		BeforeEach(func(done Done) {
+			ns.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+			ns.Spec.TTL = &metav1.Duration{Duration: time.Hour}
+			close(done)
+		}, TestTimeout)
+
+		It("deletes the namespace", func(done Done) {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, &gialv1beta1.LNamespace{})
+			Expect(err).To(HaveOccurred())
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Expired")))
+			close(done)
+		}, TestTimeout)
+
+		Context("and is protected from deletion", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Environment = gialv1beta1.EnvironmentProd
+				close(done)
+			}, TestTimeout)
+
+			It("keeps the namespace", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, &gialv1beta1.LNamespace{})).ToNot(HaveOccurred())
+				Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("ExpiryBlocked")))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+})
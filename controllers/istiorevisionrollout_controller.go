@@ -0,0 +1,464 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+)
+
+const (
+	// LabelIstioRevisionRollout marks the LNamespaces moved by an IstioRevisionRollout with its name
+	LabelIstioRevisionRollout = gialv1beta1.LabelIstioRevisionRollout
+	// AnnotationRestartedAt is the pod template annotation used by `kubectl rollout restart`
+	AnnotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+	// DefaultRolloutBatchSize is the number of LNamespaces moved at a time when the rollout does not set one
+	DefaultRolloutBatchSize = 10
+	// DefaultProgressDeadlineSeconds is how long a batch may take to become available when the rollout does not set it
+	DefaultProgressDeadlineSeconds = 600
+
+	rolloutPollInterval = 10 * time.Second
+)
+
+// IstioRevisionRolloutReconciler reconciles an IstioRevisionRollout object
+type IstioRevisionRolloutReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=istiorevisionrollouts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=istiorevisionrollouts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=namespaces;events,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;update;patch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *IstioRevisionRolloutReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("rollout", req.Name)
+
+	rollout := &gialv1beta1.IstioRevisionRollout{}
+	err := r.Get(ctx, req.NamespacedName, rollout)
+	if apierrors.IsNotFound(err) {
+		log.Info("rollout not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get rollout definition")
+		return ctrl.Result{}, err
+	}
+
+	switch rollout.Status.Phase {
+	case gialv1beta1.RolloutCompleted, gialv1beta1.RolloutRolledBack, gialv1beta1.RolloutFailed:
+		return ctrl.Result{}, nil
+	case gialv1beta1.RolloutRollingBack:
+		return r.rollback(ctx, log, rollout)
+	case "":
+		return r.start(ctx, log, rollout)
+	}
+	if len(rollout.Status.CurrentBatch) > 0 {
+		return r.progressBatch(ctx, log, rollout)
+	}
+	if rollout.Spec.Paused {
+		if rollout.Status.Phase != gialv1beta1.RolloutPaused {
+			rollout.Status.Phase = gialv1beta1.RolloutPaused
+			rollout.Status.Message = "rollout is paused"
+			r.Recorder.Event(rollout, "Normal", "Paused", "Paused rollout")
+			return ctrl.Result{}, r.updateStatus(ctx, log, rollout)
+		}
+		return ctrl.Result{}, nil
+	}
+	return r.startBatch(ctx, log, rollout)
+}
+
+// start verifies that the new revision is installed and records the number of
+// LNamespaces to move.
+func (r *IstioRevisionRolloutReconciler) start(ctx context.Context, log logr.Logger, rollout *gialv1beta1.IstioRevisionRollout) (ctrl.Result, error) {
+	if rollout.Spec.From == rollout.Spec.To {
+		rollout.Status.Phase = gialv1beta1.RolloutFailed
+		rollout.Status.Message = fmt.Sprintf("spec.from and spec.to are both %s, nothing to roll out", rollout.Spec.To)
+		r.Recorder.Event(rollout, "Warning", "InvalidSpec", rollout.Status.Message)
+		return ctrl.Result{}, r.updateStatus(ctx, log, rollout)
+	}
+	revisions, err := istio.Revisions(ctx, r)
+	if err != nil {
+		log.Error(err, "unable to list istio revisions")
+		return ctrl.Result{}, err
+	}
+	if !istio.Contains(revisions, rollout.Spec.To) {
+		rollout.Status.Phase = gialv1beta1.RolloutFailed
+		rollout.Status.Message = fmt.Sprintf("istio revision %s is not installed, valid revisions: %s", rollout.Spec.To, strings.Join(revisions, ", "))
+		r.Recorder.Event(rollout, "Warning", "RevisionNotInstalled", rollout.Status.Message)
+		return ctrl.Result{}, r.updateStatus(ctx, log, rollout)
+	}
+	candidates, err := r.candidates(ctx, rollout)
+	if err != nil {
+		log.Error(err, "unable to list namespaces to roll out")
+		return ctrl.Result{}, err
+	}
+	rollout.Status.Phase = gialv1beta1.RolloutProgressing
+	rollout.Status.Message = ""
+	rollout.Status.Total = int32(len(candidates))
+	return ctrl.Result{Requeue: true}, r.updateStatus(ctx, log, rollout)
+}
+
+// candidates returns the selected LNamespaces that are still on the old
+// revision, sorted by name. LNamespaces that pin their revision through
+// NamespaceLabelOverrides or that are not in sidecar mode are left alone.
+func (r *IstioRevisionRolloutReconciler) candidates(ctx context.Context, rollout *gialv1beta1.IstioRevisionRollout) ([]gialv1beta1.LNamespace, error) {
+	selector := labels.Everything()
+	if rollout.Spec.Selector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(rollout.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(ctx, l, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var candidates []gialv1beta1.LNamespace
+	for _, v := range l.Items {
+		if _, pinned := v.Spec.NamespaceLabelOverrides[IstioTag]; pinned || v.Spec.MeshMode() != gialv1beta1.MeshSidecar {
+			continue
+		}
+		if v.Spec.IstioRevision == rollout.Spec.From && v.DeletionTimestamp == nil {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	return candidates, nil
+}
+
+// startBatch moves the next batch of LNamespaces to the new revision, or
+// completes the rollout when none are left.
+func (r *IstioRevisionRolloutReconciler) startBatch(ctx context.Context, log logr.Logger, rollout *gialv1beta1.IstioRevisionRollout) (ctrl.Result, error) {
+	candidates, err := r.candidates(ctx, rollout)
+	if err != nil {
+		log.Error(err, "unable to list namespaces to roll out")
+		return ctrl.Result{}, err
+	}
+	if len(candidates) == 0 {
+		if err := r.unlabel(ctx, rollout); err != nil {
+			log.Error(err, "unable to unlabel namespaces moved by rollout")
+			return ctrl.Result{}, err
+		}
+		rollout.Status.Phase = gialv1beta1.RolloutCompleted
+		rollout.Status.Message = fmt.Sprintf("moved %d namespaces to %s", rollout.Status.Updated, rollout.Spec.To)
+		r.Recorder.Event(rollout, "Normal", "Completed", rollout.Status.Message)
+		return ctrl.Result{}, r.updateStatus(ctx, log, rollout)
+	}
+	batchSize := int(rollout.Spec.BatchSize)
+	if batchSize <= 0 {
+		batchSize = DefaultRolloutBatchSize
+	}
+	if len(candidates) > batchSize {
+		candidates = candidates[:batchSize]
+	}
+
+	batch := make([]string, 0, len(candidates))
+	for _, v := range candidates {
+		v := v
+		if v.Labels == nil {
+			v.Labels = make(map[string]string)
+		}
+		v.Labels[LabelIstioRevisionRollout] = rollout.Name
+		v.Spec.IstioRevision = rollout.Spec.To
+		if err := r.Update(ctx, &v); err != nil {
+			log.Error(err, "unable to move namespace to new revision", "namespace", v.Name)
+			return ctrl.Result{}, err
+		}
+		batch = append(batch, v.Name)
+	}
+	now := metav1.Now()
+	rollout.Status.Phase = gialv1beta1.RolloutProgressing
+	rollout.Status.Message = ""
+	rollout.Status.CurrentBatch = batch
+	rollout.Status.BatchStartTime = &now
+	rollout.Status.WorkloadsRestarted = false
+	r.Recorder.Eventf(rollout, "Normal", "BatchStarted", "Moved %s to %s", strings.Join(batch, ", "), rollout.Spec.To)
+	return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.updateStatus(ctx, log, rollout)
+}
+
+// progressBatch waits for the batch in progress to be relabelled, restarts its
+// workloads and gates the next batch on their availability. The rollout is
+// rolled back when the batch misses its deadline.
+func (r *IstioRevisionRolloutReconciler) progressBatch(ctx context.Context, log logr.Logger, rollout *gialv1beta1.IstioRevisionRollout) (ctrl.Result, error) {
+	batch := rollout.Status.CurrentBatch
+	labelled, err := r.labelled(ctx, batch, rollout.Spec.To)
+	if err != nil {
+		log.Error(err, "unable to get namespaces of batch")
+		return ctrl.Result{}, err
+	}
+	if labelled && rollout.Spec.RestartWorkloads && !rollout.Status.WorkloadsRestarted {
+		for _, v := range batch {
+			if err := r.restartWorkloads(ctx, v); err != nil {
+				log.Error(err, "unable to restart workloads", "namespace", v)
+				return ctrl.Result{}, err
+			}
+		}
+		rollout.Status.WorkloadsRestarted = true
+		r.Recorder.Eventf(rollout, "Normal", "Restarted", "Restarted workloads in %s", strings.Join(batch, ", "))
+		return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.updateStatus(ctx, log, rollout)
+	}
+
+	unhealthy := ""
+	if !labelled {
+		unhealthy = "namespaces are not yet relabelled"
+	} else {
+		for _, v := range batch {
+			unhealthy, err = r.unhealthyWorkload(ctx, v)
+			if err != nil {
+				log.Error(err, "unable to check workloads", "namespace", v)
+				return ctrl.Result{}, err
+			}
+			if unhealthy != "" {
+				break
+			}
+		}
+	}
+	if unhealthy == "" {
+		rollout.Status.Updated += int32(len(batch))
+		rollout.Status.CurrentBatch = nil
+		rollout.Status.BatchStartTime = nil
+		rollout.Status.WorkloadsRestarted = false
+		rollout.Status.Message = ""
+		r.Recorder.Eventf(rollout, "Normal", "BatchCompleted", "Batch %s is available on %s", strings.Join(batch, ", "), rollout.Spec.To)
+		return ctrl.Result{Requeue: true}, r.updateStatus(ctx, log, rollout)
+	}
+
+	deadline := rollout.Spec.ProgressDeadlineSeconds
+	if deadline <= 0 {
+		deadline = DefaultProgressDeadlineSeconds
+	}
+	if rollout.Status.BatchStartTime != nil && time.Since(rollout.Status.BatchStartTime.Time) > time.Duration(deadline)*time.Second {
+		rollout.Status.Phase = gialv1beta1.RolloutRollingBack
+		rollout.Status.Message = fmt.Sprintf("batch failed its health gate: %s", unhealthy)
+		r.Recorder.Event(rollout, "Warning", "RollingBack", rollout.Status.Message)
+		return ctrl.Result{Requeue: true}, r.updateStatus(ctx, log, rollout)
+	}
+	if rollout.Status.Message != unhealthy {
+		rollout.Status.Message = unhealthy
+		return ctrl.Result{RequeueAfter: rolloutPollInterval}, r.updateStatus(ctx, log, rollout)
+	}
+	return ctrl.Result{RequeueAfter: rolloutPollInterval}, nil
+}
+
+// rollback returns every LNamespace moved by the rollout to the old revision.
+func (r *IstioRevisionRolloutReconciler) rollback(ctx context.Context, log logr.Logger, rollout *gialv1beta1.IstioRevisionRollout) (ctrl.Result, error) {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(ctx, l, client.MatchingLabels{LabelIstioRevisionRollout: rollout.Name}); err != nil {
+		log.Error(err, "unable to list namespaces moved by rollout")
+		return ctrl.Result{}, err
+	}
+	moved := make([]string, 0, len(l.Items))
+	for i := range l.Items {
+		v := &l.Items[i]
+		moved = append(moved, v.Name)
+		if v.Spec.IstioRevision == rollout.Spec.From {
+			continue
+		}
+		v.Spec.IstioRevision = rollout.Spec.From
+		if err := r.Update(ctx, v); err != nil {
+			log.Error(err, "unable to move namespace back to old revision", "namespace", v.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	labelled, err := r.labelled(ctx, moved, rollout.Spec.From)
+	if err != nil {
+		log.Error(err, "unable to get namespaces moved by rollout")
+		return ctrl.Result{}, err
+	}
+	if !labelled {
+		return ctrl.Result{RequeueAfter: rolloutPollInterval}, nil
+	}
+	for i := range l.Items {
+		v := &l.Items[i]
+		if rollout.Spec.RestartWorkloads {
+			if err := r.restartWorkloads(ctx, v.Name); err != nil {
+				log.Error(err, "unable to restart workloads", "namespace", v.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		delete(v.Labels, LabelIstioRevisionRollout)
+		if err := r.Update(ctx, v); err != nil {
+			log.Error(err, "unable to unlabel namespace", "namespace", v.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	rollout.Status.Phase = gialv1beta1.RolloutRolledBack
+	rollout.Status.Updated = 0
+	rollout.Status.CurrentBatch = nil
+	rollout.Status.BatchStartTime = nil
+	r.Recorder.Eventf(rollout, "Warning", "RolledBack", "Moved %d namespaces back to %s", len(moved), rollout.Spec.From)
+	return ctrl.Result{}, r.updateStatus(ctx, log, rollout)
+}
+
+// unlabel removes the rollout label from the LNamespaces moved by the
+// rollout, once it no longer needs them for a rollback.
+func (r *IstioRevisionRolloutReconciler) unlabel(ctx context.Context, rollout *gialv1beta1.IstioRevisionRollout) error {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(ctx, l, client.MatchingLabels{LabelIstioRevisionRollout: rollout.Name}); err != nil {
+		return err
+	}
+	for i := range l.Items {
+		v := &l.Items[i]
+		delete(v.Labels, LabelIstioRevisionRollout)
+		if err := r.Update(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelled returns true once every namespace carries the revision label.
+// Namespaces that no longer exist are ignored.
+func (r *IstioRevisionRolloutReconciler) labelled(ctx context.Context, names []string, revision string) (bool, error) {
+	for _, v := range names {
+		cns := &corev1.Namespace{}
+		err := r.Get(ctx, client.ObjectKey{Name: v}, cns)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if cns.Labels[IstioTag] != revision {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// restartWorkloads triggers a rollout restart of the Deployments, StatefulSets
+// and DaemonSets in the namespace.
+func (r *IstioRevisionRolloutReconciler) restartWorkloads(ctx context.Context, namespace string) error {
+	restartedAt := time.Now().Format(time.RFC3339)
+	restart := func(o client.Object, template *corev1.PodTemplateSpec) error {
+		patch := client.MergeFrom(o.DeepCopyObject().(client.Object))
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[AnnotationRestartedAt] = restartedAt
+		return r.Patch(ctx, o, patch)
+	}
+
+	dl := &appsv1.DeploymentList{}
+	if err := r.List(ctx, dl, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	for i := range dl.Items {
+		if err := restart(&dl.Items[i], &dl.Items[i].Spec.Template); err != nil {
+			return err
+		}
+	}
+	ssl := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, ssl, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	for i := range ssl.Items {
+		if err := restart(&ssl.Items[i], &ssl.Items[i].Spec.Template); err != nil {
+			return err
+		}
+	}
+	dsl := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, dsl, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	for i := range dsl.Items {
+		if err := restart(&dsl.Items[i], &dsl.Items[i].Spec.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unhealthyWorkload describes the first workload in the namespace that is not
+// fully updated and available, or returns an empty string if all of them are.
+func (r *IstioRevisionRolloutReconciler) unhealthyWorkload(ctx context.Context, namespace string) (string, error) {
+	dl := &appsv1.DeploymentList{}
+	if err := r.List(ctx, dl, client.InNamespace(namespace)); err != nil {
+		return "", err
+	}
+	for _, v := range dl.Items {
+		replicas := int32(1)
+		if v.Spec.Replicas != nil {
+			replicas = *v.Spec.Replicas
+		}
+		if v.Status.ObservedGeneration < v.Generation || v.Status.UpdatedReplicas != replicas || v.Status.AvailableReplicas != replicas {
+			return fmt.Sprintf("deployment %s/%s is not available", namespace, v.Name), nil
+		}
+	}
+	ssl := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, ssl, client.InNamespace(namespace)); err != nil {
+		return "", err
+	}
+	for _, v := range ssl.Items {
+		replicas := int32(1)
+		if v.Spec.Replicas != nil {
+			replicas = *v.Spec.Replicas
+		}
+		if v.Status.ObservedGeneration < v.Generation || v.Status.UpdatedReplicas != replicas || v.Status.ReadyReplicas != replicas {
+			return fmt.Sprintf("statefulset %s/%s is not ready", namespace, v.Name), nil
+		}
+	}
+	dsl := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, dsl, client.InNamespace(namespace)); err != nil {
+		return "", err
+	}
+	for _, v := range dsl.Items {
+		desired := v.Status.DesiredNumberScheduled
+		if v.Status.ObservedGeneration < v.Generation || v.Status.UpdatedNumberScheduled != desired || v.Status.NumberAvailable != desired {
+			return fmt.Sprintf("daemonset %s/%s is not available", namespace, v.Name), nil
+		}
+	}
+	return "", nil
+}
+
+func (r *IstioRevisionRolloutReconciler) updateStatus(ctx context.Context, log logr.Logger, rollout *gialv1beta1.IstioRevisionRollout) error {
+	if err := r.Status().Update(ctx, rollout); err != nil {
+		log.Error(err, "unable to update rollout status")
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the IstioRevisionRolloutReconciler with the provided manager
+func (r *IstioRevisionRolloutReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.IstioRevisionRollout{}).
+		Complete(r)
+}
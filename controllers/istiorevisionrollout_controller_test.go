@@ -0,0 +1,242 @@
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IstioRevisionRollout Controller", func() {
+	var ctx context.Context
+	var rollout *gialv1beta1.IstioRevisionRollout
+	var irr *controllers.IstioRevisionRolloutReconciler
+	var nsr *controllers.NamespaceReconciler
+	var k8sClient client.Client
+	var names = []string{"tenant-a", "tenant-b", "tenant-c"}
+
+	// reconcile reconciles the rollout followed by every LNamespace, the way
+	// the NamespaceReconciler would pick up the new revisions.
+	var reconcile = func() {
+		_, err := irr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: rollout.Name},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling rollout should not have errored.")
+		for _, v := range names {
+			_, err := nsr.Reconcile(ctx, controllerruntime.Request{
+				NamespacedName: types.NamespacedName{Name: v},
+			})
+			Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+		}
+		key := types.NamespacedName{Name: rollout.Name}
+		rollout = &gialv1beta1.IstioRevisionRollout{}
+		Expect(k8sClient.Get(ctx, key, rollout)).ToNot(HaveOccurred())
+	}
+
+	var revisionOf = func(name string) string {
+		ns := &gialv1beta1.LNamespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, ns)).ToNot(HaveOccurred())
+		return ns.Spec.IstioRevision
+	}
+
+	var deployment = func(namespace string, available int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app",
+				Namespace: namespace,
+			},
+			Status: appsv1.DeploymentStatus{
+				UpdatedReplicas:   1,
+				AvailableReplicas: available,
+			},
+		}
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		irr = &controllers.IstioRevisionRolloutReconciler{
+			Client:   k8sClient,
+			Log:      logf.Log,
+			Recorder: record.NewFakeRecorder(64),
+		}
+		nsr = &controllers.NamespaceReconciler{
+			Client:   k8sClient,
+			Log:      logf.Log,
+			Recorder: record.NewFakeRecorder(64),
+		}
+		Expect(k8sClient.Create(ctx, &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "istio-sidecar-injector-1-9",
+				Labels: map[string]string{controllers.IstioTag: "1-9"},
+			},
+		})).ToNot(HaveOccurred())
+		for _, v := range names {
+			Expect(k8sClient.Create(ctx, &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: v},
+				Spec:       gialv1beta1.LNamespaceSpec{IstioRevision: "1-8"},
+			})).ToNot(HaveOccurred())
+		}
+		rollout = &gialv1beta1.IstioRevisionRollout{
+			ObjectMeta: metav1.ObjectMeta{Name: "to-1-9"},
+			Spec: gialv1beta1.IstioRevisionRolloutSpec{
+				From:      "1-8",
+				To:        "1-9",
+				BatchSize: 2,
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, rollout)).ToNot(HaveOccurred(), "Creating rollout should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("counts the namespaces to move", func(done Done) {
+		Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutProgressing))
+		Expect(rollout.Status.Total).To(BeEquivalentTo(3))
+		close(done)
+	}, TestTimeout)
+
+	It("moves namespaces in batches until all of them are on the new revision", func(done Done) {
+		reconcile()
+		Expect(rollout.Status.CurrentBatch).To(Equal([]string{"tenant-a", "tenant-b"}))
+		Expect(revisionOf("tenant-a")).To(Equal("1-9"))
+		Expect(revisionOf("tenant-c")).To(Equal("1-8"))
+
+		reconcile()
+		Expect(rollout.Status.Updated).To(BeEquivalentTo(2))
+		Expect(rollout.Status.CurrentBatch).To(BeEmpty())
+
+		reconcile()
+		Expect(rollout.Status.CurrentBatch).To(Equal([]string{"tenant-c"}))
+		reconcile()
+		reconcile()
+		Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutCompleted))
+		Expect(rollout.Status.Updated).To(BeEquivalentTo(3))
+		Expect(revisionOf("tenant-c")).To(Equal("1-9"))
+		l := &gialv1beta1.LNamespaceList{}
+		Expect(k8sClient.List(ctx, l, client.HasLabels{controllers.LabelIstioRevisionRollout})).ToNot(HaveOccurred())
+		Expect(l.Items).To(BeEmpty(), "Rollout label should be removed once completed.")
+		close(done)
+	}, TestTimeout)
+
+	When("the new revision is not installed", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.To = "1-10"
+			close(done)
+		}, TestTimeout)
+
+		It("fails without moving any namespace", func(done Done) {
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutFailed))
+			Expect(rollout.Status.Message).To(ContainSubstring("valid revisions: 1-9"))
+			Expect(revisionOf("tenant-a")).To(Equal("1-8"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the rollout moves namespaces to the revision they are on", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.To = rollout.Spec.From
+			close(done)
+		}, TestTimeout)
+
+		It("fails without moving any namespace", func(done Done) {
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutFailed))
+			Expect(rollout.Status.Message).To(ContainSubstring("nothing to roll out"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the rollout is paused", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.Paused = true
+			close(done)
+		}, TestTimeout)
+
+		It("does not start a batch", func(done Done) {
+			reconcile()
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutPaused))
+			Expect(revisionOf("tenant-a")).To(Equal("1-8"))
+			close(done)
+		}, TestTimeout)
+
+		It("continues once resumed", func(done Done) {
+			reconcile()
+			rollout.Spec.Paused = false
+			Expect(k8sClient.Update(ctx, rollout)).ToNot(HaveOccurred())
+			reconcile()
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutProgressing))
+			Expect(revisionOf("tenant-a")).To(Equal("1-9"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("workloads are restarted", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.RestartWorkloads = true
+			Expect(k8sClient.Create(ctx, deployment("tenant-a", 1))).ToNot(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+
+		It("restarts the workloads once the namespaces are relabelled", func(done Done) {
+			reconcile()
+			reconcile()
+			Expect(rollout.Status.WorkloadsRestarted).To(BeTrue())
+			d := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "app", Namespace: "tenant-a"}, d)).ToNot(HaveOccurred())
+			Expect(d.Spec.Template.Annotations).To(HaveKey(controllers.AnnotationRestartedAt))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("a batch fails its health gate", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.ProgressDeadlineSeconds = 60
+			Expect(k8sClient.Create(ctx, deployment("tenant-a", 0))).ToNot(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+
+		It("waits for the workloads until the deadline", func(done Done) {
+			reconcile()
+			reconcile()
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutProgressing))
+			Expect(rollout.Status.Message).To(ContainSubstring("deployment tenant-a/app is not available"))
+			close(done)
+		}, TestTimeout)
+
+		It("rolls back every namespace after the deadline", func(done Done) {
+			reconcile()
+			started := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+			rollout.Status.BatchStartTime = &started
+			Expect(k8sClient.Status().Update(ctx, rollout)).ToNot(HaveOccurred())
+			reconcile()
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutRollingBack))
+			reconcile()
+			reconcile()
+			Expect(rollout.Status.Phase).To(Equal(gialv1beta1.RolloutRolledBack))
+			Expect(revisionOf("tenant-a")).To(Equal("1-8"))
+			Expect(revisionOf("tenant-b")).To(Equal("1-8"))
+			l := &gialv1beta1.LNamespaceList{}
+			Expect(k8sClient.List(ctx, l, client.HasLabels{controllers.LabelIstioRevisionRollout})).ToNot(HaveOccurred())
+			Expect(l.Items).To(BeEmpty(), "Rollout label should be removed once rolled back.")
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -0,0 +1,142 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+// LNamespaceRequestReconciler creates the LNamespaces of approved LNamespaceRequests
+type LNamespaceRequestReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespacerequests,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespacerequests/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;create
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *LNamespaceRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("lnamespacerequest", req.NamespacedName)
+
+	lnsr := &gialv1beta1.LNamespaceRequest{}
+	err := r.Get(ctx, req.NamespacedName, lnsr)
+	if apierrors.IsNotFound(err) {
+		log.Info("request not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get request definition")
+		return ctrl.Result{}, err
+	}
+
+	switch lnsr.Status.Phase {
+	case gialv1beta1.RequestApproved, gialv1beta1.RequestDenied, gialv1beta1.RequestFailed:
+		return ctrl.Result{}, nil
+	}
+	switch lnsr.Spec.Decision {
+	case gialv1beta1.DecisionDenied:
+		message := "request was denied"
+		if lnsr.Spec.DecisionReason != "" {
+			message = lnsr.Spec.DecisionReason
+		}
+		r.Recorder.Eventf(lnsr, "Normal", "Denied", "Denied LNamespace %s: %s", lnsr.Spec.Name, message)
+		return ctrl.Result{}, r.updateStatus(ctx, log, lnsr, gialv1beta1.RequestDenied, message)
+	case gialv1beta1.DecisionApproved:
+		return r.create(ctx, log, lnsr)
+	}
+	if lnsr.Status.Phase != gialv1beta1.RequestPending {
+		return ctrl.Result{}, r.updateStatus(ctx, log, lnsr, gialv1beta1.RequestPending, "waiting for an approver to approve or deny the request")
+	}
+	return ctrl.Result{}, nil
+}
+
+// create creates the LNamespace of an approved request. Requests whose
+// LNamespace cannot be created, e.g. because its name is taken or because it
+// is rejected by the LNamespace webhooks, fail.
+func (r *LNamespaceRequestReconciler) create(ctx context.Context, log logr.Logger, lnsr *gialv1beta1.LNamespaceRequest) (ctrl.Result, error) {
+	origin := lnsr.Namespace + "/" + lnsr.Name
+	ns := &gialv1beta1.LNamespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        lnsr.Spec.Name,
+			Annotations: map[string]string{gialv1beta1.AnnotationRequest: origin},
+		},
+		Spec: *lnsr.Spec.Template.DeepCopy(),
+	}
+	err := r.Create(ctx, ns)
+	if apierrors.IsAlreadyExists(err) {
+		existing := &gialv1beta1.LNamespace{}
+		if err := r.Get(ctx, client.ObjectKey{Name: lnsr.Spec.Name}, existing); err != nil {
+			log.Error(err, "unable to get existing namespace")
+			return ctrl.Result{}, err
+		}
+		if existing.Annotations[gialv1beta1.AnnotationRequest] != origin {
+			return r.fail(ctx, log, lnsr, fmt.Sprintf("LNamespace %s already exists", lnsr.Spec.Name))
+		}
+		// the namespace was created by an earlier reconcile, whose status update failed
+	} else if apierrors.IsForbidden(err) || apierrors.IsInvalid(err) {
+		return r.fail(ctx, log, lnsr, fmt.Sprintf("unable to create LNamespace %s: %v", lnsr.Spec.Name, err))
+	} else if err != nil {
+		log.Error(err, "unable to create namespace")
+		return ctrl.Result{}, err
+	} else {
+		r.Recorder.Eventf(lnsr, "Normal", "Created", "Created LNamespace %s", lnsr.Spec.Name)
+	}
+	message := fmt.Sprintf("LNamespace %s was created", lnsr.Spec.Name)
+	if lnsr.Spec.DecisionReason != "" {
+		message += ": " + lnsr.Spec.DecisionReason
+	}
+	return ctrl.Result{}, r.updateStatus(ctx, log, lnsr, gialv1beta1.RequestApproved, message)
+}
+
+func (r *LNamespaceRequestReconciler) fail(ctx context.Context, log logr.Logger, lnsr *gialv1beta1.LNamespaceRequest, message string) (ctrl.Result, error) {
+	r.Recorder.Event(lnsr, "Warning", "Failed", message)
+	return ctrl.Result{}, r.updateStatus(ctx, log, lnsr, gialv1beta1.RequestFailed, message)
+}
+
+func (r *LNamespaceRequestReconciler) updateStatus(ctx context.Context, log logr.Logger, lnsr *gialv1beta1.LNamespaceRequest, phase gialv1beta1.RequestPhase, message string) error {
+	lnsr.Status.Phase = phase
+	lnsr.Status.Message = message
+	if err := r.Status().Update(ctx, lnsr); err != nil {
+		log.Error(err, "unable to update request status")
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the LNamespaceRequestReconciler with the provided manager
+func (r *LNamespaceRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespaceRequest{}).
+		Complete(r)
+}
@@ -0,0 +1,191 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/webhooks"
+	. "github.com/onsi/gomega"
+)
+
+// controllerUser is the user the controller creates LNamespaces as.
+const controllerUser = "system:serviceaccount:namespace-controller-system:namespace-controller-manager"
+
+// admittingClient admits the LNamespaces it creates through the LNamespace
+// validator, as the API server would for the controller.
+type admittingClient struct {
+	client.Client
+	validator *webhooks.LNamespaceValidator
+}
+
+func (c *admittingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if ns, ok := obj.(*gialv1beta1.LNamespace); ok {
+		raw, err := json.Marshal(ns)
+		if err != nil {
+			return err
+		}
+		res := c.validator.Handle(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: controllerUser},
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		if !res.Allowed {
+			return apierrors.NewForbidden(gialv1beta1.GroupVersion.WithResource("lnamespaces").GroupResource(), ns.Name, errors.New(string(res.Result.Reason)))
+		}
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("LNamespaceRequest Controller", func() {
+	var ctx context.Context
+	var lnsr *gialv1beta1.LNamespaceRequest
+	var lrr *controllers.LNamespaceRequestReconciler
+	var recorder *record.FakeRecorder
+	var k8sClient client.Client
+	var key = types.NamespacedName{Namespace: "requests", Name: "pharmacy"}
+
+	var get = func() *gialv1beta1.LNamespaceRequest {
+		res := &gialv1beta1.LNamespaceRequest{}
+		Expect(k8sClient.Get(ctx, key, res)).ToNot(HaveOccurred())
+		return res
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		lrr = &controllers.LNamespaceRequestReconciler{
+			Client:   k8sClient,
+			Log:      logf.Log,
+			Recorder: recorder,
+		}
+		lnsr = &gialv1beta1.LNamespaceRequest{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Spec: gialv1beta1.LNamespaceRequestSpec{
+				Name: DefaultName,
+				Template: gialv1beta1.LNamespaceSpec{
+					Sudoers:     []rbacv1.Subject{{Kind: "User", Name: john}},
+					Environment: gialv1beta1.EnvironmentProd,
+				},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, lnsr)).ToNot(HaveOccurred(), "Creating LNamespaceRequest should not have errored.")
+		_, err := lrr.Reconcile(ctx, controllerruntime.Request{NamespacedName: key})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespaceRequest should not have errored.")
+		close(done)
+	}, TestTimeout)
+
+	It("waits for a decision", func(done Done) {
+		Expect(get().Status.Phase).To(Equal(gialv1beta1.RequestPending))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, &gialv1beta1.LNamespace{})).To(HaveOccurred())
+		close(done)
+	}, TestTimeout)
+
+	When("the request is approved", func() {
+		BeforeEach(func(done Done) {
+			lnsr.Spec.Decision = gialv1beta1.DecisionApproved
+			lnsr.Spec.DecisionReason = "vetted by the platform team"
+			close(done)
+		}, TestTimeout)
+		It("creates the requested LNamespace", func(done Done) {
+			ns := &gialv1beta1.LNamespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, ns)).ToNot(HaveOccurred())
+			Expect(ns.Spec.Sudoers).To(Equal(lnsr.Spec.Template.Sudoers))
+			Expect(ns.Spec.Environment).To(Equal(gialv1beta1.EnvironmentProd))
+			Expect(ns.Annotations[gialv1beta1.AnnotationRequest]).To(Equal("requests/pharmacy"))
+			close(done)
+		}, TestTimeout)
+		It("reports the outcome and the reason", func(done Done) {
+			status := get().Status
+			Expect(status.Phase).To(Equal(gialv1beta1.RequestApproved))
+			Expect(status.Message).To(ContainSubstring("vetted by the platform team"))
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Created")))
+			close(done)
+		}, TestTimeout)
+
+		Context("and the LNamespace needs platform admin approval", func() {
+			BeforeEach(func(done Done) {
+				decoder, err := admission.NewDecoder(scheme.Scheme)
+				Expect(err).ToNot(HaveOccurred())
+				validator := &webhooks.LNamespaceValidator{
+					Client:                  k8sClient,
+					Recorder:                recorder,
+					DefaultPodSecurityLevel: gialv1beta1.PodSecurityRestricted,
+					PlatformAdminGroups:     []string{"platform-admins"},
+					ControllerUsername:      controllerUser,
+				}
+				Expect(validator.InjectDecoder(decoder)).ToNot(HaveOccurred())
+				lrr.Client = &admittingClient{Client: k8sClient, validator: validator}
+				lnsr.Spec.Template.PodSecurity = &gialv1beta1.PodSecurity{Enforce: gialv1beta1.PodSecurityPrivileged}
+				lnsr.Spec.Template.Size = gialv1beta1.SizeLarge
+				lnsr.Spec.Template.Hosts = []string{"*.loblaw.ca"}
+				close(done)
+			}, TestTimeout)
+			It("creates it with the approval of the approver", func(done Done) {
+				Expect(get().Status.Phase).To(Equal(gialv1beta1.RequestApproved))
+				ns := &gialv1beta1.LNamespace{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, ns)).ToNot(HaveOccurred())
+				Expect(ns.Spec.PodSecurity.Enforce).To(Equal(gialv1beta1.PodSecurityPrivileged))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and the name is taken", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(ctx, &gialv1beta1.LNamespace{
+					ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				})).ToNot(HaveOccurred())
+				close(done)
+			}, TestTimeout)
+			It("fails the request", func(done Done) {
+				status := get().Status
+				Expect(status.Phase).To(Equal(gialv1beta1.RequestFailed))
+				Expect(status.Message).To(ContainSubstring("already exists"))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the request is denied", func() {
+		BeforeEach(func(done Done) {
+			lnsr.Spec.Decision = gialv1beta1.DecisionDenied
+			lnsr.Spec.DecisionReason = "use a shared namespace instead"
+			close(done)
+		}, TestTimeout)
+		It("does not create the LNamespace", func(done Done) {
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, &gialv1beta1.LNamespace{})).To(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+		It("reports the outcome and the reason", func(done Done) {
+			status := get().Status
+			Expect(status.Phase).To(Equal(gialv1beta1.RequestDenied))
+			Expect(status.Message).To(Equal("use a shared namespace instead"))
+			close(done)
+		}, TestTimeout)
+	})
+})
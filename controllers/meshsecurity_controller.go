@@ -0,0 +1,213 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+)
+
+const (
+	// MeshSecurityName is the name of the istio security resources managed in every namespace
+	MeshSecurityName = "lns-default"
+	// LabelMeshSecurity marks istio security resources that are managed by the controller
+	LabelMeshSecurity = "gial.lblw.dev/mesh-security"
+)
+
+// MeshSecurityReconciler reconciles the istio security resources of a Namespace
+type MeshSecurityReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// IstioNamespace holds the istio control plane, which is always reachable from sidecars.
+	IstioNamespace string
+	// PlatformNamespaces may send traffic to every namespace in tenant isolation, e.g. istio-system or monitoring.
+	PlatformNamespaces []string
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=security.istio.io,resources=peerauthentications;authorizationpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.istio.io,resources=sidecars,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *MeshSecurityReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without updating dependents.")
+			return ctrl.Result{}, nil
+		}
+	}
+	mesh := ns.Spec.Mesh
+	if mesh == nil {
+		mesh = &gialv1beta1.Mesh{}
+	}
+	mode := ns.Spec.MeshMode()
+
+	resources := []struct {
+		gvk     schema.GroupVersionKind
+		enabled bool
+		spec    func() map[string]interface{}
+	}{
+		{istio.PeerAuthenticationGVK, mode != gialv1beta1.MeshNone && mesh.StrictMTLS, peerAuthenticationSpec},
+		{istio.AuthorizationPolicyGVK, mode != gialv1beta1.MeshNone && mesh.DefaultDeny, func() map[string]interface{} {
+			return authorizationPolicySpec(append([]string{ns.Name}, allowedNamespaces(ns, r.PlatformNamespaces)...))
+		}},
+		{istio.SidecarGVK, mode == gialv1beta1.MeshSidecar && mesh.Egress != nil, func() map[string]interface{} {
+			return sidecarSpec(r.IstioNamespace, mesh.Egress.Hosts)
+		}},
+	}
+	for _, v := range resources {
+		u := istio.New(v.gvk, MeshSecurityName, ns.Name)
+		if !v.enabled {
+			if err := r.remove(ctx, ns, u); err != nil {
+				log.Error(err, "unable to delete mesh security resource", "kind", v.gvk.Kind)
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		spec := v.spec()
+		opRes, err := controllerutil.CreateOrUpdate(ctx, r, u, func() error {
+			labels := u.GetLabels()
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[LabelMeshSecurity] = MeshSecurityName
+			u.SetLabels(labels)
+			u.Object["spec"] = spec
+			return controllerutil.SetControllerReference(ns, u, r.Scheme())
+		})
+		if err != nil {
+			log.Error(err, "unable to create or update mesh security resource", "kind", v.gvk.Kind)
+			return ctrl.Result{}, err
+		}
+		if opRes == controllerutil.OperationResultCreated {
+			r.Recorder.Eventf(ns, "Normal", "Create", "Created %s %s", v.gvk.Kind, u.GetName())
+		} else if opRes == controllerutil.OperationResultUpdated {
+			r.Recorder.Eventf(ns, "Normal", "Update", "Updated %s %s", v.gvk.Kind, u.GetName())
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// remove deletes u if it is controlled by ns.
+func (r *MeshSecurityReconciler) remove(ctx context.Context, ns *gialv1beta1.LNamespace, u *unstructured.Unstructured) error {
+	err := r.Get(ctx, client.ObjectKeyFromObject(u), u)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(u, ns) {
+		return nil
+	}
+	if err := r.Delete(ctx, u); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	r.Recorder.Eventf(ns, "Normal", "Delete", "Deleted %s %s", u.GetKind(), u.GetName())
+	return nil
+}
+
+// peerAuthenticationSpec rejects plain text traffic to every workload of the namespace.
+func peerAuthenticationSpec() map[string]interface{} {
+	return map[string]interface{}{
+		"mtls": map[string]interface{}{
+			"mode": "STRICT",
+		},
+	}
+}
+
+// authorizationPolicySpec allows traffic from the given namespaces only. As
+// soon as an ALLOW policy applies to a workload, requests that match none of
+// its rules are denied.
+func authorizationPolicySpec(namespaces []string) map[string]interface{} {
+	return map[string]interface{}{
+		"action": "ALLOW",
+		"rules": []interface{}{
+			map[string]interface{}{
+				"from": []interface{}{
+					map[string]interface{}{
+						"source": map[string]interface{}{
+							"namespaces": toInterfaces(namespaces),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// sidecarSpec limits the egress of the sidecars to the namespace itself, the
+// istio control plane and the given hosts.
+func sidecarSpec(istioNamespace string, hosts []string) map[string]interface{} {
+	egress := []string{"./*", istioNamespace + "/*"}
+	return map[string]interface{}{
+		"egress": []interface{}{
+			map[string]interface{}{
+				"hosts": toInterfaces(append(egress, hosts...)),
+			},
+		},
+	}
+}
+
+// toInterfaces converts s for use in unstructured objects.
+func toInterfaces(s []string) []interface{} {
+	out := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+// SetupWithManager sets up the MeshSecurityReconciler with the provided manager
+func (r *MeshSecurityReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Owns(istio.New(istio.PeerAuthenticationGVK, "", "")).
+		Owns(istio.New(istio.AuthorizationPolicyGVK, "", "")).
+		Owns(istio.New(istio.SidecarGVK, "", "")).
+		Complete(r)
+}
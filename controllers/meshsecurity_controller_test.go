@@ -0,0 +1,139 @@
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MeshSecurity Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var msr *controllers.MeshSecurityReconciler
+	var k8sClient client.Client
+
+	var reconcile = func() {
+		_, err := msr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{
+				Name: ns.Name,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	// get returns the managed resource of the given kind, or the error getting it
+	var get = func(gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+		u := istio.New(gvk, controllers.MeshSecurityName, ns.Name)
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(u), u)
+		return u, err
+	}
+
+	BeforeEach(func(done Done) {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		msr = &controllers.MeshSecurityReconciler{
+			Client:             k8sClient,
+			Log:                logf.Log,
+			Recorder:           record.NewFakeRecorder(64),
+			IstioNamespace:     "istio-system",
+			PlatformNamespaces: []string{"istio-system"},
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: DefaultName,
+			},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Mesh: &gialv1beta1.Mesh{
+					StrictMTLS:  true,
+					DefaultDeny: true,
+					Egress:      &gialv1beta1.MeshEgress{Hosts: []string{"shared/*"}},
+				},
+				Network: &gialv1beta1.Network{TrustedPeers: []string{"frontend"}},
+			},
+		}
+		ctx = context.Background()
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("creates a STRICT peer authentication", func(done Done) {
+		u, err := get(istio.PeerAuthenticationGVK)
+		Expect(err).ToNot(HaveOccurred())
+		mode, _, _ := unstructured.NestedString(u.Object, "spec", "mtls", "mode")
+		Expect(mode).To(Equal("STRICT"))
+		close(done)
+	}, TestTimeout)
+
+	It("only allows traffic from the namespace, platform namespaces and trusted peers", func(done Done) {
+		u, err := get(istio.AuthorizationPolicyGVK)
+		Expect(err).ToNot(HaveOccurred())
+		rules, _, _ := unstructured.NestedSlice(u.Object, "spec", "rules")
+		Expect(rules).To(HaveLen(1))
+		from := rules[0].(map[string]interface{})["from"].([]interface{})
+		namespaces, _, _ := unstructured.NestedStringSlice(from[0].(map[string]interface{}), "source", "namespaces")
+		Expect(namespaces).To(ConsistOf(DefaultName, "istio-system", "frontend"))
+		close(done)
+	}, TestTimeout)
+
+	It("scopes the sidecar egress", func(done Done) {
+		u, err := get(istio.SidecarGVK)
+		Expect(err).ToNot(HaveOccurred())
+		egress, _, _ := unstructured.NestedSlice(u.Object, "spec", "egress")
+		hosts, _, _ := unstructured.NestedStringSlice(egress[0].(map[string]interface{}), "hosts")
+		Expect(hosts).To(ConsistOf("./*", "istio-system/*", "shared/*"))
+		Expect(metav1.IsControlledBy(u, ns)).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	When("the namespace leaves the mesh", func() {
+		JustBeforeEach(func(done Done) {
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+			ns.Spec.Mesh.Mode = gialv1beta1.MeshNone
+			Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+			reconcile()
+			close(done)
+		}, TestTimeout)
+
+		It("deletes the mesh security resources", func(done Done) {
+			for _, gvk := range []schema.GroupVersionKind{istio.PeerAuthenticationGVK, istio.AuthorizationPolicyGVK, istio.SidecarGVK} {
+				_, err := get(gvk)
+				Expect(err).To(HaveOccurred(), gvk.Kind+" should have been deleted.")
+			}
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace uses the ambient mesh", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Mesh.Mode = gialv1beta1.MeshAmbient
+			close(done)
+		}, TestTimeout)
+
+		It("keeps the policies but does not manage a sidecar", func(done Done) {
+			_, err := get(istio.AuthorizationPolicyGVK)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = get(istio.SidecarGVK)
+			Expect(err).To(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -18,33 +18,62 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
+	"github.com/loblaw-sre/namespace-controller/pkg/hosts"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// IstioTag is the revision tag that istio relies on for directing which istiod to register with
-const IstioTag = "istio.io/rev"
+const (
+	// IstioTag is the revision tag that istio relies on for directing which istiod to register with
+	IstioTag = istio.RevisionLabel
+	// IstioDataplaneModeLabel enrolls a namespace in the ambient mesh
+	IstioDataplaneModeLabel = "istio.io/dataplane-mode"
+	// IstioInjectionLabel disables sidecar injection for a namespace
+	IstioInjectionLabel = "istio-injection"
+	// AnnotationManagedLabels records which namespace labels are written by the controller
+	AnnotationManagedLabels = "gial.lblw.dev/managed-labels"
+	// AnnotationManagedAnnotations records which namespace annotations are written by the controller
+	AnnotationManagedAnnotations = "gial.lblw.dev/managed-annotations"
+)
 
 // NamespaceReconciler reconciles a Namespace object
 type NamespaceReconciler struct {
 	client.Client
 	Log      logr.Logger
 	Recorder record.EventRecorder
+	// ProtectedLabels are namespace labels that NamespaceLabelOverrides may not replace.
+	ProtectedLabels utils.KeyMatcher
 }
 
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=namespaces;events,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -72,27 +101,41 @@ func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			return ctrl.Result{}, nil
 		}
 	}
+	// a soft deleted namespace is left as is, so that a restore can detach it
+	if ns.PendingDeletion() {
+		log.Info("namespace is pending deletion. Continuing without updating dependents.")
+		return ctrl.Result{}, nil
+	}
+	if ns.Retained() {
+		log.Info("namespace is to be retained. Continuing without updating dependents.")
+		return ctrl.Result{}, nil
+	}
+	resolved, err := hierarchy.Resolve(ctx, r, ns)
+	if err != nil {
+		log.Error(err, "unable to resolve parent namespaces")
+		return ctrl.Result{}, err
+	}
+
+	overrides, ignored := labelOverrides(resolved, r.ProtectedLabels)
+	for _, k := range ignored {
+		log.Info("ignoring override of protected label", "label", k)
+		r.Recorder.Eventf(ns, "Warning", "ProtectedLabel", "Ignored override of protected label %s", k)
+	}
 
 	cns := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: req.NamespacedName.Name,
 		},
 	}
+	var retainedFrom string
 	opRes, err := controllerutil.CreateOrPatch(ctx, r, cns, func() error {
 		if cns.Annotations == nil {
 			cns.Annotations = make(map[string]string)
 		}
-		if cns.Labels == nil {
-			cns.Labels = make(map[string]string)
-		}
-		cns.Labels[IstioTag] = ns.Spec.IstioRevision
-		for k, v := range ns.Spec.Billing {
-			cns.Annotations[k] = v
-		}
-
-		for k, v := range ns.Spec.NamespaceLabelOverrides {
-			cns.Labels[k] = v
-		}
+		// a namespace retained from a deleted LNamespace is adopted as is
+		retainedFrom = cns.Annotations[gialv1beta1.AnnotationRetainedFrom]
+		delete(cns.Annotations, gialv1beta1.AnnotationRetainedFrom)
+		setNamespaceMetadata(cns, resolved, overrides, nil)
 		return controllerutil.SetControllerReference(ns, cns, r.Scheme())
 	})
 	if err != nil {
@@ -101,17 +144,292 @@ func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	}
 	if opRes == controllerutil.OperationResultCreated {
 		r.Recorder.Eventf(ns, "Normal", "Create", "Created namespace %s", req.Name)
+	} else if retainedFrom != "" {
+		r.Recorder.Eventf(ns, "Normal", "Adopt", "Adopted namespace %s, retained from LNamespace %s", req.Name, retainedFrom)
 	} else if opRes == controllerutil.OperationResultUpdated {
 		r.Recorder.Eventf(ns, "Normal", "Update", "Updated namespace %s", req.Name)
 	}
 
+	revisionChanged, err := r.setIstioRevisionCondition(ctx, ns)
+	if err != nil {
+		log.Error(err, "unable to check istio revision")
+		return ctrl.Result{}, err
+	}
+	hostsChanged, err := r.setHostsCondition(ctx, ns)
+	if err != nil {
+		log.Error(err, "unable to check host claims")
+		return ctrl.Result{}, err
+	}
+	if revisionChanged || hostsChanged {
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to update namespace conditions")
+			return ctrl.Result{}, err
+		}
+	}
 	return ctrl.Result{}, nil
 }
 
+// setIstioRevisionCondition reports whether spec.istioRevision names an
+// installed istio control plane, listing the valid revisions in the message.
+func (r *NamespaceReconciler) setIstioRevisionCondition(ctx context.Context, ns *gialv1beta1.LNamespace) (bool, error) {
+	revisions, err := istio.Revisions(ctx, r)
+	if err != nil {
+		return false, err
+	}
+	condition := metav1.Condition{
+		Type:               gialv1beta1.ConditionIstioRevisionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "RevisionInstalled",
+		Message:            fmt.Sprintf("valid revisions: %s", strings.Join(revisions, ", ")),
+		ObservedGeneration: ns.Generation,
+	}
+	if ns.Spec.MeshMode() != gialv1beta1.MeshSidecar {
+		condition.Reason = "RevisionUnused"
+	} else if ns.Spec.IstioRevision == "" {
+		condition.Reason = "RevisionUnset"
+	} else if !istio.Contains(revisions, ns.Spec.IstioRevision) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RevisionNotInstalled"
+	}
+	if !setCondition(&ns.Status.Conditions, condition) {
+		return false, nil
+	}
+	if condition.Status == metav1.ConditionFalse {
+		r.Recorder.Eventf(ns, "Warning", condition.Reason, "Istio revision %s is not installed, %s", ns.Spec.IstioRevision, condition.Message)
+	}
+	return true, nil
+}
+
+// setHostsCondition reports the claims of spec.hosts that are already owned
+// by an older LNamespace.
+func (r *NamespaceReconciler) setHostsCondition(ctx context.Context, ns *gialv1beta1.LNamespace) (bool, error) {
+	if len(ns.Spec.Hosts) == 0 {
+		if meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionHostsClaimed) == nil {
+			return false, nil
+		}
+		meta.RemoveStatusCondition(&ns.Status.Conditions, gialv1beta1.ConditionHostsClaimed)
+		return true, nil
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(ctx, l); err != nil {
+		return false, err
+	}
+	condition := metav1.Condition{
+		Type:               gialv1beta1.ConditionHostsClaimed,
+		Status:             metav1.ConditionTrue,
+		Reason:             "HostsClaimed",
+		Message:            fmt.Sprintf("claimed hosts: %s", strings.Join(ns.Spec.Hosts, ", ")),
+		ObservedGeneration: ns.Generation,
+	}
+	if conflicts := hosts.Conflicts(ns, l.Items); len(conflicts) > 0 {
+		messages := make([]string, 0, len(conflicts))
+		for claim, owners := range conflicts {
+			messages = append(messages, fmt.Sprintf("%s is owned by %s", claim, strings.Join(owners, ", ")))
+		}
+		sort.Strings(messages)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HostConflict"
+		condition.Message = strings.Join(messages, "; ")
+	}
+	if !setCondition(&ns.Status.Conditions, condition) {
+		return false, nil
+	}
+	if condition.Status == metav1.ConditionFalse {
+		r.Recorder.Event(ns, "Warning", condition.Reason, condition.Message)
+	}
+	return true, nil
+}
+
+// setCondition sets condition in conditions, returning false if an identical
+// condition was already present.
+func setCondition(conditions *[]metav1.Condition, condition metav1.Condition) bool {
+	current := meta.FindStatusCondition(*conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(conditions, condition)
+	return true
+}
+
+// labelOverrides returns the NamespaceLabelOverrides of resolved that may be
+// applied, along with the sorted keys of the protected labels it overrides.
+func labelOverrides(resolved *gialv1beta1.LNamespace, protected utils.KeyMatcher) (map[string]string, []string) {
+	overrides := make(map[string]string)
+	var ignored []string
+	for k, v := range resolved.Spec.NamespaceLabelOverrides {
+		if protected.Matches(k) {
+			ignored = append(ignored, k)
+			continue
+		}
+		overrides[k] = v
+	}
+	sort.Strings(ignored)
+	return overrides, ignored
+}
+
+// setNamespaceMetadata writes the labels and billing annotations of the
+// namespace of resolved, along with extra labels, onto cns, and prunes those
+// that the controller wrote before but no longer desires.
+func setNamespaceMetadata(cns *corev1.Namespace, resolved *gialv1beta1.LNamespace, overrides, extra map[string]string) {
+	if cns.Labels == nil {
+		cns.Labels = make(map[string]string)
+	}
+	if cns.Annotations == nil {
+		cns.Annotations = make(map[string]string)
+	}
+	labels := namespaceLabels(&resolved.Spec, overrides)
+	for k, v := range extra {
+		labels[k] = v
+	}
+	applyManagedKeys(cns.Labels, labels, cns.Annotations, AnnotationManagedLabels)
+	applyManagedKeys(cns.Annotations, resolved.Spec.BillingAttributes(), cns.Annotations, AnnotationManagedAnnotations)
+}
+
+// namespaceLabels returns the labels of the namespace of spec, including the
+// label overrides that it is allowed to apply.
+func namespaceLabels(spec *gialv1beta1.LNamespaceSpec, overrides map[string]string) map[string]string {
+	labels := meshLabels(spec)
+	labels[gialv1beta1.LabelEnvironment] = string(spec.Env())
+	for k, v := range overrides {
+		labels[k] = v
+	}
+	if spec.PodSecurity != nil {
+		for mode, level := range spec.PodSecurity.Levels() {
+			if level != "" {
+				labels[gialv1beta1.PodSecurityLabelPrefix+mode] = string(level)
+			}
+		}
+	}
+	return labels
+}
+
+// meshLabels returns the istio labels of the namespace for its mesh mode.
+// Labels of the other modes are left out so that they are pruned on a switch.
+func meshLabels(spec *gialv1beta1.LNamespaceSpec) map[string]string {
+	labels := make(map[string]string)
+	switch spec.MeshMode() {
+	case gialv1beta1.MeshSidecar:
+		if spec.IstioRevision != "" {
+			labels[IstioTag] = spec.IstioRevision
+		}
+	case gialv1beta1.MeshAmbient:
+		labels[IstioDataplaneModeLabel] = "ambient"
+	case gialv1beta1.MeshNone:
+		labels[IstioInjectionLabel] = "disabled"
+	}
+	return labels
+}
+
+// applyManagedKeys writes desired into current and removes any key that was
+// previously written by the controller but is no longer desired. The keys
+// that the controller manages are recorded in record[recordKey], so that keys
+// set by other tools are never touched.
+func applyManagedKeys(current, desired, record map[string]string, recordKey string) {
+	for _, k := range strings.Split(record[recordKey], ",") {
+		if _, ok := desired[k]; !ok && k != "" {
+			delete(current, k)
+		}
+	}
+	keys := make([]string, 0, len(desired))
+	for k, v := range desired {
+		current[k] = v
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		delete(record, recordKey)
+		return
+	}
+	record[recordKey] = strings.Join(keys, ",")
+}
+
+// requestsForControlPlane maps an istio control plane to every LNamespace, so
+// that their revision conditions are refreshed when revisions come and go.
+func (r *NamespaceReconciler) requestsForControlPlane(o client.Object) []reconcile.Request {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(context.Background(), l); err != nil {
+		r.Log.Error(err, "unable to list namespaces for istio control plane", "name", o.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(l.Items))
+	for _, v := range l.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v.Name}})
+	}
+	return requests
+}
+
+// requestsForHostClaims maps an LNamespace to every other LNamespace that
+// claims hosts, so that their conflicts are refreshed when claims change.
+func (r *NamespaceReconciler) requestsForHostClaims(o client.Object) []reconcile.Request {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(context.Background(), l); err != nil {
+		r.Log.Error(err, "unable to list namespaces for host claims", "name", o.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, v := range l.Items {
+		if v.Name != o.GetName() && len(v.Spec.Hosts) > 0 {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v.Name}})
+		}
+	}
+	return requests
+}
+
+// requestsForDescendants returns a map func that enqueues the LNamespaces
+// below an LNamespace, so that they pick up what they inherit from it.
+func requestsForDescendants(c client.Reader, log logr.Logger) handler.MapFunc {
+	return func(o client.Object) []reconcile.Request {
+		names, err := hierarchy.Descendants(context.Background(), c, o.GetName())
+		if err != nil {
+			log.Error(err, "unable to list child namespaces", "name", o.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(names))
+		for _, v := range names {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v}})
+		}
+		return requests
+	}
+}
+
+// requestsForTeam returns a map func that enqueues the LNamespaces that
+// reference an LTeam, and those below them, so that they pick up its changes.
+func requestsForTeam(c client.Reader, log logr.Logger) handler.MapFunc {
+	return func(o client.Object) []reconcile.Request {
+		names, err := hierarchy.Members(context.Background(), c, o.GetName())
+		if err != nil {
+			log.Error(err, "unable to list namespaces of team", "team", o.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(names))
+		for _, v := range names {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v}})
+		}
+		return requests
+	}
+}
+
 // SetupWithManager sets up the NamespaceReconciler with the provided manager
 func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isControlPlane := predicate.NewPredicateFuncs(istio.IsControlPlane)
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gialv1beta1.LNamespace{}).
 		Owns(&corev1.Namespace{}).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LNamespace{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForHostClaims), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LNamespace{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForDescendants(r, r.Log)), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LTeam{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForTeam(r, r.Log))).
+		Watches(&source.Kind{
+			Type: &admissionregistrationv1.MutatingWebhookConfiguration{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForControlPlane), builder.WithPredicates(isControlPlane)).
+		Watches(&source.Kind{
+			Type: &appsv1.Deployment{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForControlPlane), builder.WithPredicates(isControlPlane)).
 		Complete(r)
 }
@@ -4,7 +4,10 @@ import (
 	"context"
 
 	. "github.com/onsi/ginkgo"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -16,6 +19,7 @@ import (
 
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
 	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
 	. "github.com/onsi/gomega"
 )
 
@@ -75,6 +79,194 @@ var _ = Describe("Namespace Controller", func() {
 				Expect(rawNs.Labels[controllers.IstioTag]).To(Equal("istio-version-1"))
 				close(done)
 			}, TestTimeout)
+			It("is stamped with the default environment", func(done Done) {
+				Expect(rawNs.Labels[gialv1beta1.LabelEnvironment]).To(Equal("dev"))
+				Expect(rawNs.Annotations[gialv1beta1.BillingEnvironment]).To(Equal("dev"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("prod namespace", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Environment = gialv1beta1.EnvironmentProd
+				close(done)
+			}, TestTimeout)
+			It("is stamped with its environment", func(done Done) {
+				Expect(rawNs.Labels[gialv1beta1.LabelEnvironment]).To(Equal("prod"))
+				Expect(rawNs.Annotations[gialv1beta1.BillingEnvironment]).To(Equal("prod"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("retained from a deleted LNamespace", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(ctx, &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        ns.Name,
+						Annotations: map[string]string{gialv1beta1.AnnotationRetainedFrom: ns.Name + "/old-uid"},
+					},
+				})).ToNot(HaveOccurred())
+				close(done)
+			}, TestTimeout)
+			It("is adopted by the new LNamespace", func(done Done) {
+				Expect(metav1.GetControllerOf(rawNs)).ToNot(BeNil())
+				Expect(rawNs.Annotations).ToNot(HaveKey(gialv1beta1.AnnotationRetainedFrom))
+				Eventually(nsr.Recorder.(*record.FakeRecorder).Events, EventuallyTimeout).Should(Receive(ContainSubstring("Adopt")))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("without the istio revision installed", func() {
+			It("flags the revision as invalid", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred(), "Getting LNamespace should not have errored.")
+				condition := meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionIstioRevisionValid)
+				Expect(condition).ToNot(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				Expect(condition.Reason).To(Equal("RevisionNotInstalled"))
+				close(done)
+			}, TestTimeout)
+
+			It("reports the invalid revision as an event", func(done Done) {
+				Eventually(nsr.Recorder.(*record.FakeRecorder).Events, EventuallyTimeout).Should(Receive(ContainSubstring("RevisionNotInstalled")))
+				close(done)
+			}, TestTimeout)
+
+			It("still writes the revision label", func(done Done) {
+				Expect(rawNs.Labels[controllers.IstioTag]).To(Equal("istio-version-1"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with the istio revision installed", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(ctx, &admissionregistrationv1.MutatingWebhookConfiguration{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "istio-sidecar-injector-istio-version-1",
+						Labels: map[string]string{controllers.IstioTag: "istio-version-1"},
+					},
+				})).ToNot(HaveOccurred(), "Creating sidecar injector should not have errored.")
+				Expect(k8sClient.Create(ctx, &appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "istiod-istio-version-2",
+						Namespace: "istio-system",
+						Labels:    map[string]string{"app": "istiod", controllers.IstioTag: "istio-version-2"},
+					},
+				})).ToNot(HaveOccurred(), "Creating istiod should not have errored.")
+				close(done)
+			}, TestTimeout)
+
+			It("marks the revision as valid and lists the valid revisions", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred(), "Getting LNamespace should not have errored.")
+				condition := meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionIstioRevisionValid)
+				Expect(condition).ToNot(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				Expect(condition.Message).To(Equal("valid revisions: istio-version-1, istio-version-2"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("in ambient mesh mode", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshAmbient}
+				close(done)
+			}, TestTimeout)
+
+			It("enrolls the namespace in the ambient data plane without sidecars", func(done Done) {
+				Expect(rawNs.Labels).To(HaveKeyWithValue(controllers.IstioDataplaneModeLabel, "ambient"))
+				Expect(rawNs.Labels).ToNot(HaveKey(controllers.IstioTag))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("out of the mesh", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshNone}
+				close(done)
+			}, TestTimeout)
+
+			It("disables sidecar injection", func(done Done) {
+				Expect(rawNs.Labels).To(HaveKeyWithValue(controllers.IstioInjectionLabel, "disabled"))
+				Expect(rawNs.Labels).ToNot(HaveKey(controllers.IstioTag))
+				Expect(rawNs.Labels).ToNot(HaveKey(controllers.IstioDataplaneModeLabel))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("in sidecar mode without a revision", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.IstioRevision = ""
+				close(done)
+			}, TestTimeout)
+
+			It("does not write an empty revision label", func(done Done) {
+				Expect(rawNs.Labels).ToNot(HaveKey(controllers.IstioTag))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("when switching from sidecar to ambient mode", func() {
+			JustBeforeEach(func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred(), "Getting LNamespace should not have errored.")
+				ns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshAmbient}
+				Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred(), "Updating LNamespace should not have errored.")
+				_, err := nsr.Reconcile(ctx, controllerruntime.Request{
+					NamespacedName: types.NamespacedName{
+						Name: ns.Name,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+				rawNs = &corev1.Namespace{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, rawNs)).ToNot(HaveOccurred(), "Getting raw namespace should not have errored.")
+				close(done)
+			})
+
+			It("removes the stale revision label", func(done Done) {
+				Expect(rawNs.Labels).ToNot(HaveKey(controllers.IstioTag))
+				Expect(rawNs.Labels).To(HaveKeyWithValue(controllers.IstioDataplaneModeLabel, "ambient"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with hosts claimed by an older namespace", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(ctx, &gialv1beta1.LNamespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "shop",
+						CreationTimestamp: metav1.Unix(1, 0),
+					},
+					Spec: gialv1beta1.LNamespaceSpec{Hosts: []string{"*.shop.loblaw.ca"}},
+				})).ToNot(HaveOccurred())
+				ns.CreationTimestamp = metav1.Unix(2, 0)
+				ns.Spec.Hosts = []string{"api.shop.loblaw.ca", "pharmacy.loblaw.ca"}
+				close(done)
+			}, TestTimeout)
+
+			It("reports the conflict in status", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred(), "Getting LNamespace should not have errored.")
+				condition := meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionHostsClaimed)
+				Expect(condition).ToNot(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				Expect(condition.Message).To(Equal("api.shop.loblaw.ca is owned by shop"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with pod security levels", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{
+					Enforce: gialv1beta1.PodSecurityBaseline,
+					Audit:   gialv1beta1.PodSecurityRestricted,
+					Warn:    gialv1beta1.PodSecurityRestricted,
+				}
+				close(done)
+			}, TestTimeout)
+
+			It("renders the pod security labels", func(done Done) {
+				Expect(rawNs.Labels).To(HaveKeyWithValue("pod-security.kubernetes.io/enforce", "baseline"))
+				Expect(rawNs.Labels).To(HaveKeyWithValue("pod-security.kubernetes.io/audit", "restricted"))
+				Expect(rawNs.Labels).To(HaveKeyWithValue("pod-security.kubernetes.io/warn", "restricted"))
+				close(done)
+			}, TestTimeout)
 		})
 
 		Context("with namespace label overrides", func() {
@@ -96,5 +288,81 @@ var _ = Describe("Namespace Controller", func() {
 				close(done)
 			}, TestTimeout)
 		})
+
+		Context("with overrides of protected labels", func() {
+			BeforeEach(func(done Done) {
+				nsr.ProtectedLabels = utils.KeyMatcher{
+					Keys:     []string{controllers.IstioTag},
+					Prefixes: []string{"pod-security.kubernetes.io/"},
+				}
+				ns.Spec.NamespaceLabelOverrides = map[string]string{
+					controllers.IstioTag:                 "istio-version-override",
+					"pod-security.kubernetes.io/enforce": "privileged",
+					"custom":                             "this-is-a-custom-value",
+				}
+				close(done)
+			}, TestTimeout)
+
+			It("ignores the protected labels", func(done Done) {
+				Expect(rawNs.Labels[controllers.IstioTag]).To(Equal("istio-version-1"))
+				Expect(rawNs.Labels).ToNot(HaveKey("pod-security.kubernetes.io/enforce"))
+				close(done)
+			}, TestTimeout)
+
+			It("still sets the unprotected labels", func(done Done) {
+				Expect(rawNs.Labels["custom"]).Should(Equal("this-is-a-custom-value"))
+				close(done)
+			}, TestTimeout)
+
+			It("reports the ignored labels as events", func(done Done) {
+				Expect(nsr.Recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring("ProtectedLabel")))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with keys removed from the spec", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.NamespaceLabelOverrides = map[string]string{
+					"custom": "this-is-a-custom-value",
+				}
+				close(done)
+			}, TestTimeout)
+
+			JustBeforeEach(func(done Done) {
+				rawNs.Labels["foreign"] = "set-by-another-tool"
+				rawNs.Annotations["foreign"] = "set-by-another-tool"
+				Expect(k8sClient.Update(ctx, rawNs)).ToNot(HaveOccurred(), "Updating raw namespace should not have errored.")
+
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred(), "Getting LNamespace should not have errored.")
+				ns.Spec.NamespaceLabelOverrides = nil
+				ns.Spec.Billing = nil
+				Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred(), "Updating LNamespace should not have errored.")
+				_, err := nsr.Reconcile(ctx, controllerruntime.Request{
+					NamespacedName: types.NamespacedName{
+						Name: ns.Name,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+				rawNs = &corev1.Namespace{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, rawNs)).ToNot(HaveOccurred(), "Getting raw namespace should not have errored.")
+				close(done)
+			})
+
+			It("removes labels that are no longer overridden", func(done Done) {
+				Expect(rawNs.Labels).ToNot(HaveKey("custom"))
+				close(done)
+			}, TestTimeout)
+
+			It("removes annotations that are no longer billed", func(done Done) {
+				Expect(rawNs.Annotations).ToNot(HaveKey("budget"))
+				close(done)
+			}, TestTimeout)
+
+			It("keeps labels and annotations that were added by other tools", func(done Done) {
+				Expect(rawNs.Labels).To(HaveKeyWithValue("foreign", "set-by-another-tool"))
+				Expect(rawNs.Annotations).To(HaveKeyWithValue("foreign", "set-by-another-tool"))
+				close(done)
+			}, TestTimeout)
+		})
 	})
 })
@@ -0,0 +1,177 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+const (
+	// NamespaceNameLabel is set on every namespace by the API server (1.21+) and holds its name
+	NamespaceNameLabel = "kubernetes.io/metadata.name"
+	// BaselineNetworkPolicyName is the name of the NetworkPolicy managed in every namespace
+	BaselineNetworkPolicyName = "lns-baseline"
+	// LabelNetworkPolicy marks NetworkPolicies that are managed by the controller
+	LabelNetworkPolicy = "gial.lblw.dev/network-policy"
+)
+
+// NetworkReconciler reconciles the baseline NetworkPolicy of a Namespace
+type NetworkReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// PlatformNamespaces may send traffic to every namespace in tenant isolation, e.g. istio-system or monitoring.
+	PlatformNamespaces []string
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+
+// allowedNamespaces returns the other namespaces allowed to send traffic to ns.
+func allowedNamespaces(ns *gialv1beta1.LNamespace, platformNamespaces []string) []string {
+	if ns.Spec.Network == nil {
+		return append([]string{}, platformNamespaces...)
+	}
+	var allowed []string
+	if ns.Spec.Network.Isolation != gialv1beta1.IsolationStrict {
+		allowed = append(allowed, platformNamespaces...)
+	}
+	return append(allowed, ns.Spec.Network.TrustedPeers...)
+}
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without updating dependents.")
+			return ctrl.Result{}, nil
+		}
+	}
+	if ns.Spec.Network == nil {
+		ns.Spec.Network = &gialv1beta1.Network{}
+	}
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BaselineNetworkPolicyName,
+			Namespace: ns.Name,
+		},
+	}
+	if ns.Spec.Network.Isolation == gialv1beta1.IsolationNone {
+		err := r.Get(ctx, client.ObjectKeyFromObject(np), np)
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		} else if err != nil {
+			log.Error(err, "unable to get baseline network policy")
+			return ctrl.Result{}, err
+		}
+		if !metav1.IsControlledBy(np, ns) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.Delete(ctx, np); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete baseline network policy")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Delete", "Deleted network policy %s", np.Name)
+		return ctrl.Result{}, nil
+	}
+
+	opRes, err := controllerutil.CreateOrUpdate(ctx, r, np, func() error {
+		if np.Labels == nil {
+			np.Labels = make(map[string]string)
+		}
+		np.Labels[LabelNetworkPolicy] = BaselineNetworkPolicyName
+		// NetworkPolicies are additive, so any ingress that is not allowed here is denied.
+		ingress := []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{}},
+				},
+			},
+		}
+		if allowed := allowedNamespaces(ns, r.PlatformNamespaces); len(allowed) > 0 {
+			ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{
+									Key:      NamespaceNameLabel,
+									Operator: metav1.LabelSelectorOpIn,
+									Values:   allowed,
+								},
+							},
+						},
+					},
+				},
+			})
+		}
+		np.Spec = networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     ingress,
+		}
+		return controllerutil.SetControllerReference(ns, np, r.Scheme())
+	})
+	if err != nil {
+		log.Error(err, "unable to create or update baseline network policy")
+		return ctrl.Result{}, err
+	}
+	if opRes == controllerutil.OperationResultCreated {
+		r.Recorder.Eventf(ns, "Normal", "Create", "Created network policy %s", np.Name)
+	} else if opRes == controllerutil.OperationResultUpdated {
+		r.Recorder.Eventf(ns, "Normal", "Update", "Updated network policy %s", np.Name)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the NetworkReconciler with the provided manager
+func (r *NetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Complete(r)
+}
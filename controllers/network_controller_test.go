@@ -0,0 +1,125 @@
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+var _ = Describe("Network Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var nr *controllers.NetworkReconciler
+	var k8sClient client.Client
+	var np *networkingv1.NetworkPolicy
+	var npErr error
+
+	var reconcile = func() {
+		_, err := nr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{
+				Name: ns.Name,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+		np = &networkingv1.NetworkPolicy{}
+		npErr = k8sClient.Get(ctx, types.NamespacedName{Name: controllers.BaselineNetworkPolicyName, Namespace: ns.Name}, np)
+	}
+
+	// allowedNamespaces returns the namespaces selected by the namespace selector of the baseline policy
+	var allowedNamespaces = func() []string {
+		for _, rule := range np.Spec.Ingress {
+			for _, peer := range rule.From {
+				if peer.NamespaceSelector != nil {
+					return peer.NamespaceSelector.MatchExpressions[0].Values
+				}
+			}
+		}
+		return nil
+	}
+
+	BeforeEach(func(done Done) {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		nr = &controllers.NetworkReconciler{
+			Client:             k8sClient,
+			Log:                logf.Log,
+			Recorder:           record.NewFakeRecorder(64),
+			PlatformNamespaces: []string{"istio-system", "monitoring"},
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: DefaultName,
+			},
+		}
+		ctx = context.Background()
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	Context("default isolation", func() {
+		It("creates a baseline network policy for all pods", func(done Done) {
+			Expect(npErr).ToNot(HaveOccurred())
+			Expect(np.Spec.PodSelector.MatchLabels).To(BeEmpty())
+			Expect(np.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress))
+			close(done)
+		}, TestTimeout)
+		It("allows traffic from the namespace itself", func(done Done) {
+			Expect(np.Spec.Ingress).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+				"From": ConsistOf(MatchFields(IgnoreExtras, Fields{
+					"PodSelector":       Not(BeNil()),
+					"NamespaceSelector": BeNil(),
+				})),
+			})))
+			close(done)
+		}, TestTimeout)
+		It("allows traffic from the platform namespaces", func(done Done) {
+			Expect(allowedNamespaces()).To(ConsistOf("istio-system", "monitoring"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Context("strict isolation with trusted peers", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Network = &gialv1beta1.Network{
+				Isolation:    gialv1beta1.IsolationStrict,
+				TrustedPeers: []string{"frontend"},
+			}
+			close(done)
+		}, TestTimeout)
+		It("only allows traffic from trusted peers", func(done Done) {
+			Expect(allowedNamespaces()).To(ConsistOf("frontend"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("isolation is turned off", func() {
+		JustBeforeEach(func(done Done) {
+			ns.Spec.Network = &gialv1beta1.Network{Isolation: gialv1beta1.IsolationNone}
+			Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+			reconcile()
+			close(done)
+		}, TestTimeout)
+		It("deletes the baseline network policy", func(done Done) {
+			Expect(resourceState{resource: np, err: npErr}).To(ExistAsAResource(false))
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -0,0 +1,292 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+const (
+	// LabelSize marks ResourceQuota and LimitRange templates with the size
+	// they belong to, and the copies made from them.
+	LabelSize = "gial.lblw.dev/size"
+	// CustomSizeName is the name of the ResourceQuota and LimitRange created for custom sizes.
+	CustomSizeName = "custom-size"
+)
+
+// QuotaReconciler reconciles the ResourceQuotas and LimitRanges of a Namespace
+type QuotaReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// TemplateNamespace holds the ResourceQuota and LimitRange templates of
+	// each size. The templates are enforced there as well, so it should hold
+	// no workloads.
+	TemplateNamespace string
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=resourcequotas;limitranges,verbs=get;list;watch;create;update;patch;delete
+
+// desiredSize returns the ResourceQuotas and LimitRanges that the namespace should contain.
+func (r *QuotaReconciler) desiredSize(ctx context.Context, ns *gialv1beta1.LNamespace) ([]corev1.ResourceQuota, []corev1.LimitRange, error) {
+	switch ns.Spec.Size {
+	case "", gialv1beta1.SizeNone:
+		return nil, nil, nil
+	case gialv1beta1.SizeCustom:
+		var quotas []corev1.ResourceQuota
+		var limits []corev1.LimitRange
+		if ns.Spec.CustomSize == nil {
+			return nil, nil, nil
+		}
+		if len(ns.Spec.CustomSize.Hard) > 0 {
+			quotas = append(quotas, corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: CustomSizeName},
+				Spec:       corev1.ResourceQuotaSpec{Hard: ns.Spec.CustomSize.Hard},
+			})
+		}
+		if len(ns.Spec.CustomSize.Limits) > 0 {
+			limits = append(limits, corev1.LimitRange{
+				ObjectMeta: metav1.ObjectMeta{Name: CustomSizeName},
+				Spec:       corev1.LimitRangeSpec{Limits: ns.Spec.CustomSize.Limits},
+			})
+		}
+		return quotas, limits, nil
+	}
+	listOptions := []client.ListOption{
+		client.InNamespace(r.TemplateNamespace),
+		client.MatchingLabels{LabelSize: string(ns.Spec.Size)},
+	}
+	quotas := &corev1.ResourceQuotaList{}
+	if err := r.List(ctx, quotas, listOptions...); err != nil {
+		return nil, nil, err
+	}
+	limits := &corev1.LimitRangeList{}
+	if err := r.List(ctx, limits, listOptions...); err != nil {
+		return nil, nil, err
+	}
+	return quotas.Items, limits.Items, nil
+}
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *QuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without updating dependents.")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	quotas, limits, err := r.desiredSize(ctx, ns)
+	if err != nil {
+		log.Error(err, "unable to get size templates", "size", ns.Spec.Size)
+		return ctrl.Result{}, err
+	}
+	if ns.Spec.Size.Platform() && len(quotas) == 0 && len(limits) == 0 {
+		r.Recorder.Eventf(ns, "Warning", "SizeTemplateMissing", "Size %s has no ResourceQuota or LimitRange template in %s, so the namespace has no quota", ns.Spec.Size, r.TemplateNamespace)
+	}
+
+	desiredQuotas := make(map[string]bool)
+	for _, v := range quotas {
+		v := v
+		rq := &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      v.Name,
+				Namespace: ns.Name,
+			},
+		}
+		_, err := controllerutil.CreateOrUpdate(ctx, r, rq, func() error {
+			if rq.Labels == nil {
+				rq.Labels = make(map[string]string)
+			}
+			rq.Labels[LabelSize] = string(ns.Spec.Size)
+			rq.Spec = *v.Spec.DeepCopy()
+			return controllerutil.SetControllerReference(ns, rq, r.Scheme())
+		})
+		if err != nil {
+			log.Error(err, "unable to create or update resource quota", "name", rq.Name)
+			return ctrl.Result{}, err
+		}
+		desiredQuotas[rq.Name] = true
+	}
+	desiredLimits := make(map[string]bool)
+	for _, v := range limits {
+		v := v
+		lr := &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      v.Name,
+				Namespace: ns.Name,
+			},
+		}
+		_, err := controllerutil.CreateOrUpdate(ctx, r, lr, func() error {
+			if lr.Labels == nil {
+				lr.Labels = make(map[string]string)
+			}
+			lr.Labels[LabelSize] = string(ns.Spec.Size)
+			lr.Spec = *v.Spec.DeepCopy()
+			return controllerutil.SetControllerReference(ns, lr, r.Scheme())
+		})
+		if err != nil {
+			log.Error(err, "unable to create or update limit range", "name", lr.Name)
+			return ctrl.Result{}, err
+		}
+		desiredLimits[lr.Name] = true
+	}
+
+	// cleanup quotas and limits that belong to a previous size
+	selector, err := labels.Parse(LabelSize)
+	if err != nil {
+		log.Error(err, "unable to generate List Options for sized resources")
+		return ctrl.Result{}, err
+	}
+	listOptions := &client.ListOptions{
+		LabelSelector: selector,
+		Namespace:     ns.Name,
+	}
+	rql := &corev1.ResourceQuotaList{}
+	if err := r.List(ctx, rql, listOptions); err != nil {
+		log.Error(err, "unable to list resource quotas")
+		return ctrl.Result{}, err
+	}
+	status := &corev1.ResourceQuotaStatus{}
+	for _, v := range rql.Items {
+		v := v
+		if !metav1.IsControlledBy(&v, ns) {
+			continue
+		}
+		if !desiredQuotas[v.Name] {
+			log.Info("deleting resource quota", "name", v.Name)
+			if err := r.Delete(ctx, &v); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "unable to delete resource quota", "name", v.Name)
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		mergeResourceList(&status.Hard, v.Status.Hard)
+		mergeResourceList(&status.Used, v.Status.Used)
+	}
+	lrl := &corev1.LimitRangeList{}
+	if err := r.List(ctx, lrl, listOptions); err != nil {
+		log.Error(err, "unable to list limit ranges")
+		return ctrl.Result{}, err
+	}
+	for _, v := range lrl.Items {
+		v := v
+		if metav1.IsControlledBy(&v, ns) && !desiredLimits[v.Name] {
+			log.Info("deleting limit range", "name", v.Name)
+			if err := r.Delete(ctx, &v); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "unable to delete limit range", "name", v.Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	if len(status.Hard) == 0 && len(status.Used) == 0 {
+		status = nil
+	}
+	if !apiequality.Semantic.DeepEqual(ns.Status.Quota, status) {
+		ns.Status.Quota = status
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to update quota status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// mergeResourceList copies the quantities of in into out.
+func mergeResourceList(out *corev1.ResourceList, in corev1.ResourceList) {
+	if len(in) == 0 {
+		return
+	}
+	if *out == nil {
+		*out = make(corev1.ResourceList)
+	}
+	for k, v := range in {
+		(*out)[k] = v.DeepCopy()
+	}
+}
+
+// requestsForTemplate maps a size template to every LNamespace of that size.
+func (r *QuotaReconciler) requestsForTemplate(o client.Object) []reconcile.Request {
+	if o.GetNamespace() != r.TemplateNamespace || o.GetLabels()[LabelSize] == "" {
+		return nil
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := r.List(context.Background(), l); err != nil {
+		r.Log.Error(err, "unable to list namespaces for size template", "name", o.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, v := range l.Items {
+		if string(v.Spec.Size) == o.GetLabels()[LabelSize] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: v.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the QuotaReconciler with the provided manager
+func (r *QuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Owns(&corev1.ResourceQuota{}).
+		Owns(&corev1.LimitRange{}).
+		// changes to the templates are fanned out to every namespace of that size.
+		Watches(&source.Kind{
+			Type: &corev1.ResourceQuota{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForTemplate)).
+		Watches(&source.Kind{
+			Type: &corev1.LimitRange{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForTemplate)).
+		Complete(r)
+}
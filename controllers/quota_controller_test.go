@@ -0,0 +1,184 @@
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+)
+
+const TemplateNamespace = "namespace-controller-system"
+
+var _ = Describe("Quota Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var qr *controllers.QuotaReconciler
+	var k8sClient client.Client
+	var recorder *record.FakeRecorder
+
+	var reconcile = func() {
+		_, err := qr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{
+				Name: ns.Name,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	BeforeEach(func(done Done) {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		qr = &controllers.QuotaReconciler{
+			Client:            k8sClient,
+			Log:               logf.Log,
+			Recorder:          recorder,
+			TemplateNamespace: TemplateNamespace,
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: DefaultName,
+			},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Size: gialv1beta1.SizeSmall,
+			},
+		}
+		ctx = context.Background()
+		for size, cpu := range map[gialv1beta1.NamespaceSize]string{gialv1beta1.SizeSmall: "4", gialv1beta1.SizeLarge: "64"} {
+			Expect(k8sClient.Create(ctx, &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "compute-" + string(size),
+					Namespace: TemplateNamespace,
+					Labels:    map[string]string{controllers.LabelSize: string(size)},
+				},
+				Spec: corev1.ResourceQuotaSpec{
+					Hard: corev1.ResourceList{
+						corev1.ResourceRequestsCPU:           resource.MustParse(cpu),
+						corev1.ResourceServicesLoadBalancers: resource.MustParse("0"),
+					},
+				},
+			})).ToNot(HaveOccurred(), "Creating resource quota template should not have errored.")
+			Expect(k8sClient.Create(ctx, &corev1.LimitRange{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "defaults-" + string(size),
+					Namespace: TemplateNamespace,
+					Labels:    map[string]string{controllers.LabelSize: string(size)},
+				},
+				Spec: corev1.LimitRangeSpec{
+					Limits: []corev1.LimitRangeItem{{Type: corev1.LimitTypeContainer}},
+				},
+			})).ToNot(HaveOccurred(), "Creating limit range template should not have errored.")
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("copies the resource quota template of its size into the namespace", func(done Done) {
+		rq := &corev1.ResourceQuota{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "compute-small", Namespace: ns.Name}, rq)).ToNot(HaveOccurred())
+		Expect(rq.Spec.Hard[corev1.ResourceRequestsCPU]).To(Equal(resource.MustParse("4")))
+		Expect(rq.Spec.Hard[corev1.ResourceServicesLoadBalancers]).To(Equal(resource.MustParse("0")))
+		close(done)
+	}, TestTimeout)
+
+	It("copies the limit range template of its size into the namespace", func(done Done) {
+		lr := &corev1.LimitRange{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "defaults-small", Namespace: ns.Name}, lr)).ToNot(HaveOccurred())
+		Expect(lr.Spec.Limits).To(HaveLen(1))
+		close(done)
+	}, TestTimeout)
+
+	It("does not copy templates of other sizes", func(done Done) {
+		rq := &corev1.ResourceQuota{}
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: "compute-large", Namespace: ns.Name}, rq)
+		Expect(resourceState{resource: rq, err: err}).To(ExistAsAResource(false))
+		close(done)
+	}, TestTimeout)
+
+	When("the quota reports usage", func() {
+		JustBeforeEach(func(done Done) {
+			rq := &corev1.ResourceQuota{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "compute-small", Namespace: ns.Name}, rq)).ToNot(HaveOccurred())
+			rq.Status = corev1.ResourceQuotaStatus{
+				Hard: rq.Spec.Hard,
+				Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+			}
+			Expect(k8sClient.Update(ctx, rq)).ToNot(HaveOccurred())
+			reconcile()
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+			close(done)
+		}, TestTimeout)
+		It("reports the usage in the LNamespace status", func(done Done) {
+			Expect(ns.Status.Quota).ToNot(BeNil())
+			Expect(ns.Status.Quota.Used[corev1.ResourceRequestsCPU]).To(Equal(resource.MustParse("1")))
+			Expect(ns.Status.Quota.Hard[corev1.ResourceRequestsCPU]).To(Equal(resource.MustParse("4")))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the size changes", func() {
+		JustBeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeLarge
+			Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+			reconcile()
+			close(done)
+		}, TestTimeout)
+		It("replaces the quota of the previous size", func(done Done) {
+			rq := &corev1.ResourceQuota{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "compute-large", Namespace: ns.Name}, rq)).ToNot(HaveOccurred())
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "compute-small", Namespace: ns.Name}, rq)
+			Expect(resourceState{resource: rq, err: err}).To(ExistAsAResource(false))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the size is custom", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeCustom
+			ns.Spec.CustomSize = &gialv1beta1.CustomSize{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("10")},
+			}
+			close(done)
+		}, TestTimeout)
+		It("creates a resource quota from the custom size", func(done Done) {
+			rq := &corev1.ResourceQuota{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: controllers.CustomSizeName, Namespace: ns.Name}, rq)).ToNot(HaveOccurred())
+			Expect(rq.Spec.Hard[corev1.ResourceRequestsCPU]).To(Equal(resource.MustParse("10")))
+			close(done)
+		}, TestTimeout)
+		It("does not create a limit range", func(done Done) {
+			lr := &corev1.LimitRange{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: controllers.CustomSizeName, Namespace: ns.Name}, lr)
+			Expect(resourceState{resource: lr, err: err}).To(ExistAsAResource(false))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the size has no template", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeMedium
+			close(done)
+		}, TestTimeout)
+		It("warns that the namespace has no quota", func(done Done) {
+			Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("SizeTemplateMissing")))
+			close(done)
+		}, TestTimeout)
+	})
+})
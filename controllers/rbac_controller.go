@@ -19,19 +19,24 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
 	"github.com/loblaw-sre/namespace-controller/pkg/utils"
 	rbacv1 "k8s.io/api/rbac/v1"
 )
@@ -41,6 +46,9 @@ type RBACReconciler struct {
 	client.Client
 	Log      logr.Logger
 	Recorder record.EventRecorder
+	// ProdDeveloperRole is the ClusterRole bound to the developers of prod
+	// namespaces instead of admin. Defaults to view.
+	ProdDeveloperRole string
 }
 
 const (
@@ -56,11 +64,17 @@ const (
 	LabelManagerPermissions = "manager-permissions"
 	// LabelDeveloperPermissions value for all RBAC related to developer permissions
 	LabelDeveloperPermissions = "developer-permissions"
+
+	// DefaultProdDeveloperRole is the ClusterRole bound to developers of prod namespaces by default
+	DefaultProdDeveloperRole = "view"
+	// SuspendedDeveloperRole is the ClusterRole bound to developers of suspended namespaces
+	SuspendedDeveloperRole = "view"
 )
 
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/finalizers,verbs=update
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lteams,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=users;groups,verbs=impersonate
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings;roles;rolebindings,verbs=get;list;watch;create;update;patch;delete;bind
@@ -68,53 +82,18 @@ const (
 // UpdateSelfImpersonators ClusterRoles and Bindings
 func (r *RBACReconciler) UpdateSelfImpersonators(ctx context.Context, ns *gialv1beta1.LNamespace) error {
 	log := r.Log.WithValues("namespace", ns.Name)
+	owned := func(o client.Object) error {
+		return controllerutil.SetOwnerReference(ns, o, r.Scheme())
+	}
 	for _, v := range ns.Spec.Sudoers {
-		clusterRole := &rbacv1.ClusterRole{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: utils.Slug(v.Name) + "-impersonator",
-			},
-		}
+		clusterRole, clusterRoleBinding := selfImpersonator(v)
 		log.Info("updating clusterRole", "name", clusterRole.Name)
-		_, err := controllerutil.CreateOrPatch(ctx, r, clusterRole, func() error {
-			clusterRole.Rules = []rbacv1.PolicyRule{
-				{
-					APIGroups:     []string{""},
-					Verbs:         []string{"impersonate"},
-					ResourceNames: []string{v.Name},
-					Resources:     []string{"users"},
-				},
-			}
-			if clusterRole.Labels == nil {
-				clusterRole.Labels = make(map[string]string)
-			}
-			clusterRole.Labels[LabelKey] = LabelSelfImpersonator
-
-			return controllerutil.SetOwnerReference(ns, clusterRole, r.Scheme())
-		})
-		if err != nil {
+		if _, err := applyRBAC(ctx, r, clusterRole, owned); err != nil {
 			log.Error(err, "unable to create or update impersonator cluster role", "User", v.Name)
 			return err
 		}
-		clusterRoleBinding := &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: utils.Slug(v.Name) + "-impersonator",
-			},
-		}
-		log.Info("updating clusterRoleBinding", "name", clusterRole.Name)
-		_, err = controllerutil.CreateOrPatch(ctx, r, clusterRoleBinding, func() error {
-			clusterRoleBinding.RoleRef = rbacv1.RoleRef{
-				APIGroup: "rbac.authorization.k8s.io",
-				Name:     clusterRole.Name,
-				Kind:     "ClusterRole",
-			}
-			clusterRoleBinding.Subjects = []rbacv1.Subject{v}
-			if clusterRoleBinding.Labels == nil {
-				clusterRoleBinding.Labels = make(map[string]string)
-			}
-			clusterRoleBinding.Labels[LabelKey] = LabelSelfImpersonator
-			return controllerutil.SetOwnerReference(ns, clusterRoleBinding, r.Scheme())
-		})
-		if err != nil {
+		log.Info("updating clusterRoleBinding", "name", clusterRoleBinding.Name)
+		if _, err := applyRBAC(ctx, r, clusterRoleBinding, owned); err != nil {
 			log.Error(err, "unable to create or update impersonator cluster role binding", "User", v.Name)
 			return err
 		}
@@ -194,55 +173,17 @@ func (r *RBACReconciler) UpdateSelfImpersonators(ctx context.Context, ns *gialv1
 // UpdateSudoerGroupImpersonators ClusterRole and Binding
 func (r *RBACReconciler) UpdateSudoerGroupImpersonators(ctx context.Context, ns *gialv1beta1.LNamespace) error {
 	log := r.Log.WithValues("namespace", ns.Name)
-	{
-		sgr := &rbacv1.ClusterRole{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: ns.GetSudoersGroupName(),
-			},
-		}
-		_, err := controllerutil.CreateOrUpdate(ctx, r, sgr, func() error {
-			sgr.Rules = []rbacv1.PolicyRule{
-				{
-					APIGroups:     []string{""},
-					Verbs:         []string{"impersonate"},
-					ResourceNames: []string{ns.GetSudoersGroupName()},
-					Resources:     []string{"groups"},
-				},
-			}
-			if sgr.Labels == nil {
-				sgr.Labels = make(map[string]string)
-			}
-			sgr.Labels[LabelKey] = LabelSudoerImpersonator
-			return controllerutil.SetOwnerReference(ns, sgr, r.Scheme())
-		})
-		if err != nil {
-			log.Error(err, fmt.Sprintf("error updating sudoer group role"))
-			return err
-		}
+	owned := func(o client.Object) error {
+		return controllerutil.SetOwnerReference(ns, o, r.Scheme())
 	}
-	{
-		sgrb := &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: ns.GetSudoersGroupName(),
-			},
-		}
-		_, err := controllerutil.CreateOrUpdate(ctx, r, sgrb, func() error {
-			sgrb.RoleRef = rbacv1.RoleRef{
-				Name:     ns.GetSudoersGroupName(),
-				Kind:     "ClusterRole",
-				APIGroup: "rbac.authorization.k8s.io",
-			}
-			sgrb.Subjects = ns.Spec.Sudoers
-			if sgrb.Labels == nil {
-				sgrb.Labels = make(map[string]string)
-			}
-			sgrb.Labels[LabelKey] = LabelSudoerImpersonator
-			return controllerutil.SetOwnerReference(ns, sgrb, r.Scheme())
-		})
-		if err != nil {
-			log.Error(err, fmt.Sprintf("error updating sudoer group binding"))
-			return err
-		}
+	sgr, sgrb := sudoerGroupImpersonator(ns, time.Now())
+	if _, err := applyRBAC(ctx, r, sgr, owned); err != nil {
+		log.Error(err, "error updating sudoer group role")
+		return err
+	}
+	if _, err := applyRBAC(ctx, r, sgrb, owned); err != nil {
+		log.Error(err, "error updating sudoer group binding")
+		return err
 	}
 	return nil
 }
@@ -281,29 +222,12 @@ func (r *RBACReconciler) UpdateSudoerPermissions(ctx context.Context, ns *gialv1
 	}
 
 	// carb is the cluster-admin role binding inside of the namespace
-	{
-		carb := &rbacv1.RoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      ns.GetSudoersGroupName(),
-				Namespace: ns.Name,
-			},
-		}
-		_, err := controllerutil.CreateOrUpdate(ctx, r, carb, func() error {
-			if carb.Labels == nil {
-				carb.Labels = make(map[string]string)
-			}
-			carb.Labels[LabelKey] = LabelSudoerPermissions
-			carb.RoleRef = rbacv1.RoleRef{
-				Name: "cluster-admin",
-				Kind: "ClusterRole",
-			}
-			carb.Subjects = []rbacv1.Subject{{Name: ns.GetSudoersGroupName(), Kind: "Group"}}
-			return controllerutil.SetOwnerReference(ns, carb, r.Scheme())
-		})
-		if err != nil {
-			log.Error(err, "unable to create sudoer role binding to cluster-admin within the namespace")
-			return err
-		}
+	_, err := applyRBAC(ctx, r, sudoerRoleBinding(ns), func(o client.Object) error {
+		return controllerutil.SetOwnerReference(ns, o, r.Scheme())
+	})
+	if err != nil {
+		log.Error(err, "unable to create sudoer role binding to cluster-admin within the namespace")
+		return err
 	}
 	return nil
 }
@@ -311,29 +235,224 @@ func (r *RBACReconciler) UpdateSudoerPermissions(ctx context.Context, ns *gialv1
 // UpdateDeveloperPermissions updates developer permissions on the cluster
 func (r *RBACReconciler) UpdateDeveloperPermissions(ctx context.Context, ns *gialv1beta1.LNamespace) error {
 	log := r.Log.WithValues("namespace", ns.Name)
+	rb := developerRoleBinding(ns, r.ProdDeveloperRole)
+	previous, err := applyRBAC(ctx, r, rb, func(o client.Object) error {
+		return controllerutil.SetControllerReference(ns, o, r.Scheme())
+	})
+	if previous != "" {
+		r.Recorder.Eventf(ns, "Normal", "Update", "Rebinding developers from %s to %s", previous, rb.RoleRef.Name)
+	}
+	if err != nil {
+		log.Error(err, "unable to create developer role binding")
+		return err
+	}
+	return nil
+}
+
+// selfImpersonator returns the ClusterRole and ClusterRoleBinding that let
+// sudoer impersonate themselves. They are shared by every namespace of sudoer.
+func selfImpersonator(sudoer rbacv1.Subject) (*rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding) {
+	name := utils.Slug(sudoer.Name) + "-impersonator"
+	labels := map[string]string{LabelKey: LabelSelfImpersonator}
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Verbs:         []string{"impersonate"},
+				ResourceNames: []string{sudoer.Name},
+				Resources:     []string{"users"},
+			},
+		},
+	}
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		RoleRef:    clusterRoleRef(name),
+		Subjects:   []rbacv1.Subject{sudoer},
+	}
+	return cr, crb
+}
+
+// sudoerGroupImpersonator returns the ClusterRole and ClusterRoleBinding that
+// let the sudoers of ns that are active at now impersonate its sudoers group.
+func sudoerGroupImpersonator(ns *gialv1beta1.LNamespace, now time.Time) (*rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding) {
+	name := ns.GetSudoersGroupName()
+	labels := map[string]string{LabelKey: LabelSudoerImpersonator}
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Verbs:         []string{"impersonate"},
+				ResourceNames: []string{name},
+				Resources:     []string{"groups"},
+			},
+		},
+	}
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		RoleRef:    clusterRoleRef(name),
+	}
+	crb.Subjects, _ = activeSudoers(ns, now)
+	return cr, crb
+}
+
+// sudoerRoleBinding returns the RoleBinding granting the sudoers group of ns
+// cluster-admin within its namespace.
+func sudoerRoleBinding(ns *gialv1beta1.LNamespace) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ns.GetSudoersGroupName(),
+			Namespace: ns.Name,
+			Labels:    map[string]string{LabelKey: LabelSudoerPermissions},
+		},
+		RoleRef:  clusterRoleRef("cluster-admin"),
+		Subjects: []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Name: ns.GetSudoersGroupName(), Kind: "Group"}},
+	}
+}
+
+// developerRoleBinding returns the RoleBinding of the developers of ns, given
+// the role for prod namespaces.
+func developerRoleBinding(ns *gialv1beta1.LNamespace, prodRole string) *rbacv1.RoleBinding {
 	rb := &rbacv1.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "developer",
 			Namespace: ns.Name,
+			Labels:    map[string]string{LabelKey: LabelDeveloperPermissions},
 		},
+		RoleRef:  clusterRoleRef(developerRole(ns, prodRole)),
+		Subjects: ns.Spec.Developers,
 	}
-	_, err := controllerutil.CreateOrUpdate(ctx, r, rb, func() error {
-		if rb.Labels == nil {
-			rb.Labels = make(map[string]string)
+	// soft deleted namespaces are only accessible to their managers
+	if ns.PendingDeletion() {
+		rb.Subjects = nil
+	}
+	return rb
+}
+
+// clusterRoleRef returns a reference to the ClusterRole name.
+func clusterRoleRef(name string) rbacv1.RoleRef {
+	return rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+}
+
+// applyRBAC creates or updates the ClusterRole, ClusterRoleBinding or
+// RoleBinding desired with c, setting its labels, rules, role reference and
+// subjects to those of desired. owned is called to set up the ownership of
+// the object. The roleRef of a binding is immutable, so a binding to another
+// role is recreated, e.g. when a namespace is promoted to prod. The role it
+// was bound to before is returned in that case.
+func applyRBAC(ctx context.Context, c client.Client, desired client.Object, owned func(client.Object) error) (string, error) {
+	o := newRBAC(desired)
+	var previous string
+	if ref, ok := roleRefOf(desired); ok {
+		err := c.Get(ctx, client.ObjectKeyFromObject(o), o)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", errors.Wrapf(err, "unable to get %s", o.GetName())
+		}
+		if current, _ := roleRefOf(o); err == nil && (current.Kind != ref.Kind || current.Name != ref.Name) {
+			if err := c.Delete(ctx, o); err != nil && !apierrors.IsNotFound(err) {
+				return "", errors.Wrapf(err, "unable to delete %s bound to %s", o.GetName(), current.Name)
+			}
+			previous = current.Name
+			o = newRBAC(desired)
 		}
-		rb.Labels[LabelKey] = LabelDeveloperPermissions
-		rb.RoleRef = rbacv1.RoleRef{
-			Name: "admin",
-			Kind: "ClusterRole",
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, c, o, func() error {
+		l := o.GetLabels()
+		if l == nil {
+			l = make(map[string]string)
 		}
-		rb.Subjects = ns.Spec.Developers
-		return controllerutil.SetControllerReference(ns, rb, r.Scheme())
+		for k, v := range desired.GetLabels() {
+			l[k] = v
+		}
+		o.SetLabels(l)
+		switch d := desired.(type) {
+		case *rbacv1.ClusterRole:
+			o.(*rbacv1.ClusterRole).Rules = d.Rules
+		case *rbacv1.ClusterRoleBinding:
+			o.(*rbacv1.ClusterRoleBinding).RoleRef = d.RoleRef
+			o.(*rbacv1.ClusterRoleBinding).Subjects = d.Subjects
+		case *rbacv1.RoleBinding:
+			o.(*rbacv1.RoleBinding).RoleRef = d.RoleRef
+			o.(*rbacv1.RoleBinding).Subjects = d.Subjects
+		}
+		return owned(o)
 	})
 	if err != nil {
-		log.Error(err, "unable to create developer role binding")
-		return err
+		return previous, errors.Wrapf(err, "unable to create or update %s", o.GetName())
 	}
-	return nil
+	return previous, nil
+}
+
+// newRBAC returns an empty object of the kind of desired, with its name and
+// namespace.
+func newRBAC(desired client.Object) client.Object {
+	meta := metav1.ObjectMeta{Name: desired.GetName(), Namespace: desired.GetNamespace()}
+	switch desired.(type) {
+	case *rbacv1.ClusterRole:
+		return &rbacv1.ClusterRole{ObjectMeta: meta}
+	case *rbacv1.ClusterRoleBinding:
+		return &rbacv1.ClusterRoleBinding{ObjectMeta: meta}
+	case *rbacv1.RoleBinding:
+		return &rbacv1.RoleBinding{ObjectMeta: meta}
+	}
+	panic(fmt.Sprintf("unsupported RBAC object %T", desired))
+}
+
+// roleRefOf returns the role reference of o, if it is a binding.
+func roleRefOf(o client.Object) (rbacv1.RoleRef, bool) {
+	switch b := o.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		return b.RoleRef, true
+	case *rbacv1.RoleBinding:
+		return b.RoleRef, true
+	}
+	return rbacv1.RoleRef{}, false
+}
+
+// developerRole returns the ClusterRole bound to the developers of ns, given
+// the role for prod namespaces. Developers of suspended namespaces lose write
+// access until it is resumed.
+func developerRole(ns *gialv1beta1.LNamespace, prodRole string) string {
+	if ns.Spec.Suspended {
+		return SuspendedDeveloperRole
+	}
+	if ns.Spec.Env() != gialv1beta1.EnvironmentProd {
+		return "admin"
+	}
+	if prodRole == "" {
+		return DefaultProdDeveloperRole
+	}
+	return prodRole
+}
+
+// activeSudoers returns the sudoers of ns that may sudo at now. In prod,
+// only sudoers with an open sudo session may sudo. The returned duration is
+// the time until the first of the open sessions expires, or 0 if none does.
+// Soft deleted namespaces have no active sudoers.
+func activeSudoers(ns *gialv1beta1.LNamespace, now time.Time) ([]rbacv1.Subject, time.Duration) {
+	if ns.PendingDeletion() {
+		return []rbacv1.Subject{}, 0
+	}
+	if ns.Spec.Env() != gialv1beta1.EnvironmentProd {
+		return ns.Spec.Sudoers, 0
+	}
+	var next time.Duration
+	active := []rbacv1.Subject{}
+	for _, v := range ns.Spec.Sudoers {
+		for _, session := range ns.Spec.SudoSessions {
+			remaining := session.Expires.Sub(now)
+			if session.Name != v.Name || remaining <= 0 {
+				continue
+			}
+			active = append(active, v)
+			if next == 0 || remaining < next {
+				next = remaining
+			}
+			break
+		}
+	}
+	return active, next
 }
 
 // UpdateManagerPermissions updates manager permissions on the cluster
@@ -421,6 +540,16 @@ func (r *RBACReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 			return ctrl.Result{}, nil
 		}
 	}
+	if ns.Retained() {
+		log.Info("namespace is to be retained. Continuing without updating dependents.")
+		return ctrl.Result{}, nil
+	}
+	// access is granted to the subjects inherited from parent namespaces as well
+	ns, err = hierarchy.Resolve(ctx, r, ns)
+	if err != nil {
+		log.Error(err, "unable to resolve parent namespaces")
+		return ctrl.Result{}, err
+	}
 
 	err = r.UpdateSelfImpersonators(ctx, ns)
 	if err != nil {
@@ -451,6 +580,11 @@ func (r *RBACReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		log.Error(err, "unable to update developer permissions")
 		return ctrl.Result{}, err
 	}
+
+	// revoke sudo once the next session expires
+	if _, next := activeSudoers(ns, time.Now()); next > 0 {
+		return ctrl.Result{RequeueAfter: next}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -459,6 +593,12 @@ func (r *RBACReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gialv1beta1.LNamespace{}).
 		Owns(&rbacv1.RoleBinding{}).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LNamespace{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForDescendants(r, r.Log)), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{
+			Type: &gialv1beta1.LTeam{},
+		}, handler.EnqueueRequestsFromMapFunc(requestsForTeam(r, r.Log))).
 		// this controller does not use Owns for ClusterRoles and
 		// ClusterRoleBindings, because RBAC resources should update all LNamespaces
 		// in its ownerReferences for self impersonator cluster roles and bindings.
@@ -3,6 +3,7 @@ package controllers_test
 import (
 	"context"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -304,6 +305,187 @@ var _ = Describe("RBAC Controller", func() {
 		})
 	})
 
+	Context("child namespace", func() {
+		var child *gialv1beta1.LNamespace
+
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(ctx, &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "team"},
+				Spec: gialv1beta1.LNamespaceSpec{
+					Managers:   []rbacv1.Subject{{Name: alice, Kind: "User"}},
+					Developers: []rbacv1.Subject{{Name: bob, Kind: "User"}},
+				},
+			})).ToNot(HaveOccurred())
+			child = &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Spec: gialv1beta1.LNamespaceSpec{
+					Parent:     "team",
+					Developers: []rbacv1.Subject{{Name: john, Kind: "User"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, child)).ToNot(HaveOccurred())
+			_, err := rbacr.Reconcile(ctx, controllerruntime.Request{NamespacedName: types.NamespacedName{Name: child.Name}})
+			Expect(err).ToNot(HaveOccurred(), "Reconcile should not have errored.")
+			close(done)
+		}, TestTimeout)
+
+		It("inherits the managers of its parent", func(done Done) {
+			crb := &rbacv1.ClusterRoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: child.Name + "-manager"}, crb)).ToNot(HaveOccurred())
+			Expect(crb.Subjects).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"Name": Equal(alice)})))
+			close(done)
+		}, TestTimeout)
+
+		It("adds its own developers to those of its parent", func(done Done) {
+			rb := &rbacv1.RoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "developer", Namespace: child.Name}, rb)).ToNot(HaveOccurred())
+			Expect(rb.Subjects).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Name": Equal(bob)}),
+				MatchFields(IgnoreExtras, Fields{"Name": Equal(john)}),
+			))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Context("namespace of a team", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(ctx, &gialv1beta1.LTeam{
+				ObjectMeta: metav1.ObjectMeta{Name: "pharmacy"},
+				Spec: gialv1beta1.LTeamSpec{
+					Managers: []rbacv1.Subject{{Name: alice, Kind: "User"}},
+				},
+			})).ToNot(HaveOccurred())
+			Expect(k8sClient.Create(ctx, &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Spec: gialv1beta1.LNamespaceSpec{
+					Teams:    []string{"pharmacy"},
+					Managers: []rbacv1.Subject{{Name: bob, Kind: "User"}},
+				},
+			})).ToNot(HaveOccurred())
+			_, err := rbacr.Reconcile(ctx, controllerruntime.Request{NamespacedName: types.NamespacedName{Name: DefaultName}})
+			Expect(err).ToNot(HaveOccurred(), "Reconcile should not have errored.")
+			close(done)
+		}, TestTimeout)
+
+		It("grants access to the members of the team as well", func(done Done) {
+			crb := &rbacv1.ClusterRoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName + "-manager"}, crb)).ToNot(HaveOccurred())
+			Expect(crb.Subjects).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Name": Equal(alice)}),
+				MatchFields(IgnoreExtras, Fields{"Name": Equal(bob)}),
+			))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Context("prod namespace", func() {
+		var ns *gialv1beta1.LNamespace
+		var result controllerruntime.Result
+
+		var reconcile = func() {
+			var err error
+			result, err = rbacr.Reconcile(ctx, controllerruntime.Request{NamespacedName: types.NamespacedName{Name: ns.Name}})
+			Expect(err).ToNot(HaveOccurred(), "Reconcile should not have errored.")
+		}
+
+		// binding returns the role binding with the given name in the namespace
+		var binding = func(name string) *rbacv1.RoleBinding {
+			rb := &rbacv1.RoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: ns.Name}, rb)).ToNot(HaveOccurred())
+			return rb
+		}
+
+		BeforeEach(func(done Done) {
+			ns = &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultName,
+				},
+				Spec: gialv1beta1.LNamespaceSpec{
+					Environment: gialv1beta1.EnvironmentProd,
+					Sudoers: []rbacv1.Subject{
+						{Name: john, Kind: "User"},
+						{Name: alice, Kind: "User"},
+					},
+					SudoSessions: []gialv1beta1.SudoSession{
+						{Name: john, Expires: metav1.NewTime(time.Now().Add(time.Hour))},
+						{Name: alice, Expires: metav1.NewTime(time.Now().Add(-time.Hour))},
+					},
+					Developers: []rbacv1.Subject{
+						{Name: bob, Kind: "User"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating namespace %s should not have errored.", ns.Name)
+			reconcile()
+			close(done)
+		}, TestTimeout)
+
+		It("binds developers to the read-only role", func(done Done) {
+			Expect(binding("developer").RoleRef.Name).To(Equal(controllers.DefaultProdDeveloperRole))
+			close(done)
+		}, TestTimeout)
+
+		It("only allows sudoers with an open session to sudo", func(done Done) {
+			crb := &rbacv1.ClusterRoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.GetSudoersGroupName()}, crb)).ToNot(HaveOccurred())
+			Expect(crb.Subjects).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"Name": Equal(john)})))
+			close(done)
+		}, TestTimeout)
+
+		It("requeues to revoke sudo when the session expires", func(done Done) {
+			Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+			close(done)
+		}, TestTimeout)
+
+		When("the namespace moves to dev", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+				ns.Spec.Environment = gialv1beta1.EnvironmentDev
+				Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+				reconcile()
+				close(done)
+			}, TestTimeout)
+
+			It("rebinds developers to admin", func(done Done) {
+				rb := binding("developer")
+				Expect(rb.RoleRef.Name).To(Equal("admin"))
+				Expect(rb.Subjects).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"Name": Equal(bob)})))
+				close(done)
+			}, TestTimeout)
+
+			It("removes write access from developers while suspended", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+				ns.Spec.Suspended = true
+				Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+				reconcile()
+				Expect(binding("developer").RoleRef.Name).To(Equal(controllers.SuspendedDeveloperRole))
+				close(done)
+			}, TestTimeout)
+
+			It("revokes developer and sudoer access while pending deletion", func(done Done) {
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+				ns.Spec.DeletionPolicy = gialv1beta1.DeletionPolicySoftDelete
+				ns.Finalizers = []string{gialv1beta1.FinalizerDeletionPolicy}
+				deleted := metav1.Now()
+				ns.DeletionTimestamp = &deleted
+				Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+				reconcile()
+				Expect(binding("developer").Subjects).To(BeEmpty())
+				crb := &rbacv1.ClusterRoleBinding{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.GetSudoersGroupName()}, crb)).ToNot(HaveOccurred())
+				Expect(crb.Subjects).To(BeEmpty())
+				close(done)
+			}, TestTimeout)
+
+			It("allows every sudoer to sudo", func(done Done) {
+				crb := &rbacv1.ClusterRoleBinding{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.GetSudoersGroupName()}, crb)).ToNot(HaveOccurred())
+				Expect(crb.Subjects).To(HaveLen(2))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
 	Context("Two namespaces", func() {
 		BeforeEach(func(done Done) {
 			nsList = []*gialv1beta1.LNamespace{
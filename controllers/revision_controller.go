@@ -0,0 +1,295 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/revision"
+)
+
+// DefaultRevisionHistoryLimit is how many revisions are kept per LNamespace by default.
+const DefaultRevisionHistoryLimit = 10
+
+// DefaultRevisionDeletedRetention is how long the revisions of a deleted
+// LNamespace are kept by default.
+const DefaultRevisionDeletedRetention = 30 * 24 * time.Hour
+
+// RevisionReconciler records each distinct spec of an LNamespace as a
+// ControllerRevision, so that earlier specs can be inspected and restored.
+type RevisionReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// Namespace is the namespace that revisions are stored in, out of reach
+	// of the sudoers of the LNamespaces.
+	Namespace string
+	// HistoryLimit is how many revisions are kept per LNamespace. Defaults to
+	// DefaultRevisionHistoryLimit.
+	HistoryLimit int
+	// DeletedRetention is how long the revisions of a deleted LNamespace are
+	// kept, so that it can be recreated from them. Defaults to
+	// DefaultRevisionDeletedRetention.
+	DeletedRetention time.Duration
+	// RevisionCache caches the ControllerRevisions of Namespace, so that the
+	// revisions of other namespaces are neither watched nor readable. It is
+	// required by SetupWithManager, while Reconcile falls back to the client
+	// without it.
+	RevisionCache cache.Cache
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// The revisions are written through the Role in deploy/rbac/revision_role.yaml,
+// which is bound in the revision namespace only.
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *RevisionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		return r.retain(ctx, log, req.Name)
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	if !ns.DeletionTimestamp.IsZero() {
+		log.Info("namespace is being deleted. Continuing without recording its spec.")
+		return ctrl.Result{}, nil
+	}
+
+	hash, err := revision.Hash(&ns.Spec)
+	if err != nil {
+		log.Error(err, "unable to hash spec")
+		return ctrl.Result{}, err
+	}
+	revisions, err := revision.List(ctx, r.reader(), r.Namespace, ns.Name)
+	if err != nil {
+		log.Error(err, "unable to list revisions")
+		return ctrl.Result{}, err
+	}
+	var latest int64
+	var existing *appsv1.ControllerRevision
+	for i, v := range revisions {
+		// a namespace recreated under the same name takes over the history
+		if _, ok := v.Annotations[revision.AnnotationDeleted]; ok {
+			delete(revisions[i].Annotations, revision.AnnotationDeleted)
+			if err := r.Update(ctx, &revisions[i]); err != nil {
+				log.Error(err, "unable to update revision", "revision", v.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		if v.Revision > latest {
+			latest = v.Revision
+		}
+		if v.Name == revisionName(ns, hash) {
+			existing = &revisions[i]
+		}
+	}
+	author := revision.Author(ns)
+
+	switch {
+	case existing != nil && existing.Revision == latest:
+		// the spec did not change since the latest revision
+	case existing != nil:
+		// a spec that returns to an earlier revision becomes the latest one
+		existing.Revision = latest + 1
+		setAuthor(existing, author)
+		if err := r.Update(ctx, existing); err != nil {
+			log.Error(err, "unable to update revision", "revision", existing.Name)
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(ns, "Normal", "Revision", "Spec returned to an earlier revision, now revision %d, changed by %s", existing.Revision, authorOrUnknown(author))
+	default:
+		data, err := json.Marshal(revision.Recorded(&ns.Spec))
+		if err != nil {
+			log.Error(err, "unable to encode spec")
+			return ctrl.Result{}, err
+		}
+		rev := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      revisionName(ns, hash),
+				Namespace: r.Namespace,
+				Labels:    map[string]string{gialv1beta1.LabelLNamespace: ns.Name},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: latest + 1,
+		}
+		setAuthor(rev, author)
+		if err := r.Create(ctx, rev); err != nil {
+			log.Error(err, "unable to create revision", "revision", rev.Name)
+			return ctrl.Result{}, err
+		}
+		revisions = append(revisions, *rev)
+		r.Recorder.Eventf(ns, "Normal", "Revision", "Recorded revision %d of the spec, changed by %s", rev.Revision, authorOrUnknown(author))
+	}
+
+	// a renumbered revision moves to the end of the history, so the
+	// revisions are sorted again before the oldest ones are pruned
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	limit := r.HistoryLimit
+	if limit <= 0 {
+		limit = DefaultRevisionHistoryLimit
+	}
+	if len(revisions) > limit {
+		for i := range revisions[:len(revisions)-limit] {
+			if err := r.Delete(ctx, &revisions[i]); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "unable to prune revision", "revision", revisions[i].Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// retain keeps the revisions of a deleted LNamespace for DeletedRetention
+// after its deletion was noticed, then deletes them.
+func (r *RevisionReconciler) retain(ctx context.Context, log logr.Logger, name string) (ctrl.Result, error) {
+	revisions, err := revision.List(ctx, r.reader(), r.Namespace, name)
+	if err != nil {
+		log.Error(err, "unable to list revisions")
+		return ctrl.Result{}, err
+	}
+	if len(revisions) == 0 {
+		return ctrl.Result{}, nil
+	}
+	retention := r.DeletedRetention
+	if retention <= 0 {
+		retention = DefaultRevisionDeletedRetention
+	}
+	now := time.Now()
+	deleted := now
+	for i := range revisions {
+		v, ok := revisions[i].Annotations[revision.AnnotationDeleted]
+		if !ok {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil && t.Before(deleted) {
+			deleted = t
+		}
+	}
+	if deleteAt := deleted.Add(retention); now.Before(deleteAt) {
+		for i := range revisions {
+			if _, ok := revisions[i].Annotations[revision.AnnotationDeleted]; ok {
+				continue
+			}
+			if revisions[i].Annotations == nil {
+				revisions[i].Annotations = make(map[string]string)
+			}
+			revisions[i].Annotations[revision.AnnotationDeleted] = deleted.UTC().Format(time.RFC3339)
+			if err := r.Update(ctx, &revisions[i]); err != nil {
+				log.Error(err, "unable to update revision", "revision", revisions[i].Name)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: time.Until(deleteAt)}, nil
+	}
+	log.Info("namespace was deleted beyond the retention. Deleting its revisions.")
+	for i := range revisions {
+		if err := r.Delete(ctx, &revisions[i]); err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to delete revision", "revision", revisions[i].Name)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// reader returns the reader of the revisions.
+func (r *RevisionReconciler) reader() client.Reader {
+	if r.RevisionCache != nil {
+		return r.RevisionCache
+	}
+	return r.Client
+}
+
+// requestsForRevision maps a revision to its LNamespace, so that the
+// revisions of LNamespaces deleted while the manager was down are retained
+// and deleted too.
+func (r *RevisionReconciler) requestsForRevision(o client.Object) []reconcile.Request {
+	name, ok := o.GetLabels()[gialv1beta1.LabelLNamespace]
+	if !ok || o.GetNamespace() != r.Namespace {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: name}}}
+}
+
+// revisionName returns the name of the revision of ns whose spec has hash.
+func revisionName(ns *gialv1beta1.LNamespace, hash string) string {
+	return ns.Name + "-" + hash
+}
+
+func setAuthor(rev *appsv1.ControllerRevision, author string) {
+	if author == "" {
+		delete(rev.Annotations, revision.AnnotationAuthor)
+		return
+	}
+	if rev.Annotations == nil {
+		rev.Annotations = make(map[string]string)
+	}
+	rev.Annotations[revision.AnnotationAuthor] = author
+}
+
+func authorOrUnknown(author string) string {
+	if author == "" {
+		return "an unknown client"
+	}
+	return author
+}
+
+// SetupWithManager sets up the RevisionReconciler with the provided manager
+func (r *RevisionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.RevisionCache == nil {
+		return errors.New("a cache of the revision namespace is required")
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(source.NewKindWithCache(&appsv1.ControllerRevision{}, r.RevisionCache), handler.EnqueueRequestsFromMapFunc(r.requestsForRevision), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(event.UpdateEvent) bool { return false },
+		})).
+		Complete(r)
+}
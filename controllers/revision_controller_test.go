@@ -0,0 +1,194 @@
+package controllers_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	"github.com/loblaw-sre/namespace-controller/pkg/revision"
+	. "github.com/onsi/gomega"
+)
+
+const RevisionNamespace = "revisions"
+
+var _ = Describe("Revision Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var rr *controllers.RevisionReconciler
+	var recorder *record.FakeRecorder
+	var k8sClient client.Client
+
+	var reconcile = func() {
+		_, err := rr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: DefaultName},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	// setSudoers changes the sudoers of the LNamespace as manager would.
+	var setSudoers = func(manager string, subjects ...rbacv1.Subject) {
+		lns := &gialv1beta1.LNamespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		lns.Spec.Sudoers = subjects
+		now := metav1.Now()
+		lns.ManagedFields = append(lns.ManagedFields, metav1.ManagedFieldsEntry{
+			Manager:  manager,
+			Time:     &now,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:sudoers":{}}}`)},
+		})
+		Expect(k8sClient.Update(ctx, lns)).ToNot(HaveOccurred())
+		reconcile()
+	}
+
+	var revisions = func() map[int64][]string {
+		l, err := revision.List(ctx, k8sClient, RevisionNamespace, DefaultName)
+		Expect(err).ToNot(HaveOccurred())
+		res := make(map[int64][]string)
+		for i := range l {
+			spec, err := revision.Spec(&l[i])
+			Expect(err).ToNot(HaveOccurred())
+			var names []string
+			for _, v := range spec.Sudoers {
+				names = append(names, v.Name)
+			}
+			res[l[i].Revision] = names
+		}
+		return res
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		rr = &controllers.RevisionReconciler{
+			Client:       k8sClient,
+			Log:          logf.Log,
+			Recorder:     recorder,
+			Namespace:    RevisionNamespace,
+			HistoryLimit: 3,
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Spec: gialv1beta1.LNamespaceSpec{
+				Sudoers: []rbacv1.Subject{{Kind: "User", Name: john}},
+				Billing: map[string]string{"budget": "1.0"},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("should record the spec with its author", func(done Done) {
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: alice})
+		l, err := revision.List(ctx, k8sClient, RevisionNamespace, DefaultName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).To(HaveLen(2))
+		Expect(l[1].Revision).To(Equal(int64(2)))
+		Expect(l[1].Annotations).To(HaveKeyWithValue(revision.AnnotationAuthor, "kubectl-edit"))
+		Expect(l[1].OwnerReferences).To(BeEmpty(), "Revisions should outlive their LNamespace.")
+		Expect(revisions()).To(Equal(map[int64][]string{1: {john}, 2: {alice}}))
+		Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Recorded revision 1")))
+		Eventually(recorder.Events, EventuallyTimeout).Should(Receive(ContainSubstring("Recorded revision 2 of the spec, changed by kubectl-edit")))
+		close(done)
+	}, TestTimeout)
+
+	It("should not record a revision when only sudo sessions change", func(done Done) {
+		lns := &gialv1beta1.LNamespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, lns)).ToNot(HaveOccurred())
+		lns.Spec.SudoSessions = []gialv1beta1.SudoSession{{Name: john, Expires: metav1.NewTime(time.Now().Add(time.Hour))}}
+		Expect(k8sClient.Update(ctx, lns)).ToNot(HaveOccurred())
+		reconcile()
+		Expect(revisions()).To(Equal(map[int64][]string{1: {john}}))
+		close(done)
+	}, TestTimeout)
+
+	It("should renumber a revision the spec returns to", func(done Done) {
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: alice})
+		setSudoers("lns-history", rbacv1.Subject{Kind: "User", Name: john})
+		Expect(revisions()).To(Equal(map[int64][]string{2: {alice}, 3: {john}}))
+		close(done)
+	}, TestTimeout)
+
+	It("should prune the oldest revisions beyond the history limit", func(done Done) {
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: alice})
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: bob})
+		setSudoers("kubectl-edit")
+		Expect(revisions()).To(Equal(map[int64][]string{2: {alice}, 3: {bob}, 4: nil}))
+		close(done)
+	}, TestTimeout)
+
+	It("should prune the oldest revisions when the history limit is lowered as the spec returns to one", func(done Done) {
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: alice})
+		setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: bob})
+		rr.HistoryLimit = 2
+		setSudoers("lns-history", rbacv1.Subject{Kind: "User", Name: john})
+		Expect(revisions()).To(Equal(map[int64][]string{3: {bob}, 4: {john}}))
+		close(done)
+	}, TestTimeout)
+
+	When("the LNamespace is deleted", func() {
+		JustBeforeEach(func(done Done) {
+			setSudoers("kubectl-edit", rbacv1.Subject{Kind: "User", Name: alice})
+			Expect(k8sClient.Delete(ctx, ns)).ToNot(HaveOccurred())
+			res, err := rr.Reconcile(ctx, controllerruntime.Request{
+				NamespacedName: types.NamespacedName{Name: DefaultName},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.RequeueAfter).To(BeNumerically("~", controllers.DefaultRevisionDeletedRetention, time.Minute))
+			close(done)
+		}, TestTimeout)
+
+		It("should keep its revisions for the retention", func(done Done) {
+			Expect(revisions()).To(Equal(map[int64][]string{1: {john}, 2: {alice}}))
+			l, err := revision.List(ctx, k8sClient, RevisionNamespace, DefaultName)
+			Expect(err).ToNot(HaveOccurred())
+			for _, v := range l {
+				Expect(v.Annotations).To(HaveKey(revision.AnnotationDeleted))
+			}
+			close(done)
+		}, TestTimeout)
+
+		It("should delete its revisions once the retention has passed", func(done Done) {
+			l, err := revision.List(ctx, k8sClient, RevisionNamespace, DefaultName)
+			Expect(err).ToNot(HaveOccurred())
+			l[0].Annotations[revision.AnnotationDeleted] = time.Now().Add(-controllers.DefaultRevisionDeletedRetention - time.Hour).Format(time.RFC3339)
+			Expect(k8sClient.Update(ctx, &l[0])).ToNot(HaveOccurred())
+			reconcile()
+			Expect(revisions()).To(BeEmpty())
+			close(done)
+		}, TestTimeout)
+
+		It("should hand the revisions over to an LNamespace recreated under the same name", func(done Done) {
+			recreated := &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+				Spec:       gialv1beta1.LNamespaceSpec{Sudoers: []rbacv1.Subject{{Kind: "User", Name: bob}}},
+			}
+			Expect(k8sClient.Create(ctx, recreated)).ToNot(HaveOccurred())
+			reconcile()
+			l, err := revision.List(ctx, k8sClient, RevisionNamespace, DefaultName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l).To(HaveLen(3))
+			for _, v := range l {
+				Expect(v.Annotations).ToNot(HaveKey(revision.AnnotationDeleted))
+			}
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -0,0 +1,390 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+const (
+	// AnnotationSuspendedReplicas holds the replicas of a Deployment or StatefulSet before its namespace was suspended
+	AnnotationSuspendedReplicas = "gial.lblw.dev/suspended-replicas"
+	// AnnotationSuspendedCronJob holds the suspend flag of a CronJob before its namespace was suspended
+	AnnotationSuspendedCronJob = "gial.lblw.dev/suspended-cronjob"
+	// AnnotationSuspendedScaleUp holds the scale up rules of a HorizontalPodAutoscaler before its namespace was suspended
+	AnnotationSuspendedScaleUp = "gial.lblw.dev/suspended-scale-up"
+)
+
+// SuspendReconciler hibernates the workloads of suspended namespaces
+type SuspendReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gial.lblw.dev,resources=lnamespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;update;patch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
+func (r *SuspendReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("namespace", req.Name)
+
+	ns := &gialv1beta1.LNamespace{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+	}, ns)
+	if apierrors.IsNotFound(err) {
+		log.Info("namespace not found. Continuing as if deleted.")
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "unable to get namespace definition")
+		return ctrl.Result{}, err
+	}
+	for _, v := range ns.Finalizers {
+		if v == metav1.FinalizerOrphanDependents {
+			log.Info("namespace is to be orphaned. Continuing without updating dependents.")
+			return ctrl.Result{}, nil
+		}
+	}
+
+	changed := false
+	// soft deleted namespaces are kept scaled down until they are restored or deleted
+	if ns.Spec.Suspended || ns.PendingDeletion() {
+		// autoscalers are paused first, so that they do not scale workloads back up
+		paused, err := r.pauseAutoscalers(ctx, ns.Name)
+		if err != nil {
+			log.Error(err, "unable to pause autoscalers")
+			return ctrl.Result{}, err
+		}
+		if paused > 0 {
+			r.Recorder.Eventf(ns, "Normal", "Suspend", "Paused scaling up of %d autoscalers", paused)
+		}
+		scaled, suspended, err := r.suspend(ctx, ns.Name)
+		if err != nil {
+			log.Error(err, "unable to suspend workloads")
+			return ctrl.Result{}, err
+		}
+		if scaled+suspended > 0 {
+			r.Recorder.Eventf(ns, "Normal", "Suspend", "Scaled down %d workloads and suspended %d cron jobs", scaled, suspended)
+		}
+		changed = setCondition(&ns.Status.Conditions, metav1.Condition{
+			Type:    gialv1beta1.ConditionSuspended,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Suspended",
+			Message: "workloads are scaled down and cron jobs are suspended",
+		})
+	} else {
+		scaled, resumed, err := r.resume(ctx, ns.Name)
+		if err != nil {
+			log.Error(err, "unable to resume workloads")
+			return ctrl.Result{}, err
+		}
+		if scaled+resumed > 0 {
+			r.Recorder.Eventf(ns, "Normal", "Resume", "Restored %d workloads and %d cron jobs", scaled, resumed)
+		}
+		unpaused, err := r.resumeAutoscalers(ctx, ns.Name)
+		if err != nil {
+			log.Error(err, "unable to resume autoscalers")
+			return ctrl.Result{}, err
+		}
+		if unpaused > 0 {
+			r.Recorder.Eventf(ns, "Normal", "Resume", "Restored scaling up of %d autoscalers", unpaused)
+		}
+		changed = meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionSuspended) != nil
+		meta.RemoveStatusCondition(&ns.Status.Conditions, gialv1beta1.ConditionSuspended)
+	}
+	if changed {
+		if err := r.Status().Update(ctx, ns); err != nil {
+			log.Error(err, "unable to update suspended condition")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// suspend scales the Deployments and StatefulSets of the namespace to zero
+// and suspends its CronJobs, recording their previous state in annotations.
+// Workloads that were scaled up again while suspended are scaled back down.
+func (r *SuspendReconciler) suspend(ctx context.Context, namespace string) (int, int, error) {
+	scaled := 0
+	scaleDown := func(o client.Object, replicas **int32) error {
+		if *replicas != nil && **replicas == 0 && o.GetAnnotations()[AnnotationSuspendedReplicas] != "" {
+			return nil
+		}
+		patch := client.MergeFrom(o.DeepCopyObject().(client.Object))
+		annotations := o.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		if _, ok := annotations[AnnotationSuspendedReplicas]; !ok {
+			previous := int32(1)
+			if *replicas != nil {
+				previous = **replicas
+			}
+			annotations[AnnotationSuspendedReplicas] = strconv.Itoa(int(previous))
+		}
+		o.SetAnnotations(annotations)
+		zero := int32(0)
+		*replicas = &zero
+		scaled++
+		return r.Patch(ctx, o, patch)
+	}
+
+	dl := &appsv1.DeploymentList{}
+	if err := r.List(ctx, dl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range dl.Items {
+		if err := scaleDown(&dl.Items[i], &dl.Items[i].Spec.Replicas); err != nil {
+			return 0, 0, err
+		}
+	}
+	ssl := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, ssl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range ssl.Items {
+		if err := scaleDown(&ssl.Items[i], &ssl.Items[i].Spec.Replicas); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	suspended := 0
+	cl := &batchv1beta1.CronJobList{}
+	if err := r.List(ctx, cl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range cl.Items {
+		cj := &cl.Items[i]
+		if cj.Spec.Suspend != nil && *cj.Spec.Suspend && cj.Annotations[AnnotationSuspendedCronJob] != "" {
+			continue
+		}
+		patch := client.MergeFrom(cj.DeepCopy())
+		if cj.Annotations == nil {
+			cj.Annotations = make(map[string]string)
+		}
+		if _, ok := cj.Annotations[AnnotationSuspendedCronJob]; !ok {
+			cj.Annotations[AnnotationSuspendedCronJob] = strconv.FormatBool(cj.Spec.Suspend != nil && *cj.Spec.Suspend)
+		}
+		suspend := true
+		cj.Spec.Suspend = &suspend
+		if err := r.Patch(ctx, cj, patch); err != nil {
+			return 0, 0, err
+		}
+		suspended++
+	}
+	return scaled, suspended, nil
+}
+
+// resume restores the replicas and suspend flags recorded by suspend.
+func (r *SuspendReconciler) resume(ctx context.Context, namespace string) (int, int, error) {
+	scaled := 0
+	restore := func(o client.Object, replicas **int32) error {
+		previous, ok := o.GetAnnotations()[AnnotationSuspendedReplicas]
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(previous)
+		if err != nil {
+			return fmt.Errorf("invalid %s annotation on %s: %v", AnnotationSuspendedReplicas, o.GetName(), err)
+		}
+		patch := client.MergeFrom(o.DeepCopyObject().(client.Object))
+		annotations := o.GetAnnotations()
+		delete(annotations, AnnotationSuspendedReplicas)
+		o.SetAnnotations(annotations)
+		restored := int32(n)
+		*replicas = &restored
+		scaled++
+		return r.Patch(ctx, o, patch)
+	}
+
+	dl := &appsv1.DeploymentList{}
+	if err := r.List(ctx, dl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range dl.Items {
+		if err := restore(&dl.Items[i], &dl.Items[i].Spec.Replicas); err != nil {
+			return 0, 0, err
+		}
+	}
+	ssl := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, ssl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range ssl.Items {
+		if err := restore(&ssl.Items[i], &ssl.Items[i].Spec.Replicas); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	resumed := 0
+	cl := &batchv1beta1.CronJobList{}
+	if err := r.List(ctx, cl, client.InNamespace(namespace)); err != nil {
+		return 0, 0, err
+	}
+	for i := range cl.Items {
+		cj := &cl.Items[i]
+		previous, ok := cj.Annotations[AnnotationSuspendedCronJob]
+		if !ok {
+			continue
+		}
+		suspend, err := strconv.ParseBool(previous)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s annotation on %s: %v", AnnotationSuspendedCronJob, cj.Name, err)
+		}
+		patch := client.MergeFrom(cj.DeepCopy())
+		delete(cj.Annotations, AnnotationSuspendedCronJob)
+		cj.Spec.Suspend = &suspend
+		if err := r.Patch(ctx, cj, patch); err != nil {
+			return 0, 0, err
+		}
+		resumed++
+	}
+	return scaled, resumed, nil
+}
+
+// pauseAutoscalers disables scaling up for the HorizontalPodAutoscalers of
+// the namespace, recording their previous scale up rules in an annotation.
+// Autoscalers cannot scale to zero, so scaling up is disabled instead.
+func (r *SuspendReconciler) pauseAutoscalers(ctx context.Context, namespace string) (int, error) {
+	paused := 0
+	hl := &autoscalingv2beta2.HorizontalPodAutoscalerList{}
+	if err := r.List(ctx, hl, client.InNamespace(namespace)); err != nil {
+		return 0, err
+	}
+	disabled := autoscalingv2beta2.DisabledPolicySelect
+	for i := range hl.Items {
+		hpa := &hl.Items[i]
+		behavior := hpa.Spec.Behavior
+		if _, ok := hpa.Annotations[AnnotationSuspendedScaleUp]; ok && behavior != nil && behavior.ScaleUp != nil &&
+			behavior.ScaleUp.SelectPolicy != nil && *behavior.ScaleUp.SelectPolicy == disabled {
+			continue
+		}
+		patch := client.MergeFrom(hpa.DeepCopy())
+		if behavior == nil {
+			behavior = &autoscalingv2beta2.HorizontalPodAutoscalerBehavior{}
+			hpa.Spec.Behavior = behavior
+		}
+		if hpa.Annotations == nil {
+			hpa.Annotations = make(map[string]string)
+		}
+		if _, ok := hpa.Annotations[AnnotationSuspendedScaleUp]; !ok {
+			previous, err := json.Marshal(behavior.ScaleUp)
+			if err != nil {
+				return 0, err
+			}
+			hpa.Annotations[AnnotationSuspendedScaleUp] = string(previous)
+		}
+		if behavior.ScaleUp == nil {
+			behavior.ScaleUp = &autoscalingv2beta2.HPAScalingRules{}
+		}
+		behavior.ScaleUp.SelectPolicy = &disabled
+		if err := r.Patch(ctx, hpa, patch); err != nil {
+			return 0, err
+		}
+		paused++
+	}
+	return paused, nil
+}
+
+// resumeAutoscalers restores the scale up rules recorded by pauseAutoscalers.
+func (r *SuspendReconciler) resumeAutoscalers(ctx context.Context, namespace string) (int, error) {
+	resumed := 0
+	hl := &autoscalingv2beta2.HorizontalPodAutoscalerList{}
+	if err := r.List(ctx, hl, client.InNamespace(namespace)); err != nil {
+		return 0, err
+	}
+	for i := range hl.Items {
+		hpa := &hl.Items[i]
+		previous, ok := hpa.Annotations[AnnotationSuspendedScaleUp]
+		if !ok {
+			continue
+		}
+		var scaleUp *autoscalingv2beta2.HPAScalingRules
+		if err := json.Unmarshal([]byte(previous), &scaleUp); err != nil {
+			return 0, fmt.Errorf("invalid %s annotation on %s: %v", AnnotationSuspendedScaleUp, hpa.Name, err)
+		}
+		patch := client.MergeFrom(hpa.DeepCopy())
+		delete(hpa.Annotations, AnnotationSuspendedScaleUp)
+		if hpa.Spec.Behavior == nil {
+			hpa.Spec.Behavior = &autoscalingv2beta2.HorizontalPodAutoscalerBehavior{}
+		}
+		hpa.Spec.Behavior.ScaleUp = scaleUp
+		if err := r.Patch(ctx, hpa, patch); err != nil {
+			return 0, err
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// requestsForWorkload enqueues the LNamespace of a workload while it is
+// suspended, so that workloads created or scaled up in the meantime are
+// scaled back down.
+func (r *SuspendReconciler) requestsForWorkload(o client.Object) []reconcile.Request {
+	ns := &gialv1beta1.LNamespace{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: o.GetNamespace()}, ns); err != nil || !(ns.Spec.Suspended || ns.PendingDeletion()) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: ns.Name}}}
+}
+
+// SetupWithManager sets up the SuspendReconciler with the provided manager
+func (r *SuspendReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&gialv1beta1.LNamespace{}).
+		Watches(&source.Kind{
+			Type: &appsv1.Deployment{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForWorkload)).
+		Watches(&source.Kind{
+			Type: &appsv1.StatefulSet{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForWorkload)).
+		Watches(&source.Kind{
+			Type: &batchv1beta1.CronJob{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForWorkload)).
+		Watches(&source.Kind{
+			Type: &autoscalingv2beta2.HorizontalPodAutoscaler{},
+		}, handler.EnqueueRequestsFromMapFunc(r.requestsForWorkload)).
+		Complete(r)
+}
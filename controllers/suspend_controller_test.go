@@ -0,0 +1,217 @@
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/controllers"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Suspend Controller", func() {
+	var ctx context.Context
+	var ns *gialv1beta1.LNamespace
+	var sr *controllers.SuspendReconciler
+	var k8sClient client.Client
+
+	var reconcile = func() {
+		_, err := sr.Reconcile(ctx, controllerruntime.Request{
+			NamespacedName: types.NamespacedName{Name: ns.Name},
+		})
+		Expect(err).ToNot(HaveOccurred(), "Reconciling LNamespace should not have errored.")
+	}
+
+	var replicasOf = func(o client.Object) *int32 {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(o), o)).ToNot(HaveOccurred())
+		switch w := o.(type) {
+		case *appsv1.Deployment:
+			return w.Spec.Replicas
+		case *appsv1.StatefulSet:
+			return w.Spec.Replicas
+		}
+		return nil
+	}
+
+	var cronJob = func(name string, suspend bool) *batchv1beta1.CronJob {
+		return &batchv1beta1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: DefaultName},
+			Spec: batchv1beta1.CronJobSpec{
+				Schedule: "0 * * * *",
+				Suspend:  &suspend,
+			},
+		}
+	}
+
+	var suspendOf = func(name string) bool {
+		cj := &batchv1beta1.CronJob{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: DefaultName}, cj)).ToNot(HaveOccurred())
+		return *cj.Spec.Suspend
+	}
+
+	var setSuspended = func(suspended bool) {
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+		ns.Spec.Suspended = suspended
+		Expect(k8sClient.Update(ctx, ns)).ToNot(HaveOccurred())
+		reconcile()
+	}
+
+	// replicas returns a new pointer, since decoding into an object writes
+	// through the pointers it holds
+	var replicas = func(n int32) *int32 {
+		return &n
+	}
+	var deployment *appsv1.Deployment
+	var statefulSet *appsv1.StatefulSet
+	var autoscaler *autoscalingv2beta2.HorizontalPodAutoscaler
+
+	var scaleUpOf = func() *autoscalingv2beta2.HPAScalingRules {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(autoscaler), autoscaler)).ToNot(HaveOccurred())
+		if autoscaler.Spec.Behavior == nil {
+			return nil
+		}
+		return autoscaler.Spec.Behavior.ScaleUp
+	}
+
+	BeforeEach(func(done Done) {
+		ctx = context.Background()
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		sr = &controllers.SuspendReconciler{
+			Client:   k8sClient,
+			Log:      logf.Log,
+			Recorder: record.NewFakeRecorder(64),
+		}
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultName},
+			Spec:       gialv1beta1.LNamespaceSpec{Suspended: true},
+		}
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: DefaultName},
+			Spec:       appsv1.DeploymentSpec{Replicas: replicas(3)},
+		}
+		statefulSet = &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: DefaultName},
+			Spec:       appsv1.StatefulSetSpec{Replicas: replicas(2)},
+		}
+		autoscaler = &autoscalingv2beta2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: DefaultName},
+			Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+				MinReplicas:    replicas(2),
+				MaxReplicas:    10,
+			},
+		}
+		Expect(k8sClient.Create(ctx, deployment)).ToNot(HaveOccurred())
+		Expect(k8sClient.Create(ctx, autoscaler)).ToNot(HaveOccurred())
+		Expect(k8sClient.Create(ctx, statefulSet)).ToNot(HaveOccurred())
+		Expect(k8sClient.Create(ctx, cronJob("report", false))).ToNot(HaveOccurred())
+		Expect(k8sClient.Create(ctx, cronJob("paused", true))).ToNot(HaveOccurred())
+		close(done)
+	}, TestTimeout)
+
+	JustBeforeEach(func(done Done) {
+		Expect(k8sClient.Create(ctx, ns)).ToNot(HaveOccurred(), "Creating LNamespace should not have errored.")
+		reconcile()
+		close(done)
+	}, TestTimeout)
+
+	It("scales workloads to zero and records their replicas", func(done Done) {
+		Expect(*replicasOf(deployment)).To(BeZero())
+		Expect(deployment.Annotations[controllers.AnnotationSuspendedReplicas]).To(Equal("3"))
+		Expect(*replicasOf(statefulSet)).To(BeZero())
+		Expect(statefulSet.Annotations[controllers.AnnotationSuspendedReplicas]).To(Equal("2"))
+		close(done)
+	}, TestTimeout)
+
+	It("disables scaling up for autoscalers", func(done Done) {
+		scaleUp := scaleUpOf()
+		Expect(scaleUp).ToNot(BeNil())
+		Expect(*scaleUp.SelectPolicy).To(Equal(autoscalingv2beta2.DisabledPolicySelect))
+		Expect(autoscaler.Annotations).To(HaveKey(controllers.AnnotationSuspendedScaleUp))
+		close(done)
+	}, TestTimeout)
+
+	It("suspends cron jobs", func(done Done) {
+		Expect(suspendOf("report")).To(BeTrue())
+		Expect(suspendOf("paused")).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	It("reports the namespace as suspended", func(done Done) {
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ns.Name}, ns)).ToNot(HaveOccurred())
+		Expect(meta.IsStatusConditionTrue(ns.Status.Conditions, gialv1beta1.ConditionSuspended)).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	It("scales workloads that are scaled up while suspended back down", func(done Done) {
+		replicasOf(deployment)
+		deployment.Spec.Replicas = replicas(2)
+		Expect(k8sClient.Update(ctx, deployment)).ToNot(HaveOccurred())
+		reconcile()
+		Expect(*replicasOf(deployment)).To(BeZero())
+		Expect(deployment.Annotations[controllers.AnnotationSuspendedReplicas]).To(Equal("3"))
+		close(done)
+	}, TestTimeout)
+
+	When("the namespace is pending deletion", func() {
+		BeforeEach(func(done Done) {
+			deleted := metav1.Now()
+			ns.Spec = gialv1beta1.LNamespaceSpec{DeletionPolicy: gialv1beta1.DeletionPolicySoftDelete}
+			ns.DeletionTimestamp = &deleted
+			ns.Finalizers = []string{gialv1beta1.FinalizerDeletionPolicy}
+			close(done)
+		}, TestTimeout)
+
+		It("scales workloads to zero", func(done Done) {
+			Expect(*replicasOf(deployment)).To(BeZero())
+			Expect(suspendOf("report")).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace is resumed", func() {
+		JustBeforeEach(func(done Done) {
+			setSuspended(false)
+			close(done)
+		}, TestTimeout)
+
+		It("restores the replicas of the workloads", func(done Done) {
+			Expect(*replicasOf(deployment)).To(BeEquivalentTo(3))
+			Expect(deployment.Annotations).ToNot(HaveKey(controllers.AnnotationSuspendedReplicas))
+			Expect(*replicasOf(statefulSet)).To(BeEquivalentTo(2))
+			close(done)
+		}, TestTimeout)
+
+		It("restores the scale up rules of autoscalers", func(done Done) {
+			Expect(scaleUpOf()).To(BeNil())
+			Expect(autoscaler.Annotations).ToNot(HaveKey(controllers.AnnotationSuspendedScaleUp))
+			close(done)
+		}, TestTimeout)
+
+		It("restores the suspend flag of cron jobs", func(done Done) {
+			Expect(suspendOf("report")).To(BeFalse())
+			Expect(suspendOf("paused")).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		It("removes the suspended condition", func(done Done) {
+			ns = &gialv1beta1.LNamespace{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: DefaultName}, ns)).ToNot(HaveOccurred())
+			Expect(meta.FindStatusCondition(ns.Status.Conditions, gialv1beta1.ConditionSuspended)).To(BeNil())
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -18,7 +18,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -30,6 +33,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -37,6 +41,9 @@ import (
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
 	"github.com/loblaw-sre/namespace-controller/controllers"
 	"github.com/loblaw-sre/namespace-controller/pkg/bq"
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
 	"github.com/loblaw-sre/namespace-controller/webhooks"
 	// +kubebuilder:scaffold:imports
 )
@@ -84,22 +91,240 @@ func main() {
 		os.Exit(1)
 	}
 
+	defaultPodSecurityLevel := gialv1beta1.PodSecurityLevel(os.Getenv("NC_DEFAULT_POD_SECURITY_LEVEL"))
+	if defaultPodSecurityLevel == "" {
+		defaultPodSecurityLevel = gialv1beta1.PodSecurityRestricted
+	} else if !defaultPodSecurityLevel.Valid() {
+		setupLog.Error(fmt.Errorf("unknown pod security level %q", defaultPodSecurityLevel), "unable to parse NC_DEFAULT_POD_SECURITY_LEVEL")
+		os.Exit(1)
+	}
+	platformAdminGroups := utils.SplitList(os.Getenv("NC_PLATFORM_ADMIN_GROUPS"))
+	// the manager creates the LNamespaces of approved LNamespaceRequests as
+	// its service account, which is set through the downward API
+	var controllerUsername string
+	if v := os.Getenv("NC_SERVICE_ACCOUNT"); v != "" {
+		controllerUsername = "system:serviceaccount:" + os.Getenv("NC_POD_NAMESPACE") + ":" + v
+	}
+	protectedLabels := utils.KeyMatcher{
+		Keys:     utils.SplitList(os.Getenv("NC_PROTECTED_LABEL_KEYS")),
+		Prefixes: utils.SplitList(os.Getenv("NC_PROTECTED_LABEL_PREFIXES")),
+	}
+	ttlPolicies, err := expiry.ParsePolicies(os.Getenv("NC_TTL_POLICIES"))
+	if err != nil {
+		setupLog.Error(err, "unable to parse NC_TTL_POLICIES")
+		os.Exit(1)
+	}
+	expiryWarnings := controllers.DefaultExpiryWarnings
+	if v := os.Getenv("NC_EXPIRY_WARNINGS"); v != "" {
+		expiryWarnings, err = expiry.ParseDurations(v)
+		if err != nil {
+			setupLog.Error(err, "unable to parse NC_EXPIRY_WARNINGS")
+			os.Exit(1)
+		}
+	}
+	var expiryNotifier expiry.Notifier
+	if v := os.Getenv("NC_EXPIRY_NOTIFICATION_URL"); v != "" {
+		expiryNotifier = &expiry.WebhookNotifier{URL: v}
+	}
+	softDeleteRetention := controllers.DefaultSoftDeleteRetention
+	if v := os.Getenv("NC_SOFT_DELETE_RETENTION"); v != "" {
+		softDeleteRetention, err = time.ParseDuration(v)
+		if err != nil {
+			setupLog.Error(err, "unable to parse NC_SOFT_DELETE_RETENTION")
+			os.Exit(1)
+		}
+	}
+	ownershipLimits := webhooks.OwnershipLimits{CostCenterKey: os.Getenv("NC_COST_CENTER_KEY")}
+	for env, limit := range map[string]*int{
+		"NC_MAX_NAMESPACES_PER_USER":        &ownershipLimits.User,
+		"NC_MAX_NAMESPACES_PER_GROUP":       &ownershipLimits.Group,
+		"NC_MAX_NAMESPACES_PER_COST_CENTER": &ownershipLimits.CostCenter,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*limit, err = strconv.Atoi(v)
+			if err != nil {
+				setupLog.Error(err, "unable to parse "+env)
+				os.Exit(1)
+			}
+		}
+	}
+	namingPolicy := naming.Policy{Pattern: os.Getenv("NC_NAME_PATTERN")}
+	namingPolicy.Prefixes, err = naming.ParsePrefixes(os.Getenv("NC_RESERVED_NAME_PREFIXES"))
+	if err != nil {
+		setupLog.Error(err, "unable to parse NC_RESERVED_NAME_PREFIXES")
+		os.Exit(1)
+	}
+	maxSudoSession := webhooks.DefaultMaxSudoSession
+	if v := os.Getenv("NC_MAX_SUDO_SESSION"); v != "" {
+		maxSudoSession, err = time.ParseDuration(v)
+		if err != nil {
+			setupLog.Error(err, "unable to parse NC_MAX_SUDO_SESSION")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.NamespaceReconciler{
-		Client:   mgr.GetClient(),
-		Log:      ctrl.Log.WithName("controllers").WithName("Namespace"),
-		Recorder: mgr.GetEventRecorderFor("Namespace"),
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Namespace"),
+		Recorder:        mgr.GetEventRecorderFor("Namespace"),
+		ProtectedLabels: protectedLabels,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Namespace")
 		os.Exit(1)
 	}
 	if err = (&controllers.RBACReconciler{
-		Client:   mgr.GetClient(),
-		Log:      ctrl.Log.WithName("controllers").WithName("RBAC"),
-		Recorder: mgr.GetEventRecorderFor("RBAC"),
+		Client:            mgr.GetClient(),
+		Log:               ctrl.Log.WithName("controllers").WithName("RBAC"),
+		Recorder:          mgr.GetEventRecorderFor("RBAC"),
+		ProdDeveloperRole: os.Getenv("NC_PROD_DEVELOPER_ROLE"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RBAC")
 		os.Exit(1)
 	}
+	if err = (&controllers.SuspendReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Suspend"),
+		Recorder: mgr.GetEventRecorderFor("Suspend"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Suspend")
+		os.Exit(1)
+	}
+	if err = (&controllers.ExpiryReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Expiry"),
+		Recorder: mgr.GetEventRecorderFor("Expiry"),
+		Warnings: expiryWarnings,
+		Notifier: expiryNotifier,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Expiry")
+		os.Exit(1)
+	}
+	if err = (&controllers.DeletionReconciler{
+		Client:              mgr.GetClient(),
+		Log:                 ctrl.Log.WithName("controllers").WithName("Deletion"),
+		Recorder:            mgr.GetEventRecorderFor("Deletion"),
+		SoftDeleteRetention: softDeleteRetention,
+		RestoreNamespace:    os.Getenv("NC_RESTORE_NAMESPACE"),
+		RestoreRecords:      mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Deletion")
+		os.Exit(1)
+	}
+	if err = (&controllers.LNamespaceRequestReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("LNamespaceRequest"),
+		Recorder: mgr.GetEventRecorderFor("LNamespaceRequest"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LNamespaceRequest")
+		os.Exit(1)
+	}
+	if err = (&controllers.IstioRevisionRolloutReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("IstioRevisionRollout"),
+		Recorder: mgr.GetEventRecorderFor("IstioRevisionRollout"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IstioRevisionRollout")
+		os.Exit(1)
+	}
+	if err = (&controllers.NetworkReconciler{
+		Client:             mgr.GetClient(),
+		Log:                ctrl.Log.WithName("controllers").WithName("Network"),
+		Recorder:           mgr.GetEventRecorderFor("Network"),
+		PlatformNamespaces: utils.SplitList(os.Getenv("NC_NETWORK_PLATFORM_NAMESPACES")),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Network")
+		os.Exit(1)
+	}
+	if os.Getenv("NC_MESH_SECURITY_ENABLED") == "true" {
+		istioNamespace := os.Getenv("NC_ISTIO_NAMESPACE")
+		if istioNamespace == "" {
+			istioNamespace = "istio-system"
+		}
+		if err = (&controllers.MeshSecurityReconciler{
+			Client:             mgr.GetClient(),
+			Log:                ctrl.Log.WithName("controllers").WithName("MeshSecurity"),
+			Recorder:           mgr.GetEventRecorderFor("MeshSecurity"),
+			IstioNamespace:     istioNamespace,
+			PlatformNamespaces: utils.SplitList(os.Getenv("NC_NETWORK_PLATFORM_NAMESPACES")),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "MeshSecurity")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("Mesh security not enabled. MeshSecurity Controller not activated.")
+	}
+	if os.Getenv("NC_SIZE_TEMPLATE_NAMESPACE") != "" {
+		if err = (&controllers.QuotaReconciler{
+			Client:            mgr.GetClient(),
+			Log:               ctrl.Log.WithName("controllers").WithName("Quota"),
+			Recorder:          mgr.GetEventRecorderFor("Quota"),
+			TemplateNamespace: os.Getenv("NC_SIZE_TEMPLATE_NAMESPACE"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Quota")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("Size template namespace not provided. Quota Controller not activated.")
+	}
+	var clusterSecrets cache.Cache
+	if os.Getenv("NC_CLUSTER_SECRET_NAMESPACE") != "" {
+		clusterSecrets = namespacedCache(mgr, os.Getenv("NC_CLUSTER_SECRET_NAMESPACE"))
+		resyncInterval := controllers.DefaultClusterResyncInterval
+		if v := os.Getenv("NC_CLUSTER_RESYNC_INTERVAL"); v != "" {
+			resyncInterval, err = time.ParseDuration(v)
+			if err != nil {
+				setupLog.Error(err, "unable to parse NC_CLUSTER_RESYNC_INTERVAL")
+				os.Exit(1)
+			}
+		}
+		if err = (&controllers.ClusterReconciler{
+			Client:            mgr.GetClient(),
+			Log:               ctrl.Log.WithName("controllers").WithName("Cluster"),
+			Recorder:          mgr.GetEventRecorderFor("Cluster"),
+			SecretNamespace:   os.Getenv("NC_CLUSTER_SECRET_NAMESPACE"),
+			SecretCache:       clusterSecrets,
+			ProtectedLabels:   protectedLabels,
+			ProdDeveloperRole: os.Getenv("NC_PROD_DEVELOPER_ROLE"),
+			ResyncInterval:    resyncInterval,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Cluster")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("Cluster secret namespace not provided. Cluster Controller not activated.")
+	}
+	if os.Getenv("NC_REVISION_NAMESPACE") != "" {
+		historyLimit := controllers.DefaultRevisionHistoryLimit
+		if v := os.Getenv("NC_REVISION_HISTORY_LIMIT"); v != "" {
+			historyLimit, err = strconv.Atoi(v)
+			if err != nil {
+				setupLog.Error(err, "unable to parse NC_REVISION_HISTORY_LIMIT")
+				os.Exit(1)
+			}
+		}
+		deletedRetention := controllers.DefaultRevisionDeletedRetention
+		if v := os.Getenv("NC_REVISION_DELETED_RETENTION"); v != "" {
+			deletedRetention, err = time.ParseDuration(v)
+			if err != nil {
+				setupLog.Error(err, "unable to parse NC_REVISION_DELETED_RETENTION")
+				os.Exit(1)
+			}
+		}
+		if err = (&controllers.RevisionReconciler{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("controllers").WithName("Revision"),
+			Recorder:         mgr.GetEventRecorderFor("Revision"),
+			Namespace:        os.Getenv("NC_REVISION_NAMESPACE"),
+			HistoryLimit:     historyLimit,
+			DeletedRetention: deletedRetention,
+			RevisionCache:    namespacedCache(mgr, os.Getenv("NC_REVISION_NAMESPACE")),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Revision")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("Revision namespace not provided. Revision Controller not activated.")
+	}
 	if !(os.Getenv("NC_BIGQUERY_DATASET_NAME") == "" || os.Getenv("NC_BIGQUERY_TABLE_NAME") == "" || os.Getenv("NC_PROJECT_ID") == "") {
 		if err = (&controllers.BillingReconciler{
 			Client:      mgr.GetClient(),
@@ -117,12 +342,71 @@ func main() {
 		setupLog.Info("BigQuery configuration not provided. Billing Controller not activated.")
 	}
 	setupLog.Info("Default istio revision: " + os.Getenv("NC_DEFAULT_ISTIO_REVISION"))
+	setupLog.Info("Default pod security level: " + string(defaultPodSecurityLevel))
 	mgr.GetWebhookServer().Register(
 		"/mutate-gial-lblw-dev-v1beta1-lnamespace",
 		&webhook.Admission{
 			Handler: &webhooks.LNamespaceDefaulter{
-				Client:               mgr.GetClient(),
-				DefaultIstioRevision: os.Getenv("NC_DEFAULT_ISTIO_REVISION"),
+				Client:                  mgr.GetClient(),
+				DefaultIstioRevision:    os.Getenv("NC_DEFAULT_ISTIO_REVISION"),
+				DefaultPodSecurityLevel: defaultPodSecurityLevel,
+				DefaultSize:             gialv1beta1.NamespaceSize(os.Getenv("NC_DEFAULT_SIZE")),
+				RestoreRecords:          mgr.GetAPIReader(),
+				RestoreNamespace:        os.Getenv("NC_RESTORE_NAMESPACE"),
+			},
+		},
+	)
+	mgr.GetWebhookServer().Register(
+		"/validate-gial-lblw-dev-v1beta1-lnamespace",
+		&webhook.Admission{
+			Handler: &webhooks.LNamespaceValidator{
+				Client:                  mgr.GetClient(),
+				Recorder:                mgr.GetEventRecorderFor("LNamespaceValidator"),
+				ProtectedLabels:         protectedLabels,
+				DefaultPodSecurityLevel: defaultPodSecurityLevel,
+				DefaultSize:             gialv1beta1.NamespaceSize(os.Getenv("NC_DEFAULT_SIZE")),
+				PlatformAdminGroups:     platformAdminGroups,
+				MaxSudoSession:          maxSudoSession,
+				TTLPolicies:             ttlPolicies,
+				OwnershipLimits:         ownershipLimits,
+				NamingPolicy:            namingPolicy,
+				ClusterSecrets:          clusterSecrets,
+				ClusterSecretNamespace:  os.Getenv("NC_CLUSTER_SECRET_NAMESPACE"),
+				RestoreRecords:          mgr.GetAPIReader(),
+				RestoreNamespace:        os.Getenv("NC_RESTORE_NAMESPACE"),
+				ControllerUsername:      controllerUsername,
+			},
+		},
+	)
+	mgr.GetWebhookServer().Register(
+		"/mutate-gial-lblw-dev-v1beta1-lnamespacerequest",
+		&webhook.Admission{
+			Handler: &webhooks.LNamespaceRequestDefaulter{},
+		},
+	)
+	mgr.GetWebhookServer().Register(
+		"/validate-gial-lblw-dev-v1beta1-lnamespacerequest",
+		&webhook.Admission{
+			Handler: &webhooks.LNamespaceRequestValidator{
+				Client:              mgr.GetClient(),
+				ApproverGroups:      utils.SplitList(os.Getenv("NC_REQUEST_APPROVER_GROUPS")),
+				PlatformAdminGroups: platformAdminGroups,
+				NamingPolicy:        namingPolicy,
+			},
+		},
+	)
+	mgr.GetWebhookServer().Register(
+		"/validate-gial-lblw-dev-v1beta1-istiorevisionrollout",
+		&webhook.Admission{
+			Handler: &webhooks.IstioRevisionRolloutValidator{},
+		},
+	)
+	mgr.GetWebhookServer().Register(
+		"/validate-networking-istio-io-hosts",
+		&webhook.Admission{
+			Handler: &webhooks.HostValidator{
+				Client:           mgr.GetClient(),
+				InternalSuffixes: utils.SplitList(os.Getenv("NC_INTERNAL_HOST_SUFFIXES")),
 			},
 		},
 	)
@@ -143,3 +427,33 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// nonLeaderCache is started on every replica, since the webhooks read from
+// some of the namespaced caches.
+type nonLeaderCache struct {
+	cache.Cache
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (nonLeaderCache) NeedLeaderElection() bool {
+	return false
+}
+
+// namespacedCache returns a cache of the objects of namespace, for the objects
+// that the manager is only allowed to read in that namespace.
+func namespacedCache(mgr ctrl.Manager, namespace string) cache.Cache {
+	c, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: namespace,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create cache", "namespace", namespace)
+		os.Exit(1)
+	}
+	if err := mgr.Add(nonLeaderCache{c}); err != nil {
+		setupLog.Error(err, "unable to add cache", "namespace", namespace)
+		os.Exit(1)
+	}
+	return c
+}
@@ -0,0 +1,69 @@
+package expiry
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+)
+
+// Policy caps the lifetime of namespaces whose name matches Pattern.
+type Policy struct {
+	// Pattern is a shell pattern, e.g. preview-*.
+	Pattern string
+	// MaxTTL is the longest a matching namespace may live before it expires.
+	MaxTTL time.Duration
+}
+
+// ParsePolicies parses a comma separated list of pattern=duration pairs, e.g.
+// preview-*=168h,pr-*=72h.
+func ParsePolicies(s string) ([]Policy, error) {
+	policies := []Policy{}
+	for _, v := range utils.SplitList(s) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("policy %q must be of the form pattern=duration", v)
+		}
+		if _, err := path.Match(kv[0], ""); err != nil {
+			return nil, fmt.Errorf("policy %q has an invalid pattern: %v", v, err)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("policy %q has an invalid duration: %v", v, err)
+		}
+		policies = append(policies, Policy{Pattern: kv[0], MaxTTL: d})
+	}
+	return policies, nil
+}
+
+// MaxTTL returns the strictest cap that policies put on the namespace name,
+// and false if no policy matches it.
+func MaxTTL(policies []Policy, name string) (time.Duration, bool) {
+	var max time.Duration
+	found := false
+	for _, v := range policies {
+		if ok, _ := path.Match(v.Pattern, name); !ok {
+			continue
+		}
+		if !found || v.MaxTTL < max {
+			max = v.MaxTTL
+		}
+		found = true
+	}
+	return max, found
+}
+
+// ParseDurations parses a comma separated list of durations, e.g. 72h,24h,1h.
+func ParseDurations(s string) ([]time.Duration, error) {
+	durations := []time.Duration{}
+	for _, v := range utils.SplitList(s) {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
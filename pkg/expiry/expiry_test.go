@@ -0,0 +1,44 @@
+package expiry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := expiry.ParsePolicies("preview-*=168h, pr-*=72h")
+	if err != nil {
+		t.Fatalf("ParsePolicies returned an error: %v", err)
+	}
+	if len(policies) != 2 || policies[1].Pattern != "pr-*" || policies[1].MaxTTL != 72*time.Hour {
+		t.Errorf("ParsePolicies = %v, want preview-* for 168h and pr-* for 72h", policies)
+	}
+	for _, v := range []string{"preview-*", "preview-*=forever", "[=1h"} {
+		if _, err := expiry.ParsePolicies(v); err == nil {
+			t.Errorf("ParsePolicies(%q) should have returned an error", v)
+		}
+	}
+}
+
+func TestMaxTTL(t *testing.T) {
+	policies := []expiry.Policy{
+		{Pattern: "preview-*", MaxTTL: 168 * time.Hour},
+		{Pattern: "preview-pr-*", MaxTTL: 72 * time.Hour},
+	}
+	for _, tc := range []struct {
+		name  string
+		want  time.Duration
+		found bool
+	}{
+		{"preview-shop", 168 * time.Hour, true},
+		{"preview-pr-12", 72 * time.Hour, true},
+		{"shop", 0, false},
+	} {
+		max, found := expiry.MaxTTL(policies, tc.name)
+		if max != tc.want || found != tc.found {
+			t.Errorf("MaxTTL(%q) = %v, %v, want %v, %v", tc.name, max, found, tc.want, tc.found)
+		}
+	}
+}
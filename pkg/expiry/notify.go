@@ -0,0 +1,60 @@
+package expiry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Notification announces the upcoming expiry of a namespace.
+type Notification struct {
+	Namespace string           `json:"namespace"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+	Message   string           `json:"message"`
+	Managers  []rbacv1.Subject `json:"managers,omitempty"`
+	Sudoers   []rbacv1.Subject `json:"sudoers,omitempty"`
+}
+
+// Notifier delivers expiry notifications to the people owning a namespace.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier posts notifications as JSON to URL, e.g. a chat or
+// alerting webhook that forwards them to the managers and sudoers.
+type WebhookNotifier struct {
+	URL string
+	// Client is used to post notifications. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+// Notify implements Notifier.
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
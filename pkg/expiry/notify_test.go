@@ -0,0 +1,41 @@
+package expiry_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var got expiry.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	n := expiry.Notification{Namespace: "preview-shop", ExpiresAt: time.Unix(0, 0).UTC(), Message: "expires soon"}
+	if err := (&expiry.WebhookNotifier{URL: server.URL}).Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+	if got.Namespace != n.Namespace || !got.ExpiresAt.Equal(n.ExpiresAt) || got.Message != n.Message {
+		t.Errorf("webhook received %v, want %v", got, n)
+	}
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := (&expiry.WebhookNotifier{URL: server.URL}).Notify(context.Background(), expiry.Notification{}); err == nil {
+		t.Error("Notify should have returned an error")
+	}
+}
@@ -0,0 +1,200 @@
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+// CycleError is returned when the parents of an LNamespace lead back to it.
+type CycleError struct {
+	Chain []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("parents form a cycle: %s", strings.Join(e.Chain, " -> "))
+}
+
+// Ancestors returns the parent of ns, its parent and so on, nearest first.
+// The chain ends at the first parent that does not exist.
+func Ancestors(ctx context.Context, c client.Reader, ns *gialv1beta1.LNamespace) ([]gialv1beta1.LNamespace, error) {
+	var ancestors []gialv1beta1.LNamespace
+	chain := []string{ns.Name}
+	seen := map[string]bool{ns.Name: true}
+	parent := ns.Spec.Parent
+	for parent != "" {
+		chain = append(chain, parent)
+		if seen[parent] {
+			return nil, &CycleError{Chain: chain}
+		}
+		seen[parent] = true
+		p := gialv1beta1.LNamespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: parent}, &p); apierrors.IsNotFound(err) {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "unable to get parent %s", parent)
+		}
+		ancestors = append(ancestors, p)
+		parent = p.Spec.Parent
+	}
+	return ancestors, nil
+}
+
+// Resolve returns a copy of ns whose spec includes everything it inherits
+// from its teams and ancestors. What a namespace inherits from its own teams
+// takes precedence over what it inherits from its parent.
+func Resolve(ctx context.Context, c client.Reader, ns *gialv1beta1.LNamespace) (*gialv1beta1.LNamespace, error) {
+	ancestors, err := Ancestors(ctx, c, ns)
+	if err != nil {
+		return nil, err
+	}
+	res := ns.DeepCopy()
+	if err := inheritTeams(ctx, c, &res.Spec); err != nil {
+		return nil, err
+	}
+	for _, v := range ancestors {
+		if err := inheritTeams(ctx, c, &v.Spec); err != nil {
+			return nil, err
+		}
+		Inherit(&res.Spec, &v.Spec)
+	}
+	return res, nil
+}
+
+// inheritTeams adds the members and billing of the teams of spec to it.
+// Teams that do not exist are skipped.
+func inheritTeams(ctx context.Context, c client.Reader, spec *gialv1beta1.LNamespaceSpec) error {
+	for _, name := range spec.Teams {
+		team := &gialv1beta1.LTeam{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, team); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to get team %s", name)
+		}
+		Inherit(spec, &gialv1beta1.LNamespaceSpec{
+			Managers:   team.Spec.Managers,
+			Sudoers:    team.Spec.Sudoers,
+			Developers: team.Spec.Developers,
+			Billing:    team.Spec.Billing,
+		})
+	}
+	return nil
+}
+
+// Inherit adds the managers, sudoers, developers, billing and label overrides
+// of parent to spec. Subjects are added to those of spec, while keys set on
+// spec override those of parent.
+func Inherit(spec, parent *gialv1beta1.LNamespaceSpec) {
+	spec.Managers = mergeSubjects(parent.Managers, spec.Managers)
+	spec.Sudoers = mergeSubjects(parent.Sudoers, spec.Sudoers)
+	spec.Developers = mergeSubjects(parent.Developers, spec.Developers)
+	spec.Billing = mergeMaps(parent.Billing, spec.Billing)
+	spec.NamespaceLabelOverrides = mergeMaps(parent.NamespaceLabelOverrides, spec.NamespaceLabelOverrides)
+}
+
+// Descendants returns the names of the LNamespaces below name, children first.
+func Descendants(ctx context.Context, c client.Reader, name string) ([]string, error) {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := c.List(ctx, l); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaces")
+	}
+	return descendants(l.Items, name), nil
+}
+
+func descendants(items []gialv1beta1.LNamespace, name string) []string {
+	children := make(map[string][]string)
+	for _, v := range items {
+		if v.Spec.Parent != "" {
+			children[v.Spec.Parent] = append(children[v.Spec.Parent], v.Name)
+		}
+	}
+	var res []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		for _, v := range children[queue[0]] {
+			if !seen[v] {
+				seen[v] = true
+				res = append(res, v)
+				queue = append(queue, v)
+			}
+		}
+		queue = queue[1:]
+	}
+	return res
+}
+
+// Members returns the names of the LNamespaces that reference team, and of
+// the LNamespaces below them.
+func Members(ctx context.Context, c client.Reader, team string) ([]string, error) {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := c.List(ctx, l); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaces")
+	}
+	var res []string
+	seen := make(map[string]bool)
+	for _, v := range l.Items {
+		if !contains(v.Spec.Teams, team) || seen[v.Name] {
+			continue
+		}
+		seen[v.Name] = true
+		res = append(res, v.Name)
+		for _, d := range descendants(l.Items, v.Name) {
+			if !seen[d] {
+				seen[d] = true
+				res = append(res, d)
+			}
+		}
+	}
+	return res, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeSubjects(parent, child []rbacv1.Subject) []rbacv1.Subject {
+	if len(parent) == 0 {
+		return child
+	}
+	res := append([]rbacv1.Subject{}, parent...)
+	for _, v := range child {
+		found := false
+		for _, p := range parent {
+			if p == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func mergeMaps(parent, child map[string]string) map[string]string {
+	if len(parent) == 0 {
+		return child
+	}
+	res := make(map[string]string)
+	for k, v := range parent {
+		res[k] = v
+	}
+	for k, v := range child {
+		res[k] = v
+	}
+	return res
+}
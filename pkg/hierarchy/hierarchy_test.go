@@ -0,0 +1,138 @@
+package hierarchy_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
+)
+
+func lns(name, parent string, spec gialv1beta1.LNamespaceSpec) *gialv1beta1.LNamespace {
+	spec.Parent = parent
+	return &gialv1beta1.LNamespace{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func TestInherit(t *testing.T) {
+	spec := &gialv1beta1.LNamespaceSpec{
+		Managers: []rbacv1.Subject{{Kind: "User", Name: "bob"}, {Kind: "User", Name: "john"}},
+		Billing:  map[string]string{"budget": "2.0"},
+	}
+	hierarchy.Inherit(spec, &gialv1beta1.LNamespaceSpec{
+		Managers:                []rbacv1.Subject{{Kind: "User", Name: "john"}},
+		Billing:                 map[string]string{"budget": "1.0", "team": "shop"},
+		NamespaceLabelOverrides: map[string]string{"team": "shop"},
+	})
+	wantManagers := []rbacv1.Subject{{Kind: "User", Name: "john"}, {Kind: "User", Name: "bob"}}
+	if !reflect.DeepEqual(spec.Managers, wantManagers) {
+		t.Errorf("Managers = %v, want %v", spec.Managers, wantManagers)
+	}
+	wantBilling := map[string]string{"budget": "2.0", "team": "shop"}
+	if !reflect.DeepEqual(spec.Billing, wantBilling) {
+		t.Errorf("Billing = %v, want %v", spec.Billing, wantBilling)
+	}
+	if spec.NamespaceLabelOverrides["team"] != "shop" {
+		t.Errorf("NamespaceLabelOverrides = %v, want team=shop", spec.NamespaceLabelOverrides)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		lns("org", "", gialv1beta1.LNamespaceSpec{Billing: map[string]string{"budget": "1.0", "org": "lblw"}}),
+		lns("team", "org", gialv1beta1.LNamespaceSpec{Billing: map[string]string{"budget": "2.0"}}),
+	).Build()
+	resolved, err := hierarchy.Resolve(context.Background(), c, lns("app", "team", gialv1beta1.LNamespaceSpec{}))
+	if err != nil {
+		t.Fatalf("Resolve() errored: %v", err)
+	}
+	want := map[string]string{"budget": "2.0", "org": "lblw"}
+	if !reflect.DeepEqual(resolved.Spec.Billing, want) {
+		t.Errorf("Billing = %v, want %v", resolved.Spec.Billing, want)
+	}
+}
+
+func TestResolveTeams(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&gialv1beta1.LTeam{
+			ObjectMeta: metav1.ObjectMeta{Name: "pharmacy"},
+			Spec: gialv1beta1.LTeamSpec{
+				Sudoers: []rbacv1.Subject{{Kind: "Group", Name: "pharmacy-sre"}},
+				Billing: map[string]string{"budget": "3.0", "cost-center": "4021"},
+			},
+		},
+		lns("org", "", gialv1beta1.LNamespaceSpec{Billing: map[string]string{"budget": "1.0", "org": "lblw"}}),
+	).Build()
+	resolved, err := hierarchy.Resolve(context.Background(), c, lns("app", "org", gialv1beta1.LNamespaceSpec{
+		Teams:   []string{"pharmacy", "missing"},
+		Billing: map[string]string{"cost-center": "1000"},
+	}))
+	if err != nil {
+		t.Fatalf("Resolve() errored: %v", err)
+	}
+	wantBilling := map[string]string{"budget": "3.0", "cost-center": "1000", "org": "lblw"}
+	if !reflect.DeepEqual(resolved.Spec.Billing, wantBilling) {
+		t.Errorf("Billing = %v, want %v", resolved.Spec.Billing, wantBilling)
+	}
+	if len(resolved.Spec.Sudoers) != 1 || resolved.Spec.Sudoers[0].Name != "pharmacy-sre" {
+		t.Errorf("Sudoers = %v, want pharmacy-sre", resolved.Spec.Sudoers)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		lns("team", "", gialv1beta1.LNamespaceSpec{Teams: []string{"pharmacy"}}),
+		lns("app", "team", gialv1beta1.LNamespaceSpec{}),
+		lns("other", "", gialv1beta1.LNamespaceSpec{Teams: []string{"shop"}}),
+	).Build()
+	names, err := hierarchy.Members(context.Background(), c, "pharmacy")
+	if err != nil {
+		t.Fatalf("Members() errored: %v", err)
+	}
+	if want := []string{"team", "app"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Members() = %v, want %v", names, want)
+	}
+}
+
+func TestAncestorsCycle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		lns("team", "app", gialv1beta1.LNamespaceSpec{}),
+	).Build()
+	_, err := hierarchy.Ancestors(context.Background(), c, lns("app", "team", gialv1beta1.LNamespaceSpec{}))
+	var cycle *hierarchy.CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Ancestors() = %v, want a cycle error", err)
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		lns("org", "", gialv1beta1.LNamespaceSpec{}),
+		lns("team", "org", gialv1beta1.LNamespaceSpec{}),
+		lns("app", "team", gialv1beta1.LNamespaceSpec{}),
+		lns("other", "", gialv1beta1.LNamespaceSpec{}),
+	).Build()
+	names, err := hierarchy.Descendants(context.Background(), c, "org")
+	if err != nil {
+		t.Fatalf("Descendants() errored: %v", err)
+	}
+	if want := []string{"team", "app"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Descendants() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,91 @@
+package hosts
+
+import (
+	"sort"
+	"strings"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+// Normalize lowercases a host and strips the namespace prefix used by
+// Gateway servers, e.g. "prod/shop.loblaw.ca" becomes "shop.loblaw.ca".
+func Normalize(host string) string {
+	if i := strings.LastIndex(host, "/"); i >= 0 {
+		host = host[i+1:]
+	}
+	return strings.ToLower(strings.TrimSpace(host))
+}
+
+// Covers returns true if claim grants host. A claim is either an exact
+// hostname or a wildcard domain such as "*.loblaw.ca", which grants every
+// host below the domain, including narrower wildcards.
+func Covers(claim, host string) bool {
+	claim, host = Normalize(claim), Normalize(host)
+	if claim == host {
+		return true
+	}
+	if strings.HasPrefix(claim, "*.") {
+		return strings.HasSuffix(host, claim[1:])
+	}
+	return false
+}
+
+// Overlaps returns true if the claims a and b share at least one host.
+func Overlaps(a, b string) bool {
+	return Covers(a, b) || Covers(b, a)
+}
+
+// Claimed returns true if any of the claims grants host.
+func Claimed(claims []string, host string) bool {
+	for _, v := range claims {
+		if Covers(v, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// precedes returns true if a claimed its hosts before b.
+func precedes(a, b *gialv1beta1.LNamespace) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// Conflicts returns the claims of ns that overlap a claim of an older
+// LNamespace, with the sorted names of those LNamespaces. The oldest claim
+// wins, so that a new claim can never take a host away from its owner.
+func Conflicts(ns *gialv1beta1.LNamespace, all []gialv1beta1.LNamespace) map[string][]string {
+	conflicts := make(map[string][]string)
+	for i := range all {
+		other := &all[i]
+		if other.Name == ns.Name || !precedes(other, ns) {
+			continue
+		}
+		for _, claim := range ns.Spec.Hosts {
+			for _, v := range other.Spec.Hosts {
+				if Overlaps(claim, v) {
+					conflicts[claim] = append(conflicts[claim], other.Name)
+					break
+				}
+			}
+		}
+	}
+	for _, v := range conflicts {
+		sort.Strings(v)
+	}
+	return conflicts
+}
+
+// Owned returns the claims of ns that do not conflict with an older LNamespace.
+func Owned(ns *gialv1beta1.LNamespace, all []gialv1beta1.LNamespace) []string {
+	conflicts := Conflicts(ns, all)
+	var owned []string
+	for _, v := range ns.Spec.Hosts {
+		if _, ok := conflicts[v]; !ok {
+			owned = append(owned, v)
+		}
+	}
+	return owned
+}
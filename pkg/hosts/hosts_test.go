@@ -0,0 +1,42 @@
+package hosts_test
+
+import (
+	"testing"
+
+	"github.com/loblaw-sre/namespace-controller/pkg/hosts"
+)
+
+func TestNormalize(t *testing.T) {
+	actual := hosts.Normalize("prod/Shop.Loblaw.ca")
+	if actual != "shop.loblaw.ca" {
+		t.Errorf("Normalize(\"prod/Shop.Loblaw.ca\") = %s, want shop.loblaw.ca", actual)
+	}
+}
+
+func TestCovers(t *testing.T) {
+	for _, tc := range []struct {
+		claim, host string
+		want        bool
+	}{
+		{"shop.loblaw.ca", "shop.loblaw.ca", true},
+		{"shop.loblaw.ca", "api.shop.loblaw.ca", false},
+		{"*.shop.loblaw.ca", "api.shop.loblaw.ca", true},
+		{"*.shop.loblaw.ca", "*.api.shop.loblaw.ca", true},
+		{"*.shop.loblaw.ca", "shop.loblaw.ca", false},
+		{"*.shop.loblaw.ca", "myshop.loblaw.ca", false},
+		{"api.shop.loblaw.ca", "*.shop.loblaw.ca", false},
+	} {
+		if actual := hosts.Covers(tc.claim, tc.host); actual != tc.want {
+			t.Errorf("Covers(%q, %q) = %v, want %v", tc.claim, tc.host, actual, tc.want)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	if !hosts.Overlaps("api.shop.loblaw.ca", "*.shop.loblaw.ca") {
+		t.Errorf("Overlaps(\"api.shop.loblaw.ca\", \"*.shop.loblaw.ca\") = false, want true")
+	}
+	if hosts.Overlaps("shop.loblaw.ca", "*.pharmacy.loblaw.ca") {
+		t.Errorf("Overlaps(\"shop.loblaw.ca\", \"*.pharmacy.loblaw.ca\") = true, want false")
+	}
+}
@@ -0,0 +1,286 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+)
+
+const (
+	// SudoerRole is the ClusterRole whose subjects are imported as sudoers.
+	SudoerRole = "cluster-admin"
+	// DeveloperRole is the ClusterRole whose subjects are imported as developers.
+	DeveloperRole = "admin"
+
+	// labelRBACType is set by the controllers on the RBAC they manage, which
+	// is not imported.
+	labelRBACType = "gial.lblw.dev/rbac-type"
+)
+
+// DefaultExclude holds the namespaces that are never imported.
+var DefaultExclude = []string{"default", "kube-*", "istio-system", "namespace-controller-system"}
+
+// Options configures an import.
+type Options struct {
+	// Billing maps billing keys to the namespace annotations they are read from.
+	Billing map[string]string
+	// Exclude holds shell patterns of namespaces that are not imported.
+	Exclude []string
+	// DefaultPodSecurityLevel is the cluster default. Looser levels found on
+	// a namespace are approved on its LNamespace, so that it is admitted.
+	DefaultPodSecurityLevel gialv1beta1.PodSecurityLevel
+}
+
+// Conflict is something about a namespace that could not be imported as is.
+type Conflict struct {
+	Namespace string
+	Reason    string
+	// Skipped is true if no LNamespace was generated for the namespace.
+	Skipped bool
+}
+
+// Result holds the generated LNamespaces and the conflicts found on the way,
+// both sorted by namespace.
+type Result struct {
+	LNamespaces []gialv1beta1.LNamespace
+	Conflicts   []Conflict
+}
+
+// ParseBilling parses a comma separated list of billing keys, each read from
+// the namespace annotation of the same name, or of key=annotation pairs.
+func ParseBilling(s string) (map[string]string, error) {
+	billing := make(map[string]string)
+	for _, v := range utils.SplitList(s) {
+		kv := strings.SplitN(v, "=", 2)
+		if kv[0] == "" || len(kv) == 2 && kv[1] == "" {
+			return nil, fmt.Errorf("billing %q must be of the form key or key=annotation", v)
+		}
+		billing[kv[0]] = kv[len(kv)-1]
+	}
+	return billing, nil
+}
+
+// Import generates an LNamespace for each existing namespace that no
+// LNamespace manages yet. The LNamespaces describe the namespaces as they are,
+// so that adopting them changes as little as possible:
+//   - subjects bound to cluster-admin become sudoers and those bound to admin
+//     become developers. The existing RoleBindings are left in place, which
+//     is reported for prod namespaces, whose sudoers only get cluster-admin
+//     through sudo sessions.
+//   - billing is read from the namespace annotations given in opts.Billing.
+//   - the environment, pod security levels and mesh mode are read from the
+//     namespace labels.
+//   - network isolation is disabled and the size is none, so that no
+//     NetworkPolicy, ResourceQuota or LimitRange is added.
+//   - the deletion policy is Retain, so that deleting an imported LNamespace
+//     does not delete the workloads of the namespace.
+func Import(ctx context.Context, c client.Reader, opts Options) (*Result, error) {
+	namespaces := &corev1.NamespaceList{}
+	if err := c.List(ctx, namespaces); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaces")
+	}
+	lnamespaces := &gialv1beta1.LNamespaceList{}
+	if err := c.List(ctx, lnamespaces); err != nil {
+		return nil, errors.Wrap(err, "unable to list LNamespaces")
+	}
+	managed := make(map[string]bool, len(lnamespaces.Items))
+	for _, v := range lnamespaces.Items {
+		managed[v.Name] = true
+	}
+	bindings := &rbacv1.RoleBindingList{}
+	if err := c.List(ctx, bindings); err != nil {
+		return nil, errors.Wrap(err, "unable to list role bindings")
+	}
+	bindingsByNamespace := make(map[string][]rbacv1.RoleBinding)
+	for _, v := range bindings.Items {
+		bindingsByNamespace[v.Namespace] = append(bindingsByNamespace[v.Namespace], v)
+	}
+
+	res := &Result{}
+	for _, ns := range namespaces.Items {
+		if excluded(ns.Name, opts.Exclude) {
+			continue
+		}
+		skip := func(reason string) {
+			res.Conflicts = append(res.Conflicts, Conflict{Namespace: ns.Name, Reason: reason, Skipped: true})
+		}
+		if managed[ns.Name] {
+			skip("already managed by an LNamespace")
+			continue
+		}
+		if !ns.DeletionTimestamp.IsZero() {
+			skip("namespace is being deleted")
+			continue
+		}
+		if owner := metav1.GetControllerOf(&ns); owner != nil {
+			skip(fmt.Sprintf("namespace is controlled by %s %s", owner.Kind, owner.Name))
+			continue
+		}
+		lns, conflicts := generate(&ns, bindingsByNamespace[ns.Name], opts)
+		res.LNamespaces = append(res.LNamespaces, *lns)
+		res.Conflicts = append(res.Conflicts, conflicts...)
+	}
+	sort.Slice(res.LNamespaces, func(i, j int) bool { return res.LNamespaces[i].Name < res.LNamespaces[j].Name })
+	sort.SliceStable(res.Conflicts, func(i, j int) bool { return res.Conflicts[i].Namespace < res.Conflicts[j].Namespace })
+	return res, nil
+}
+
+// generate returns the LNamespace of ns and what could not be imported.
+func generate(ns *corev1.Namespace, bindings []rbacv1.RoleBinding, opts Options) (*gialv1beta1.LNamespace, []Conflict) {
+	var conflicts []Conflict
+	conflict := func(format string, args ...interface{}) {
+		conflicts = append(conflicts, Conflict{Namespace: ns.Name, Reason: fmt.Sprintf(format, args...)})
+	}
+	lns := &gialv1beta1.LNamespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gialv1beta1.GroupVersion.String(),
+			Kind:       "LNamespace",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ns.Name,
+		},
+		Spec: gialv1beta1.LNamespaceSpec{
+			Size:           gialv1beta1.SizeNone,
+			Network:        &gialv1beta1.Network{Isolation: gialv1beta1.IsolationNone},
+			DeletionPolicy: gialv1beta1.DeletionPolicyRetain,
+		},
+	}
+
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Name < bindings[j].Name })
+	var sudoerBindings []string
+	for _, rb := range bindings {
+		if _, ok := rb.Labels[labelRBACType]; ok || rb.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+		var subjects *[]rbacv1.Subject
+		switch rb.RoleRef.Name {
+		case SudoerRole:
+			subjects = &lns.Spec.Sudoers
+			sudoerBindings = append(sudoerBindings, rb.Name)
+		case DeveloperRole:
+			subjects = &lns.Spec.Developers
+		default:
+			continue
+		}
+		for _, v := range rb.Subjects {
+			if v.Kind != rbacv1.UserKind && v.Kind != rbacv1.GroupKind {
+				conflict("RoleBinding %s binds %s %s to %s, which is kept but not imported", rb.Name, v.Kind, v.Name, rb.RoleRef.Name)
+				continue
+			}
+			if !contains(*subjects, v) {
+				*subjects = append(*subjects, v)
+			}
+		}
+	}
+	// sudoers can do everything developers can
+	developers := lns.Spec.Developers[:0]
+	for _, v := range lns.Spec.Developers {
+		if !contains(lns.Spec.Sudoers, v) {
+			developers = append(developers, v)
+		}
+	}
+	lns.Spec.Developers = developers
+	if len(lns.Spec.Developers) == 0 {
+		lns.Spec.Developers = nil
+	}
+	if len(lns.Spec.Sudoers) == 0 {
+		conflict("no RoleBinding to %s, sudoers default to whoever creates the LNamespace", SudoerRole)
+	}
+
+	keys := make([]string, 0, len(opts.Billing))
+	for k := range opts.Billing {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := ns.Annotations[opts.Billing[k]]
+		if !ok {
+			conflict("annotation %s of billing key %s is not set", opts.Billing[k], k)
+			continue
+		}
+		if lns.Spec.Billing == nil {
+			lns.Spec.Billing = make(map[string]string)
+		}
+		lns.Spec.Billing[k] = v
+	}
+
+	if v, ok := ns.Labels[gialv1beta1.LabelEnvironment]; ok {
+		switch env := gialv1beta1.Environment(v); env {
+		case gialv1beta1.EnvironmentDev, gialv1beta1.EnvironmentStaging, gialv1beta1.EnvironmentProd:
+			lns.Spec.Environment = env
+		default:
+			conflict("environment %s is unknown, defaulting to %s", v, gialv1beta1.EnvironmentDev)
+		}
+	}
+	// prod sudoers only get cluster-admin through sudo sessions, which the
+	// standing bindings would bypass
+	if lns.Spec.Env() == gialv1beta1.EnvironmentProd {
+		for _, v := range sudoerBindings {
+			conflict("RoleBinding %s grants %s outside of sudo sessions, which prod requires, and should be deleted once the LNamespace is applied", v, SudoerRole)
+		}
+	}
+
+	// namespaces without pod security labels admit any pod
+	lns.Spec.PodSecurity = &gialv1beta1.PodSecurity{}
+	loosest := gialv1beta1.PodSecurityLevel("")
+	levels := []*gialv1beta1.PodSecurityLevel{&lns.Spec.PodSecurity.Enforce, &lns.Spec.PodSecurity.Audit, &lns.Spec.PodSecurity.Warn}
+	for i, mode := range []string{"enforce", "audit", "warn"} {
+		level := levels[i]
+		*level = gialv1beta1.PodSecurityPrivileged
+		if v, ok := ns.Labels[gialv1beta1.PodSecurityLabelPrefix+mode]; ok {
+			if l := gialv1beta1.PodSecurityLevel(v); l.Valid() {
+				*level = l
+			} else {
+				conflict("pod security %s level %s is unknown, importing it as %s", mode, v, gialv1beta1.PodSecurityPrivileged)
+			}
+		}
+		if loosest == "" || level.LooserThan(loosest) {
+			loosest = *level
+		}
+	}
+	if loosest.LooserThan(opts.DefaultPodSecurityLevel) {
+		lns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: string(loosest)}
+	}
+
+	switch {
+	case ns.Labels[istio.RevisionLabel] != "":
+		lns.Spec.IstioRevision = ns.Labels[istio.RevisionLabel]
+	case ns.Labels["istio.io/dataplane-mode"] == "ambient":
+		lns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshAmbient}
+	case ns.Labels["istio-injection"] == "enabled":
+		conflict("istio-injection=enabled is replaced by the %s label of the default istio revision", istio.RevisionLabel)
+	default:
+		lns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshNone}
+	}
+	return lns, conflicts
+}
+
+func excluded(name string, patterns []string) bool {
+	for _, v := range patterns {
+		if ok, _ := path.Match(v, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []rbacv1.Subject, s rbacv1.Subject) bool {
+	for _, v := range list {
+		if v.Kind == s.Kind && v.Name == s.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package importer_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/importer"
+)
+
+func namespace(name string, labels, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+func binding(namespace, name, role string, subjects ...rbacv1.Subject) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role},
+		Subjects:   subjects,
+	}
+}
+
+func TestParseBilling(t *testing.T) {
+	billing, err := importer.ParseBilling("budget,cost-center=shipyard.io/cost-center")
+	if err != nil {
+		t.Fatalf("ParseBilling() errored: %v", err)
+	}
+	want := map[string]string{"budget": "budget", "cost-center": "shipyard.io/cost-center"}
+	if !reflect.DeepEqual(billing, want) {
+		t.Errorf("ParseBilling() = %v, want %v", billing, want)
+	}
+	if _, err := importer.ParseBilling("budget="); err == nil {
+		t.Errorf("ParseBilling(budget=) should have errored")
+	}
+}
+
+func TestImport(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = gialv1beta1.AddToScheme(scheme)
+	john := rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "john@loblaw.ca"}
+	sre := rbacv1.Subject{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "pharmacy-sre"}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		namespace("kube-system", nil, nil),
+		namespace("managed", nil, nil),
+		&gialv1beta1.LNamespace{ObjectMeta: metav1.ObjectMeta{Name: "managed"}},
+		namespace("pharmacy", map[string]string{
+			gialv1beta1.LabelEnvironment:                   "prod",
+			gialv1beta1.PodSecurityLabelPrefix + "enforce": "baseline",
+			"istio.io/rev": "canary",
+		}, map[string]string{"shipyard.io/cost-center": "4021"}),
+		binding("pharmacy", "owners", "cluster-admin", sre),
+		binding("pharmacy", "devs", "admin", sre, john,
+			rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "deployer", Namespace: "pharmacy"}),
+		binding("pharmacy", "viewers", "view", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "everyone"}),
+		namespace("scratch", nil, nil),
+	).Build()
+
+	res, err := importer.Import(context.Background(), c, importer.Options{
+		Billing:                 map[string]string{"cost-center": "shipyard.io/cost-center"},
+		Exclude:                 importer.DefaultExclude,
+		DefaultPodSecurityLevel: gialv1beta1.PodSecurityRestricted,
+	})
+	if err != nil {
+		t.Fatalf("Import() errored: %v", err)
+	}
+	if len(res.LNamespaces) != 2 {
+		t.Fatalf("Import() generated %d LNamespaces, want pharmacy and scratch", len(res.LNamespaces))
+	}
+
+	pharmacy := res.LNamespaces[0]
+	if pharmacy.Name != "pharmacy" {
+		t.Fatalf("LNamespaces[0] = %s, want pharmacy", pharmacy.Name)
+	}
+	if want := []rbacv1.Subject{sre}; !reflect.DeepEqual(pharmacy.Spec.Sudoers, want) {
+		t.Errorf("Sudoers = %v, want %v", pharmacy.Spec.Sudoers, want)
+	}
+	if want := []rbacv1.Subject{john}; !reflect.DeepEqual(pharmacy.Spec.Developers, want) {
+		t.Errorf("Developers = %v, want %v", pharmacy.Spec.Developers, want)
+	}
+	if pharmacy.Spec.Billing["cost-center"] != "4021" {
+		t.Errorf("Billing = %v, want cost-center=4021", pharmacy.Spec.Billing)
+	}
+	if pharmacy.Spec.Environment != gialv1beta1.EnvironmentProd {
+		t.Errorf("Environment = %s, want prod", pharmacy.Spec.Environment)
+	}
+	if pharmacy.Spec.PodSecurity.Enforce != gialv1beta1.PodSecurityBaseline || pharmacy.Spec.PodSecurity.Warn != gialv1beta1.PodSecurityPrivileged {
+		t.Errorf("PodSecurity = %+v, want enforce baseline and warn privileged", pharmacy.Spec.PodSecurity)
+	}
+	if v := pharmacy.Annotations[gialv1beta1.AnnotationPodSecurityApproval]; v != string(gialv1beta1.PodSecurityPrivileged) {
+		t.Errorf("pod security approval = %q, want privileged", v)
+	}
+	if pharmacy.Spec.IstioRevision != "canary" {
+		t.Errorf("IstioRevision = %s, want canary", pharmacy.Spec.IstioRevision)
+	}
+	if pharmacy.Spec.DeletionPolicy != gialv1beta1.DeletionPolicyRetain {
+		t.Errorf("DeletionPolicy = %s, want Retain", pharmacy.Spec.DeletionPolicy)
+	}
+	if pharmacy.Spec.Size != gialv1beta1.SizeNone || pharmacy.Spec.CustomSize != nil {
+		t.Errorf("Size = %s with custom size %v, want none", pharmacy.Spec.Size, pharmacy.Spec.CustomSize)
+	}
+
+	if scratch := res.LNamespaces[1]; scratch.Spec.MeshMode() != gialv1beta1.MeshNone {
+		t.Errorf("mesh mode of scratch = %s, want none", scratch.Spec.MeshMode())
+	}
+
+	want := []importer.Conflict{
+		{Namespace: "managed", Reason: "already managed by an LNamespace", Skipped: true},
+		{Namespace: "pharmacy", Reason: "RoleBinding devs binds ServiceAccount deployer to admin, which is kept but not imported"},
+		{Namespace: "pharmacy", Reason: "RoleBinding owners grants cluster-admin outside of sudo sessions, which prod requires, and should be deleted once the LNamespace is applied"},
+		{Namespace: "scratch", Reason: "no RoleBinding to cluster-admin, sudoers default to whoever creates the LNamespace"},
+		{Namespace: "scratch", Reason: "annotation shipyard.io/cost-center of billing key cost-center is not set"},
+	}
+	if !reflect.DeepEqual(res.Conflicts, want) {
+		t.Errorf("Conflicts = %v, want %v", res.Conflicts, want)
+	}
+}
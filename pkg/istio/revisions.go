@@ -0,0 +1,76 @@
+package istio
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RevisionLabel is the label that istio relies on for directing which istiod to register with.
+const RevisionLabel = "istio.io/rev"
+
+// istiodSelector selects the istiod Deployments of every revision. Workloads
+// injected with a sidecar carry the revision label as well, so it alone is
+// not enough.
+const istiodSelector = "app=istiod," + RevisionLabel
+
+// IsControlPlane returns true if o is a sidecar injector
+// MutatingWebhookConfiguration or an istiod Deployment of a revision, as
+// counted by Revisions.
+func IsControlPlane(o client.Object) bool {
+	if _, ok := o.GetLabels()[RevisionLabel]; !ok {
+		return false
+	}
+	if _, ok := o.(*appsv1.Deployment); ok {
+		return o.GetLabels()["app"] == "istiod"
+	}
+	return true
+}
+
+// Revisions returns the sorted revisions of the istio control planes installed
+// in the cluster. Revisions are discovered through istiod Deployments and
+// sidecar injector MutatingWebhookConfigurations labelled with istio.io/rev.
+func Revisions(ctx context.Context, c client.Reader) ([]string, error) {
+	found := make(map[string]bool)
+	mwcl := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := c.List(ctx, mwcl, client.HasLabels{RevisionLabel}); err != nil {
+		return nil, errors.Wrap(err, "unable to list mutating webhook configurations")
+	}
+	for _, v := range mwcl.Items {
+		found[v.Labels[RevisionLabel]] = true
+	}
+	selector, err := labels.Parse(istiodSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate selector for istiod deployments")
+	}
+	dl := &appsv1.DeploymentList{}
+	if err := c.List(ctx, dl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, errors.Wrap(err, "unable to list istiod deployments")
+	}
+	for _, v := range dl.Items {
+		found[v.Labels[RevisionLabel]] = true
+	}
+	revisions := []string{}
+	for k := range found {
+		if k != "" {
+			revisions = append(revisions, k)
+		}
+	}
+	sort.Strings(revisions)
+	return revisions, nil
+}
+
+// Contains returns true if revision is one of revisions.
+func Contains(revisions []string, revision string) bool {
+	for _, v := range revisions {
+		if v == revision {
+			return true
+		}
+	}
+	return false
+}
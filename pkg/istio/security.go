@@ -0,0 +1,25 @@
+package istio
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// PeerAuthenticationGVK is the kind of istio PeerAuthentications
+	PeerAuthenticationGVK = schema.GroupVersionKind{Group: "security.istio.io", Version: "v1beta1", Kind: "PeerAuthentication"}
+	// AuthorizationPolicyGVK is the kind of istio AuthorizationPolicies
+	AuthorizationPolicyGVK = schema.GroupVersionKind{Group: "security.istio.io", Version: "v1beta1", Kind: "AuthorizationPolicy"}
+	// SidecarGVK is the kind of istio Sidecars
+	SidecarGVK = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "Sidecar"}
+)
+
+// New returns an empty istio object of the given kind, so that the
+// controller does not need the istio Go types to compile.
+func New(gvk schema.GroupVersionKind, name, namespace string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(name)
+	u.SetNamespace(namespace)
+	return u
+}
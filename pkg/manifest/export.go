@@ -0,0 +1,185 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+// Extension is the extension of the files written by Export.
+const Extension = ".yaml"
+
+// Difference is an LNamespace that differs between a directory and a cluster.
+type Difference struct {
+	Name   string
+	Reason string
+}
+
+// Export writes every LNamespace to dir as <name>.yaml, normalized by Marshal,
+// and removes the files of the LNamespaces that no longer exist. Files whose
+// content did not change are left untouched, and so are the files that are not
+// LNamespace manifests, e.g. a kustomization.yaml. It returns the number of
+// LNamespaces exported.
+func Export(ctx context.Context, c client.Reader, dir string) (int, error) {
+	live, err := liveManifests(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.Wrapf(err, "unable to create %s", dir)
+	}
+	for name, b := range live {
+		path := filepath.Join(dir, name+Extension)
+		if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, b) {
+			continue
+		}
+		// a file is replaced at once, so that it is never read half written
+		tmp := filepath.Join(dir, "."+name+Extension+".tmp")
+		if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+			return 0, errors.Wrapf(err, "unable to write %s", tmp)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return 0, errors.Wrapf(err, "unable to write %s", path)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+Extension))
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range files {
+		if _, ok := live[strings.TrimSuffix(filepath.Base(v), Extension)]; ok {
+			continue
+		}
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return 0, errors.Wrapf(err, "unable to read %s", v)
+		}
+		if !isLNamespace(b) {
+			continue
+		}
+		if err := os.Remove(v); err != nil {
+			return 0, errors.Wrapf(err, "unable to remove %s", v)
+		}
+	}
+	return len(live), nil
+}
+
+// Diff compares the LNamespaces exported to dir to those of the cluster,
+// returning the differences sorted by name. Files are compared once
+// normalized, so that formatting does not matter, and the files that are not
+// LNamespace manifests are ignored.
+func Diff(ctx context.Context, c client.Reader, dir string) ([]Difference, error) {
+	live, err := liveManifests(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+Extension))
+	if err != nil {
+		return nil, err
+	}
+	var res []Difference
+	exported := make(map[string]bool, len(files))
+	for _, v := range files {
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s", v)
+		}
+		if !isLNamespace(b) {
+			continue
+		}
+		ns := &gialv1beta1.LNamespace{}
+		if err := yaml.UnmarshalStrict(b, ns); err != nil {
+			res = append(res, Difference{Name: filepath.Base(v), Reason: fmt.Sprintf("unable to parse: %v", err)})
+			continue
+		}
+		exported[ns.Name] = true
+		b, err = Marshal(ns)
+		if err != nil {
+			return nil, err
+		}
+		current, ok := live[ns.Name]
+		if !ok {
+			res = append(res, Difference{Name: ns.Name, Reason: "not in the cluster"})
+		} else if fields := changedFields(b, current); len(fields) > 0 {
+			res = append(res, Difference{Name: ns.Name, Reason: strings.Join(fields, ", ") + " differ"})
+		}
+	}
+	for name := range live {
+		if !exported[name] {
+			res = append(res, Difference{Name: name, Reason: "not in the directory"})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// isLNamespace returns true if b is the manifest of an LNamespace, judging
+// by its apiVersion and kind only.
+func isLNamespace(b []byte) bool {
+	t := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(b, &t); err != nil {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(t.APIVersion)
+	return err == nil && gv.Group == gialv1beta1.GroupVersion.Group && t.Kind == "LNamespace"
+}
+
+// liveManifests returns the LNamespaces of the cluster marshalled by Marshal,
+// by name.
+func liveManifests(ctx context.Context, c client.Reader) (map[string][]byte, error) {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := c.List(ctx, l); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaces")
+	}
+	res := make(map[string][]byte, len(l.Items))
+	for i := range l.Items {
+		b, err := Marshal(&l.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		res[l.Items[i].Name] = b
+	}
+	return res, nil
+}
+
+// changedFields returns the fields of the metadata and spec that differ
+// between two manifests, e.g. spec.billing.
+func changedFields(a, b []byte) []string {
+	var am, bm map[string]interface{}
+	if yaml.Unmarshal(a, &am) != nil || yaml.Unmarshal(b, &bm) != nil {
+		return []string{"manifests"}
+	}
+	var res []string
+	for _, section := range []string{"metadata", "spec"} {
+		as, _ := am[section].(map[string]interface{})
+		bs, _ := bm[section].(map[string]interface{})
+		keys := make(map[string]bool)
+		for k := range as {
+			keys[k] = true
+		}
+		for k := range bs {
+			keys[k] = true
+		}
+		for k := range keys {
+			if !reflect.DeepEqual(as[k], bs[k]) {
+				res = append(res, section+"."+k)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,92 @@
+package manifest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/manifest"
+)
+
+func lns(name, budget string) *gialv1beta1.LNamespace {
+	return &gialv1beta1.LNamespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       gialv1beta1.LNamespaceSpec{Billing: map[string]string{"budget": budget}},
+	}
+}
+
+func TestExportAndDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lns-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "deleted.yaml"), []byte("apiVersion: gial.lblw.dev/v1beta1\nkind: LNamespace\nmetadata:\n  name: deleted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kustomization := []byte("apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n  - pharmacy.yaml\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "kustomization.yaml"), kustomization, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(lns("pharmacy", "1.0"), lns("shop", "2.0")).Build()
+	ctx := context.Background()
+
+	n, err := manifest.Export(ctx, c, dir)
+	if err != nil {
+		t.Fatalf("Export() errored: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Export() = %d, want 2", n)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	want := []string{filepath.Join(dir, "kustomization.yaml"), filepath.Join(dir, "pharmacy.yaml"), filepath.Join(dir, "shop.yaml")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+
+	differences, err := manifest.Diff(ctx, c, dir)
+	if err != nil {
+		t.Fatalf("Diff() errored: %v", err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("Diff() = %v, want no differences after an export", differences)
+	}
+
+	live := &gialv1beta1.LNamespace{}
+	_ = c.Get(ctx, client.ObjectKey{Name: "shop"}, live)
+	live.Spec.Billing["budget"] = "3.0"
+	_ = c.Update(ctx, live)
+	_ = c.Create(ctx, lns("new", "1.0"))
+	if err := os.Remove(filepath.Join(dir, "pharmacy.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "stale.yaml"), []byte("apiVersion: gial.lblw.dev/v1beta1\nkind: LNamespace\nmetadata:\n  name: stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	differences, err = manifest.Diff(ctx, c, dir)
+	if err != nil {
+		t.Fatalf("Diff() errored: %v", err)
+	}
+	wantDifferences := []manifest.Difference{
+		{Name: "new", Reason: "not in the directory"},
+		{Name: "pharmacy", Reason: "not in the directory"},
+		{Name: "shop", Reason: "spec.billing differ"},
+		{Name: "stale", Reason: "not in the cluster"},
+	}
+	if !reflect.DeepEqual(differences, wantDifferences) {
+		t.Errorf("Diff() = %v, want %v", differences, wantDifferences)
+	}
+}
@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/revision"
+)
+
+// lastAppliedAnnotation is set by kubectl apply on the objects it applies.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// controllerLabels and controllerAnnotations are set on LNamespaces by the
+// controllers rather than by their owners.
+var (
+	controllerLabels      = []string{gialv1beta1.LabelIstioRevisionRollout}
+	controllerAnnotations = []string{lastAppliedAnnotation, gialv1beta1.AnnotationRequest}
+)
+
+// Normalize returns a copy of ns without its status, the metadata that is set
+// by the API server or the controllers, and its sudo sessions, so that it can
+// be applied to any cluster and only changes when its owners change it.
+func Normalize(ns *gialv1beta1.LNamespace) *gialv1beta1.LNamespace {
+	res := &gialv1beta1.LNamespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gialv1beta1.GroupVersion.String(),
+			Kind:       "LNamespace",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ns.Name,
+			Labels:      ns.Labels,
+			Annotations: ns.Annotations,
+		},
+		Spec: *revision.Recorded(&ns.Spec),
+	}
+	res = res.DeepCopy()
+	for _, k := range controllerLabels {
+		delete(res.Labels, k)
+	}
+	if len(res.Labels) == 0 {
+		res.Labels = nil
+	}
+	for _, k := range controllerAnnotations {
+		delete(res.Annotations, k)
+	}
+	if len(res.Annotations) == 0 {
+		res.Annotations = nil
+	}
+	return res
+}
+
+// Marshal returns the YAML of ns as normalized by Normalize.
+func Marshal(ns *gialv1beta1.LNamespace) ([]byte, error) {
+	b, err := yaml.Marshal(Normalize(ns))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to marshal %s", ns.Name)
+	}
+	// creationTimestamp and status are not omitted when empty
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal %s", ns.Name)
+	}
+	delete(m, "status")
+	if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return yaml.Marshal(m)
+}
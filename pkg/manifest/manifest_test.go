@@ -0,0 +1,52 @@
+package manifest_test
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/manifest"
+)
+
+func TestMarshal(t *testing.T) {
+	b, err := manifest.Marshal(&gialv1beta1.LNamespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pharmacy",
+			ResourceVersion:   "42",
+			UID:               "uid",
+			CreationTimestamp: metav1.Now(),
+			Labels: map[string]string{
+				"team":                                "pharmacy",
+				gialv1beta1.LabelIstioRevisionRollout: "canary",
+			},
+			Annotations: map[string]string{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				gialv1beta1.AnnotationRequest:                      "requests/pharmacy",
+			},
+		},
+		Spec: gialv1beta1.LNamespaceSpec{
+			Billing:      map[string]string{"budget": "1.0"},
+			SudoSessions: []gialv1beta1.SudoSession{{Name: "john@loblaw.ca", Expires: metav1.Now()}},
+		},
+		Status: gialv1beta1.LNamespaceStatus{
+			Conditions: []metav1.Condition{{Type: "Ready"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal() errored: %v", err)
+	}
+	want := `apiVersion: gial.lblw.dev/v1beta1
+kind: LNamespace
+metadata:
+  labels:
+    team: pharmacy
+  name: pharmacy
+spec:
+  billing:
+    budget: "1.0"
+`
+	if string(b) != want {
+		t.Errorf("Marshal() = \n%s\nwant\n%s", b, want)
+	}
+}
@@ -0,0 +1,152 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+)
+
+// Policy constrains the names of LNamespaces.
+type Policy struct {
+	// Pattern is a shell pattern that names must match, e.g. {team}-*. Each
+	// {key} is replaced with the value of key in the billing of the namespace.
+	// Any name is allowed when empty.
+	Pattern string
+	// Prefixes reserves the names starting with each prefix for the members
+	// of its groups.
+	Prefixes map[string][]string
+}
+
+// Violation is an LNamespace whose name violates a Policy.
+type Violation struct {
+	Name   string
+	Reason string
+}
+
+var placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// ParsePrefixes parses a comma separated list of prefix=group pairs, e.g.
+// pharmacy-=pharmacy-sre,pharmacy-=pharmacy-leads. A prefix can be reserved
+// for several groups by repeating it.
+func ParsePrefixes(s string) (map[string][]string, error) {
+	prefixes := make(map[string][]string)
+	for _, v := range utils.SplitList(s) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("reserved prefix %q must be of the form prefix=group", v)
+		}
+		prefixes[kv[0]] = append(prefixes[kv[0]], kv[1])
+	}
+	return prefixes, nil
+}
+
+// CheckPattern returns an error if name does not match the pattern of the
+// policy, given the billing of the namespace.
+func (p Policy) CheckPattern(name string, billing map[string]string) error {
+	if p.Pattern == "" {
+		return nil
+	}
+	var missing []string
+	pattern := placeholder.ReplaceAllStringFunc(p.Pattern, func(s string) string {
+		key := s[1 : len(s)-1]
+		v, ok := billing[key]
+		if !ok {
+			missing = append(missing, key)
+		}
+		return escape(v)
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("billing must set %s, which the naming pattern %s requires", strings.Join(missing, ", "), p.Pattern)
+	}
+	if ok, err := path.Match(pattern, name); err != nil {
+		return errors.Wrapf(err, "naming pattern %s is invalid", p.Pattern)
+	} else if !ok {
+		return fmt.Errorf("name %s does not match the naming pattern %s, expanded to %s from billing", name, p.Pattern, pattern)
+	}
+	return nil
+}
+
+// Reserved returns the longest reserved prefix of name and the groups it is
+// reserved for, or an empty prefix if name is not reserved.
+func (p Policy) Reserved(name string) (string, []string) {
+	prefix := ""
+	for k := range p.Prefixes {
+		if strings.HasPrefix(name, k) && len(k) > len(prefix) {
+			prefix = k
+		}
+	}
+	return prefix, p.Prefixes[prefix]
+}
+
+// Violations lists the existing LNamespaces whose names violate the policy,
+// sorted by name. Since the groups of whoever created a namespace are not
+// known, a name with a reserved prefix is a violation when none of the groups
+// it is reserved for is one of the sudoers or managers of the namespace.
+func Violations(ctx context.Context, c client.Reader, p Policy) ([]Violation, error) {
+	l := &gialv1beta1.LNamespaceList{}
+	if err := c.List(ctx, l); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaces")
+	}
+	var res []Violation
+	for i := range l.Items {
+		ns, err := hierarchy.Resolve(ctx, c, &l.Items[i])
+		if err != nil {
+			res = append(res, Violation{Name: l.Items[i].Name, Reason: err.Error()})
+			continue
+		}
+		if err := p.CheckPattern(ns.Name, ns.Spec.Billing); err != nil {
+			res = append(res, Violation{Name: ns.Name, Reason: err.Error()})
+			continue
+		}
+		prefix, groups := p.Reserved(ns.Name)
+		if prefix != "" && !owned(ns, groups) {
+			res = append(res, Violation{Name: ns.Name, Reason: fmt.Sprintf("names starting with %s are reserved for %s, which are not sudoers or managers", prefix, strings.Join(groups, ", "))})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// owned returns true if one of groups is a sudoer or manager of ns.
+func owned(ns *gialv1beta1.LNamespace, groups []string) bool {
+	for _, subjects := range [][]rbacv1.Subject{ns.Spec.Sudoers, ns.Spec.Managers} {
+		for _, v := range subjects {
+			if v.Kind == rbacv1.GroupKind && contains(groups, v.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// escape quotes the characters of s that path.Match treats as special.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package naming_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := naming.ParsePrefixes("pharmacy-=pharmacy-sre, pharmacy-=pharmacy-leads,shop-=shop")
+	if err != nil {
+		t.Fatalf("ParsePrefixes returned an error: %v", err)
+	}
+	want := map[string][]string{"pharmacy-": {"pharmacy-sre", "pharmacy-leads"}, "shop-": {"shop"}}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("ParsePrefixes = %v, want %v", prefixes, want)
+	}
+	for _, v := range []string{"pharmacy-", "=shop", "shop-="} {
+		if _, err := naming.ParsePrefixes(v); err == nil {
+			t.Errorf("ParsePrefixes(%q) should have returned an error", v)
+		}
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	policy := naming.Policy{Pattern: "{team}-*"}
+	for _, tc := range []struct {
+		name    string
+		billing map[string]string
+		valid   bool
+	}{
+		{"pharmacy-refill", map[string]string{"team": "pharmacy"}, true},
+		{"shop-refill", map[string]string{"team": "pharmacy"}, false},
+		{"pharmacy-refill", nil, false},
+		{"pharmacy-refill", map[string]string{"team": "*"}, false},
+	} {
+		if err := policy.CheckPattern(tc.name, tc.billing); (err == nil) != tc.valid {
+			t.Errorf("CheckPattern(%s, %v) = %v, want valid %t", tc.name, tc.billing, err, tc.valid)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	policy := naming.Policy{Prefixes: map[string][]string{"pharmacy-": {"pharmacy-sre"}, "pharmacy-rx-": {"rx"}}}
+	if prefix, groups := policy.Reserved("pharmacy-rx-refill"); prefix != "pharmacy-rx-" || !reflect.DeepEqual(groups, []string{"rx"}) {
+		t.Errorf("Reserved() = %s, %v, want the longest prefix pharmacy-rx-", prefix, groups)
+	}
+	if prefix, _ := policy.Reserved("shop"); prefix != "" {
+		t.Errorf("Reserved(shop) = %s, want no prefix", prefix)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = gialv1beta1.AddToScheme(scheme)
+	lns := func(name, team string, sudoers ...rbacv1.Subject) *gialv1beta1.LNamespace {
+		return &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       gialv1beta1.LNamespaceSpec{Sudoers: sudoers, Billing: map[string]string{"team": team}},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		lns("pharmacy-refill", "pharmacy", rbacv1.Subject{Kind: "Group", Name: "pharmacy-sre"}),
+		lns("pharmacy-rogue", "pharmacy", rbacv1.Subject{Kind: "User", Name: "john"}),
+		lns("checkout", "shop"),
+	).Build()
+	violations, err := naming.Violations(context.Background(), c, naming.Policy{
+		Pattern:  "{team}-*",
+		Prefixes: map[string][]string{"pharmacy-": {"pharmacy-sre"}},
+	})
+	if err != nil {
+		t.Fatalf("Violations() errored: %v", err)
+	}
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Name)
+	}
+	if want := []string{"checkout", "pharmacy-rogue"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Violations() = %v, want %v", violations, want)
+	}
+}
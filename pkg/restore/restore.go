@@ -0,0 +1,57 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+// dataKey is the key of the recorded LNamespace in the data of a record.
+const dataKey = "lnamespace"
+
+// Name returns the name of the ConfigMap recording the restore of the
+// LNamespace name.
+func Name(name string) string {
+	return "restore-" + name
+}
+
+// Record returns a ConfigMap in namespace recording ns, which is recreated
+// once the soft deleted LNamespace is gone. Records are kept in the namespace
+// of the manager, since tenants can annotate their own namespaces.
+func Record(namespace string, ns *gialv1beta1.LNamespace) (*corev1.ConfigMap, error) {
+	b, err := json.Marshal(ns)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Name(ns.Name),
+			Namespace: namespace,
+			Labels:    map[string]string{gialv1beta1.LabelLNamespace: ns.Name},
+		},
+		Data: map[string]string{dataKey: string(b)},
+	}, nil
+}
+
+// Get returns the LNamespace recorded for the restore of name in namespace,
+// or nil if there is none.
+func Get(ctx context.Context, c client.Reader, namespace, name string) (*gialv1beta1.LNamespace, error) {
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: Name(name)}, cm); apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "unable to get restore record of %s", name)
+	}
+	ns := &gialv1beta1.LNamespace{}
+	if err := json.Unmarshal([]byte(cm.Data[dataKey]), ns); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode restore record of %s", name)
+	}
+	return ns, nil
+}
@@ -0,0 +1,116 @@
+package revision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+)
+
+const (
+	// AnnotationAuthor holds the field manager that made the spec of a revision.
+	AnnotationAuthor = "gial.lblw.dev/author"
+	// AnnotationDeleted holds when the LNamespace of a revision was found
+	// deleted, in RFC 3339.
+	AnnotationDeleted = "gial.lblw.dev/lnamespace-deleted"
+)
+
+// Recorded returns the part of spec that is recorded in revisions. Sudo
+// sessions come and go, so they are left out.
+func Recorded(spec *gialv1beta1.LNamespaceSpec) *gialv1beta1.LNamespaceSpec {
+	res := spec.DeepCopy()
+	res.SudoSessions = nil
+	return res
+}
+
+// Hash returns a short hash of the recorded part of spec.
+func Hash(spec *gialv1beta1.LNamespaceSpec) (string, error) {
+	b, err := json.Marshal(Recorded(spec))
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New32a()
+	h.Write(b)
+	return rand.SafeEncodeString(fmt.Sprint(h.Sum32())), nil
+}
+
+// Author returns the field manager that last changed the spec of ns, as
+// recorded in its managedFields, or an empty string if it is unknown. Managed
+// fields name the client that made a change, such as kubectl-edit, not the user.
+func Author(ns *gialv1beta1.LNamespace) string {
+	var author string
+	var latest time.Time
+	for _, v := range ns.ManagedFields {
+		if v.FieldsV1 == nil || v.Time == nil {
+			continue
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(v.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields["f:spec"]; !ok {
+			continue
+		}
+		if author == "" || v.Time.After(latest) {
+			author = v.Manager
+			latest = v.Time.Time
+		}
+	}
+	return author
+}
+
+// List returns the revisions of the LNamespace name stored in namespace,
+// oldest first.
+func List(ctx context.Context, c client.Reader, namespace, name string) ([]appsv1.ControllerRevision, error) {
+	l := &appsv1.ControllerRevisionList{}
+	if err := c.List(ctx, l, client.InNamespace(namespace), client.MatchingLabels{gialv1beta1.LabelLNamespace: name}); err != nil {
+		return nil, errors.Wrapf(err, "unable to list revisions of %s", name)
+	}
+	sort.Slice(l.Items, func(i, j int) bool { return l.Items[i].Revision < l.Items[j].Revision })
+	return l.Items, nil
+}
+
+// Spec returns the spec recorded in rev.
+func Spec(rev *appsv1.ControllerRevision) (*gialv1beta1.LNamespaceSpec, error) {
+	spec := &gialv1beta1.LNamespaceSpec{}
+	if err := json.Unmarshal(rev.Data.Raw, spec); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode revision %d", rev.Revision)
+	}
+	return spec, nil
+}
+
+// Changes returns the fields of the spec that differ between a and b, e.g.
+// sudoers.
+func Changes(a, b *gialv1beta1.LNamespaceSpec) []string {
+	var am, bm map[string]interface{}
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	if json.Unmarshal(ab, &am) != nil || json.Unmarshal(bb, &bm) != nil {
+		return nil
+	}
+	keys := make(map[string]bool)
+	for k := range am {
+		keys[k] = true
+	}
+	for k := range bm {
+		keys[k] = true
+	}
+	var res []string
+	for k := range keys {
+		if !reflect.DeepEqual(am[k], bm[k]) {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
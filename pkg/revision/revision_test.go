@@ -0,0 +1,100 @@
+package revision_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/revision"
+)
+
+func TestHashIgnoresSudoSessions(t *testing.T) {
+	spec := &gialv1beta1.LNamespaceSpec{Sudoers: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "john"}}}
+	a, err := revision.Hash(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSession := spec.DeepCopy()
+	withSession.SudoSessions = []gialv1beta1.SudoSession{{Name: "john"}}
+	b, _ := revision.Hash(withSession)
+	if a != b {
+		t.Errorf("hash changed with a sudo session: %s != %s", a, b)
+	}
+	changed := spec.DeepCopy()
+	changed.Sudoers = nil
+	c, _ := revision.Hash(changed)
+	if a == c {
+		t.Errorf("hash did not change with the sudoers: %s", a)
+	}
+}
+
+func TestAuthor(t *testing.T) {
+	at := func(min int) *metav1.Time {
+		t := metav1.NewTime(time.Date(2021, 1, 1, 0, min, 0, 0, time.UTC))
+		return &t
+	}
+	ns := &gialv1beta1.LNamespace{ObjectMeta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+		{Manager: "kubectl-edit", Time: at(1), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:sudoers":{}}}`)}},
+		{Manager: "manager", Time: at(3), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:status":{}}`)}},
+		{Manager: "kubectl-apply", Time: at(2), FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:billing":{}}}`)}},
+	}}}
+	if got := revision.Author(ns); got != "kubectl-apply" {
+		t.Errorf("Author() = %q, want kubectl-apply", got)
+	}
+	if got := revision.Author(&gialv1beta1.LNamespace{}); got != "" {
+		t.Errorf("Author() = %q without managed fields, want none", got)
+	}
+}
+
+func TestListAndSpec(t *testing.T) {
+	rev := func(name string, n int64, data string) *appsv1.ControllerRevision {
+		return &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "system", Labels: map[string]string{gialv1beta1.LabelLNamespace: "shop"}},
+			Data:       runtime.RawExtension{Raw: []byte(data)},
+			Revision:   n,
+		}
+	}
+	scheme := runtime.NewScheme()
+	_ = appsv1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		rev("shop-b", 2, `{"sudoers":[{"kind":"User","name":"alice"}]}`),
+		rev("shop-a", 1, `{"sudoers":[{"kind":"User","name":"john"}]}`),
+		&appsv1.ControllerRevision{ObjectMeta: metav1.ObjectMeta{Name: "pharmacy-a", Namespace: "system", Labels: map[string]string{gialv1beta1.LabelLNamespace: "pharmacy"}}},
+	).Build()
+
+	revisions, err := revision.List(context.Background(), c, "system", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Name != "shop-a" || revisions[1].Name != "shop-b" {
+		t.Fatalf("List() returned %v, want shop-a and shop-b", revisions)
+	}
+	spec, err := revision.Spec(&revisions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Sudoers) != 1 || spec.Sudoers[0].Name != "john" {
+		t.Errorf("Spec() = %v, want john as the sudoer", spec)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	a := &gialv1beta1.LNamespaceSpec{Sudoers: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "john"}}, Billing: map[string]string{"budget": "2.0"}}
+	b := a.DeepCopy()
+	if got := revision.Changes(a, b); len(got) != 0 {
+		t.Errorf("Changes() = %v for equal specs", got)
+	}
+	b.Sudoers = nil
+	b.Billing = map[string]string{"budget": "1.0"}
+	if got, want := revision.Changes(a, b), []string{"billing", "sudoers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+}
@@ -9,3 +9,35 @@ func Slug(s string) string {
 	s = strings.ReplaceAll(s, ".", "-")
 	return s
 }
+
+// SplitList splits a comma separated list, trimming whitespace and dropping empty entries.
+func SplitList(s string) []string {
+	out := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// KeyMatcher matches keys against a set of exact keys and key prefixes.
+type KeyMatcher struct {
+	Keys     []string
+	Prefixes []string
+}
+
+// Matches returns true if key is one of the exact keys or starts with one of the prefixes.
+func (m KeyMatcher) Matches(key string) bool {
+	for _, k := range m.Keys {
+		if k == key {
+			return true
+		}
+	}
+	for _, p := range m.Prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -12,3 +12,27 @@ func TestSlug(t *testing.T) {
 		t.Errorf("Slug(\"john@loblaw.ca\") = %s, want john-loblaw-ca", actual)
 	}
 }
+
+func TestSplitList(t *testing.T) {
+	actual := utils.SplitList(" istio.io/rev, ,team ")
+	if len(actual) != 2 || actual[0] != "istio.io/rev" || actual[1] != "team" {
+		t.Errorf("SplitList(\" istio.io/rev, ,team \") = %v, want [istio.io/rev team]", actual)
+	}
+}
+
+func TestKeyMatcher(t *testing.T) {
+	m := utils.KeyMatcher{
+		Keys:     []string{"istio.io/rev"},
+		Prefixes: []string{"pod-security.kubernetes.io/"},
+	}
+	for key, want := range map[string]bool{
+		"istio.io/rev":                       true,
+		"istio.io/revision":                  false,
+		"pod-security.kubernetes.io/enforce": true,
+		"team":                               false,
+	} {
+		if actual := m.Matches(key); actual != want {
+			t.Errorf("Matches(%q) = %v, want %v", key, actual, want)
+		}
+	}
+}
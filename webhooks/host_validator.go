@@ -0,0 +1,150 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/hosts"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-networking-istio-io-hosts,mutating=false,failurePolicy=fail,sideEffects=None,groups=networking.istio.io,resources=virtualservices;gateways,verbs=create;update,versions=v1alpha3;v1beta1,name=vhosts.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// The namespaceSelector of the webhook, which excludes the system namespaces so
+// that an outage of the controller does not block istio-system, is set in
+// deploy/webhook/manifests.yaml.
+
+// HostValidator rejects VirtualServices and Gateways that expose hosts which
+// are not claimed by the LNamespace of their namespace.
+type HostValidator struct {
+	Client client.Client
+	// InternalSuffixes are host suffixes that only resolve inside the cluster, e.g. .svc.cluster.local.
+	InternalSuffixes []string
+	decoder          *admission.Decoder
+}
+
+var _ admission.Handler = &HostValidator{}
+
+// Handle implements admission.Handler
+func (hv *HostValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	u := &unstructured.Unstructured{}
+	if err := hv.decoder.Decode(req, u); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	exposed, err := exposedHosts(u)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var external []string
+	for _, v := range exposed {
+		if !hv.internal(v) {
+			external = append(external, v)
+		}
+	}
+	if len(external) == 0 {
+		return admission.Allowed("")
+	}
+
+	ns := &gialv1beta1.LNamespace{}
+	err = hv.Client.Get(ctx, client.ObjectKey{Name: req.Namespace}, ns)
+	if apierrors.IsNotFound(err) {
+		return admission.Allowed("namespace is not managed by an LNamespace")
+	} else if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := hv.Client.List(ctx, l); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	owned := hosts.Owned(ns, l.Items)
+	for _, v := range external {
+		if !hosts.Claimed(owned, v) {
+			return admission.Denied(fmt.Sprintf("host %s is not claimed by LNamespace %s, add it to spec.hosts", v, ns.Name))
+		}
+	}
+	return admission.Allowed("")
+}
+
+// exposedHosts returns the hosts that u exposes through an ingress gateway.
+// VirtualServices that only apply to the mesh expose no hosts.
+func exposedHosts(u *unstructured.Unstructured) ([]string, error) {
+	switch u.GetKind() {
+	case "VirtualService":
+		gateways, _, err := unstructured.NestedStringSlice(u.Object, "spec", "gateways")
+		if err != nil {
+			return nil, err
+		}
+		exposed := false
+		for _, v := range gateways {
+			if v != "mesh" {
+				exposed = true
+			}
+		}
+		if !exposed {
+			return nil, nil
+		}
+		h, _, err := unstructured.NestedStringSlice(u.Object, "spec", "hosts")
+		return h, err
+	case "Gateway":
+		servers, _, err := unstructured.NestedSlice(u.Object, "spec", "servers")
+		if err != nil {
+			return nil, err
+		}
+		var exposed []string
+		for _, v := range servers {
+			server, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			h, _, err := unstructured.NestedStringSlice(server, "hosts")
+			if err != nil {
+				return nil, err
+			}
+			exposed = append(exposed, h...)
+		}
+		return exposed, nil
+	}
+	return nil, nil
+}
+
+// internal returns true if host only resolves inside the cluster.
+func (hv *HostValidator) internal(host string) bool {
+	host = hosts.Normalize(host)
+	if host != "*" && !strings.Contains(host, ".") {
+		return true
+	}
+	for _, v := range hv.InternalSuffixes {
+		if strings.HasSuffix(host, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
+func (hv *HostValidator) InjectDecoder(d *admission.Decoder) error {
+	hv.decoder = d
+	return nil
+}
@@ -0,0 +1,145 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("host validating webhook", func() {
+	var k8sClient client.Client
+	var hv *webhooks.HostValidator
+	var obj map[string]interface{}
+	var namespace string
+	var res admission.Response
+
+	var lnamespace = func(name string, created int64, claims ...string) *gialv1beta1.LNamespace {
+		return &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.Unix(created, 0),
+			},
+			Spec: gialv1beta1.LNamespaceSpec{Hosts: claims},
+		}
+	}
+
+	BeforeEach(func(done Done) {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			lnamespace("shop", 1, "*.shop.loblaw.ca"),
+			lnamespace("late", 2, "api.shop.loblaw.ca"),
+		).Build()
+		hv = &webhooks.HostValidator{
+			Client:           k8sClient,
+			InternalSuffixes: []string{".svc.cluster.local"},
+		}
+		hv.InjectDecoder(decoder)
+		namespace = "shop"
+		obj = map[string]interface{}{
+			"apiVersion": "networking.istio.io/v1beta1",
+			"kind":       "VirtualService",
+			"metadata":   map[string]interface{}{"name": "web"},
+			"spec": map[string]interface{}{
+				"gateways": []interface{}{"istio-system/public"},
+				"hosts":    []interface{}{"www.shop.loblaw.ca"},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+	JustBeforeEach(func(done Done) {
+		raw, err := json.Marshal(obj)
+		Expect(err).ToNot(HaveOccurred())
+		res = hv.Handle(context.Background(), admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		close(done)
+	}, TestTimeout)
+
+	It("accepts hosts claimed by the namespace", func(done Done) {
+		Expect(res.Allowed).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	When("the host is claimed by another namespace", func() {
+		BeforeEach(func(done Done) {
+			obj["spec"].(map[string]interface{})["hosts"] = []interface{}{"www.pharmacy.loblaw.ca"}
+			close(done)
+		}, TestTimeout)
+		It("rejects the virtual service", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace lost its claim to an older namespace", func() {
+		BeforeEach(func(done Done) {
+			namespace = "late"
+			obj["spec"].(map[string]interface{})["hosts"] = []interface{}{"api.shop.loblaw.ca"}
+			close(done)
+		}, TestTimeout)
+		It("rejects the virtual service", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the virtual service only applies to the mesh", func() {
+		BeforeEach(func(done Done) {
+			spec := obj["spec"].(map[string]interface{})
+			delete(spec, "gateways")
+			spec["hosts"] = []interface{}{"www.pharmacy.loblaw.ca"}
+			close(done)
+		}, TestTimeout)
+		It("accepts the virtual service", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("a gateway exposes an unclaimed host", func() {
+		BeforeEach(func(done Done) {
+			obj = map[string]interface{}{
+				"apiVersion": "networking.istio.io/v1beta1",
+				"kind":       "Gateway",
+				"metadata":   map[string]interface{}{"name": "public"},
+				"spec": map[string]interface{}{
+					"servers": []interface{}{
+						map[string]interface{}{"hosts": []interface{}{"shop/www.shop.loblaw.ca"}},
+						map[string]interface{}{"hosts": []interface{}{"*"}},
+					},
+				},
+			}
+			close(done)
+		}, TestTimeout)
+		It("rejects the gateway", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("host *"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace is not managed", func() {
+		BeforeEach(func(done Done) {
+			namespace = "istio-system"
+			close(done)
+		}, TestTimeout)
+		It("accepts the virtual service", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+})
@@ -0,0 +1,70 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-gial-lblw-dev-v1beta1-istiorevisionrollout,mutating=false,failurePolicy=fail,sideEffects=None,groups=gial.lblw.dev,resources=istiorevisionrollouts,verbs=create;update,versions=v1beta1,name=vistiorevisionrollout.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// IstioRevisionRolloutValidator rejects rollouts that move LNamespaces to the
+// revision they are on, and keeps started rollouts from changing which
+// LNamespaces they move and where, since their rollback reverts to From.
+type IstioRevisionRolloutValidator struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &IstioRevisionRolloutValidator{}
+
+// Handle implements admission.Handler
+func (rv *IstioRevisionRolloutValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	rollout := &gialv1beta1.IstioRevisionRollout{}
+	if err := rv.decoder.Decode(req, rollout); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if rollout.Spec.From == rollout.Spec.To {
+		return admission.Denied(fmt.Sprintf("spec.from and spec.to are both %s, nothing would be rolled out", rollout.Spec.To))
+	}
+	if req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	old := &gialv1beta1.IstioRevisionRollout{}
+	if err := rv.decoder.DecodeRaw(req.OldObject, old); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if old.Status.Phase == "" {
+		return admission.Allowed("")
+	}
+	if rollout.Spec.From != old.Spec.From || rollout.Spec.To != old.Spec.To || !equality.Semantic.DeepEqual(rollout.Spec.Selector, old.Spec.Selector) {
+		return admission.Denied(fmt.Sprintf("IstioRevisionRollout %s has started, so spec.from, spec.to and spec.selector cannot change, create a new rollout instead", rollout.Name))
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
+func (rv *IstioRevisionRolloutValidator) InjectDecoder(d *admission.Decoder) error {
+	rv.decoder = d
+	return nil
+}
@@ -0,0 +1,101 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("IstioRevisionRollout validator", func() {
+	var rollout, old *gialv1beta1.IstioRevisionRollout
+	var res admission.Response
+	BeforeEach(func(done Done) {
+		rollout = &gialv1beta1.IstioRevisionRollout{
+			ObjectMeta: metav1.ObjectMeta{Name: "to-1-9"},
+			Spec:       gialv1beta1.IstioRevisionRolloutSpec{From: "1-8", To: "1-9"},
+		}
+		old = nil
+		close(done)
+	}, TestTimeout)
+	JustBeforeEach(func(done Done) {
+		rv := &webhooks.IstioRevisionRolloutValidator{}
+		rv.InjectDecoder(decoder)
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create}}
+		raw, err := json.Marshal(rollout)
+		Expect(err).ToNot(HaveOccurred())
+		req.Object = runtime.RawExtension{Raw: raw}
+		if old != nil {
+			req.Operation = admissionv1.Update
+			raw, err := json.Marshal(old)
+			Expect(err).ToNot(HaveOccurred())
+			req.OldObject = runtime.RawExtension{Raw: raw}
+		}
+		res = rv.Handle(context.Background(), req)
+		close(done)
+	}, TestTimeout)
+
+	It("accepts a rollout to another revision", func(done Done) {
+		Expect(res.Allowed).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	When("the rollout moves namespaces to the revision they are on", func() {
+		BeforeEach(func(done Done) {
+			rollout.Spec.To = "1-8"
+			close(done)
+		}, TestTimeout)
+		It("rejects the rollout", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the rollout has started", func() {
+		BeforeEach(func(done Done) {
+			old = rollout.DeepCopy()
+			old.Status.Phase = gialv1beta1.RolloutProgressing
+			close(done)
+		}, TestTimeout)
+		Context("and it is paused", func() {
+			BeforeEach(func(done Done) {
+				rollout.Spec.Paused = true
+				close(done)
+			}, TestTimeout)
+			It("accepts the change", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and its target changes", func() {
+			BeforeEach(func(done Done) {
+				rollout.Spec.To = "1-10"
+				close(done)
+			}, TestTimeout)
+			It("rejects the change", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the rollout has not started", func() {
+		BeforeEach(func(done Done) {
+			old = rollout.DeepCopy()
+			rollout.Spec.To = "1-10"
+			close(done)
+		}, TestTimeout)
+		It("accepts changes", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+})
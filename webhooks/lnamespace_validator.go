@@ -0,0 +1,792 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+	"github.com/loblaw-sre/namespace-controller/pkg/hierarchy"
+	"github.com/loblaw-sre/namespace-controller/pkg/hosts"
+	"github.com/loblaw-sre/namespace-controller/pkg/istio"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+	"github.com/loblaw-sre/namespace-controller/pkg/restore"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-gial-lblw-dev-v1beta1-lnamespace,mutating=false,failurePolicy=fail,sideEffects=None,groups=gial.lblw.dev,resources=lnamespaces,verbs=create;update;delete,versions=v1beta1,name=vlnamespace.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// LNamespaceValidator rejects LNamespaces that violate cluster policy.
+type LNamespaceValidator struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	// ProtectedLabels are namespace labels that NamespaceLabelOverrides may not replace.
+	ProtectedLabels utils.KeyMatcher
+	// DefaultPodSecurityLevel is the cluster default. Looser levels require approval.
+	DefaultPodSecurityLevel gialv1beta1.PodSecurityLevel
+	// DefaultSize is the cluster default. Larger sizes require approval.
+	// Defaults to small.
+	DefaultSize gialv1beta1.NamespaceSize
+	// PlatformAdminGroups are the groups allowed to grant approvals.
+	PlatformAdminGroups []string
+	// MaxSudoSession is the longest sudo session that can be opened. Defaults to DefaultMaxSudoSession.
+	MaxSudoSession time.Duration
+	// TTLPolicies cap the lifetime of namespaces by name.
+	TTLPolicies []expiry.Policy
+	// OwnershipLimits cap how many LNamespaces a user, group or cost center can own.
+	OwnershipLimits OwnershipLimits
+	// NamingPolicy constrains the names of new LNamespaces.
+	NamingPolicy naming.Policy
+	// WildcardDomains are the domains below which any LNamespace can claim
+	// wildcard domains, e.g. *.shop.loblaw.ca below loblaw.ca. Other wildcard
+	// domains can only be claimed by platform admins.
+	WildcardDomains []string
+	// ClusterSecrets reads the cluster Secrets of ClusterSecretNamespace. When
+	// nil, only platform admins can place namespaces in member clusters.
+	ClusterSecrets client.Reader
+	// ClusterSecretNamespace is the namespace of the cluster Secrets.
+	ClusterSecretNamespace string
+	// RestoreRecords reads the restore records of RestoreNamespace. The
+	// LNamespace recorded there was admitted before it was soft deleted, and
+	// is admitted again as recorded when the controller recreates it.
+	RestoreRecords client.Reader
+	// RestoreNamespace is the namespace of the restore records.
+	RestoreNamespace string
+	// ControllerUsername is the user the controller creates LNamespaces as.
+	// The LNamespaces it creates for approved LNamespaceRequests were vetted
+	// by their approver, so they are admitted as if a platform admin had
+	// approved them.
+	ControllerUsername string
+	decoder            *admission.Decoder
+}
+
+// approvedRequestKey marks the context of the creation of an LNamespace for
+// an approved LNamespaceRequest.
+type approvedRequestKey struct{}
+
+// DefaultMaxSudoSession is the longest sudo session that can be opened by default.
+const DefaultMaxSudoSession = 8 * time.Hour
+
+// OwnershipLimits cap how many LNamespaces a subject or cost center can own.
+// Sudoers and managers set on a namespace own it, and so does the cost center
+// in its billing. Zero means unlimited.
+type OwnershipLimits struct {
+	// User caps the namespaces of each user and service account.
+	User int
+	// Group caps the namespaces of each group.
+	Group int
+	// CostCenter caps the namespaces of each cost center.
+	CostCenter int
+	// CostCenterKey is the billing key holding the cost center of a namespace.
+	CostCenterKey string
+}
+
+var _ admission.Handler = &LNamespaceValidator{}
+
+// rejection describes why a request was denied.
+type rejection struct {
+	reason  string
+	message string
+}
+
+// validation checks a single policy, returning a rejection if the policy is violated.
+type validation func(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection
+
+// Handle implements admission.Handler
+func (lnv *LNamespaceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		old := &gialv1beta1.LNamespace{}
+		if err := lnv.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if old.Spec.Protected() {
+			return lnv.deny(old, "DeletionProtection", fmt.Sprintf("LNamespace %s is protected from deletion, set spec.deletionProtection to false first", old.Name))
+		}
+		return admission.Allowed("")
+	}
+	ns := &gialv1beta1.LNamespace{}
+	err := lnv.decoder.Decode(req, ns)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	old := &gialv1beta1.LNamespace{}
+	if req.Operation == admissionv1.Update {
+		if err := lnv.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if req.Operation == admissionv1.Create {
+		// a restored LNamespace was admitted before it was soft deleted
+		if ok, err := restoring(ctx, lnv.RestoreRecords, lnv.RestoreNamespace, ns); err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		} else if ok {
+			return admission.Allowed("")
+		}
+		if origin := ns.Annotations[gialv1beta1.AnnotationRequest]; origin != "" && lnv.ControllerUsername != "" && req.UserInfo.Username == lnv.ControllerUsername {
+			if ok, err := lnv.requestApproved(ctx, origin, ns.Name); err != nil {
+				return admission.Errored(http.StatusInternalServerError, err)
+			} else if ok {
+				ctx = context.WithValue(ctx, approvedRequestKey{}, origin)
+			}
+		}
+	}
+
+	for _, validate := range []validation{
+		lnv.validateLabelOverrides,
+		lnv.validatePodSecurity,
+		lnv.validateSize,
+		lnv.validateIstioRevision,
+		lnv.validateHosts,
+		lnv.validateEnvironment,
+		lnv.validateSudoSessions,
+		lnv.validateExpiry,
+		lnv.validateDeletionProtection,
+		lnv.validateParent,
+		lnv.validateTeams,
+		lnv.validatePlacement,
+		lnv.validateOwnershipLimits,
+		lnv.validateName,
+	} {
+		if r := validate(ctx, req, ns, old); r != nil {
+			return lnv.deny(ns, r.reason, r.message)
+		}
+	}
+	return admission.Allowed("")
+}
+
+// validateLabelOverrides rejects overrides of protected labels. Overrides
+// that are unchanged are left alone, so that overrides made before a label was
+// protected do not block unrelated updates.
+func (lnv *LNamespaceValidator) validateLabelOverrides(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	for k, v := range ns.Spec.NamespaceLabelOverrides {
+		if ov, ok := old.Spec.NamespaceLabelOverrides[k]; ok && ov == v {
+			continue
+		}
+		if lnv.ProtectedLabels.Matches(k) || strings.HasPrefix(k, gialv1beta1.PodSecurityLabelPrefix) {
+			return &rejection{"ProtectedLabel", fmt.Sprintf("label %s is protected and cannot be set through namespaceLabelOverrides", k)}
+		}
+	}
+	return nil
+}
+
+// validatePodSecurity rejects pod security levels looser than the cluster
+// default, unless a platform admin has approved them. Levels that are
+// unchanged or tightened are left alone, including levels migrated from label
+// overrides, so that existing exceptions do not block unrelated updates.
+func (lnv *LNamespaceValidator) validatePodSecurity(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	approval := gialv1beta1.PodSecurityLevel(ns.Annotations[gialv1beta1.AnnotationPodSecurityApproval])
+	if string(approval) != old.Annotations[gialv1beta1.AnnotationPodSecurityApproval] {
+		if !isMember(req.UserInfo, lnv.PlatformAdminGroups) {
+			return &rejection{"Unauthorized", fmt.Sprintf("only platform admins can set the %s annotation", gialv1beta1.AnnotationPodSecurityApproval)}
+		}
+		if approval != "" && !approval.Valid() {
+			return &rejection{"PodSecurity", fmt.Sprintf("%s must be one of privileged, baseline or restricted", gialv1beta1.AnnotationPodSecurityApproval)}
+		}
+	}
+	if ns.Spec.PodSecurity == nil {
+		return nil
+	}
+	oldLevels := map[string]gialv1beta1.PodSecurityLevel{}
+	if old.Spec.PodSecurity != nil {
+		oldLevels = old.Spec.PodSecurity.Levels()
+	}
+	for mode, level := range ns.Spec.PodSecurity.Levels() {
+		if !level.LooserThan(lnv.DefaultPodSecurityLevel) {
+			continue
+		}
+		previous := oldLevels[mode]
+		if previous == "" {
+			previous = gialv1beta1.PodSecurityLevel(old.Spec.NamespaceLabelOverrides[gialv1beta1.PodSecurityLabelPrefix+mode])
+		}
+		if previous.Valid() && !level.LooserThan(previous) || fromApprovedRequest(ctx) {
+			continue
+		}
+		if approval == "" || level.LooserThan(approval) {
+			return &rejection{"PodSecurity", fmt.Sprintf("pod security %s level %s is looser than the cluster default %s and requires platform admin approval through the %s annotation", mode, level, lnv.DefaultPodSecurityLevel, gialv1beta1.AnnotationPodSecurityApproval)}
+		}
+	}
+	return nil
+}
+
+// validateSize rejects custom sizes without hard limits, and quota or limits
+// on platform-defined sizes. Sizes larger than the cluster default and the
+// previous size require platform admin approval, and only platform admins can
+// set custom sizes or the none size, so that managers cannot lift their own
+// quota.
+func (lnv *LNamespaceValidator) validateSize(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	approval := gialv1beta1.NamespaceSize(ns.Annotations[gialv1beta1.AnnotationSizeApproval])
+	if string(approval) != old.Annotations[gialv1beta1.AnnotationSizeApproval] {
+		if !isMember(req.UserInfo, lnv.PlatformAdminGroups) {
+			return &rejection{"Unauthorized", fmt.Sprintf("only platform admins can set the %s annotation", gialv1beta1.AnnotationSizeApproval)}
+		}
+		if approval != "" && !approval.Platform() {
+			return &rejection{"Size", fmt.Sprintf("%s must be one of small, medium or large", gialv1beta1.AnnotationSizeApproval)}
+		}
+	}
+	if ns.Spec.Size == gialv1beta1.SizeCustom && ns.Spec.CustomSize == nil {
+		return &rejection{"Size", "customSize must be set when size is custom"}
+	}
+	if ns.Spec.Size != gialv1beta1.SizeCustom && ns.Spec.CustomSize != nil {
+		return &rejection{"Size", "customSize can only be set when size is custom"}
+	}
+	if ns.Spec.Size == gialv1beta1.SizeCustom {
+		if apiequality.Semantic.DeepEqual(ns.Spec.CustomSize, old.Spec.CustomSize) {
+			return nil
+		}
+		if len(ns.Spec.CustomSize.Hard) == 0 {
+			return &rejection{"Size", "customSize.hard must set at least one limit"}
+		}
+		if !isMember(req.UserInfo, lnv.PlatformAdminGroups) && !fromApprovedRequest(ctx) {
+			return &rejection{"Unauthorized", "only platform admins can set customSize"}
+		}
+		return nil
+	}
+	if ns.Spec.Size == gialv1beta1.SizeNone {
+		if old.Spec.Size != gialv1beta1.SizeNone && !isMember(req.UserInfo, lnv.PlatformAdminGroups) && !fromApprovedRequest(ctx) {
+			return &rejection{"Unauthorized", "only platform admins can set the none size"}
+		}
+		return nil
+	}
+	previous := old.Spec.Size
+	if !previous.Platform() {
+		previous = lnv.DefaultSize
+	}
+	if !previous.Platform() {
+		previous = gialv1beta1.SizeSmall
+	}
+	if ns.Spec.Size.LargerThan(previous) && (!approval.Platform() || ns.Spec.Size.LargerThan(approval)) && !fromApprovedRequest(ctx) {
+		return &rejection{"Size", fmt.Sprintf("size %s is larger than %s and requires platform admin approval through the %s annotation", ns.Spec.Size, previous, gialv1beta1.AnnotationSizeApproval)}
+	}
+	return nil
+}
+
+// validateIstioRevision rejects istio revisions that no installed control plane
+// serves. Revisions that are unchanged are left alone, so that removing a
+// control plane does not block unrelated updates.
+func (lnv *LNamespaceValidator) validateIstioRevision(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if ns.Spec.IstioRevision == "" || ns.Spec.IstioRevision == old.Spec.IstioRevision {
+		return nil
+	}
+	revisions, err := istio.Revisions(ctx, lnv.Client)
+	if err != nil {
+		return &rejection{"IstioRevision", fmt.Sprintf("unable to verify istio revision %s: %v", ns.Spec.IstioRevision, err)}
+	}
+	if !istio.Contains(revisions, ns.Spec.IstioRevision) {
+		return &rejection{"IstioRevision", fmt.Sprintf("istio revision %s is not installed, valid revisions: %s", ns.Spec.IstioRevision, strings.Join(revisions, ", "))}
+	}
+	return nil
+}
+
+// validateHosts rejects malformed host claims and new claims that overlap
+// the claims of another LNamespace. The oldest claim wins, so broad wildcard
+// domains can only be claimed by platform admins.
+func (lnv *LNamespaceValidator) validateHosts(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	var added []string
+	for _, v := range ns.Spec.Hosts {
+		errs := utilvalidation.IsDNS1123Subdomain(v)
+		if strings.HasPrefix(v, "*.") {
+			errs = utilvalidation.IsWildcardDNS1123Subdomain(v)
+		}
+		if len(errs) > 0 {
+			return &rejection{"HostClaim", fmt.Sprintf("host %s must be a hostname or a wildcard domain: %s", v, strings.Join(errs, ", "))}
+		}
+		if contains(old.Spec.Hosts, v) {
+			continue
+		}
+		if strings.HasPrefix(v, "*.") && !lnv.selfServiceWildcard(v) && !isMember(req.UserInfo, lnv.PlatformAdminGroups) && !fromApprovedRequest(ctx) {
+			message := fmt.Sprintf("only platform admins can claim wildcard domain %s", v)
+			if len(lnv.WildcardDomains) > 0 {
+				message += fmt.Sprintf(", others can claim wildcard domains below %s", strings.Join(lnv.WildcardDomains, ", "))
+			}
+			return &rejection{"Unauthorized", message}
+		}
+		added = append(added, v)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := lnv.Client.List(ctx, l); err != nil {
+		return &rejection{"HostClaim", fmt.Sprintf("unable to verify host claims: %v", err)}
+	}
+	for _, other := range l.Items {
+		if other.Name == ns.Name {
+			continue
+		}
+		for _, claim := range added {
+			for _, v := range other.Spec.Hosts {
+				if hosts.Overlaps(claim, v) {
+					return &rejection{"HostClaim", fmt.Sprintf("host %s overlaps %s, which is claimed by %s", claim, v, other.Name)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// selfServiceWildcard returns true if the wildcard domain claim is at least
+// one label below one of the WildcardDomains, e.g. *.shop.loblaw.ca below
+// loblaw.ca, but not *.loblaw.ca itself.
+func (lnv *LNamespaceValidator) selfServiceWildcard(claim string) bool {
+	domain := hosts.Normalize(strings.TrimPrefix(claim, "*."))
+	for _, v := range lnv.WildcardDomains {
+		if strings.HasSuffix(domain, "."+hosts.Normalize(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEnvironment rejects moving a prod namespace to another environment,
+// which would lift its RBAC restrictions, unless done by a platform admin.
+func (lnv *LNamespaceValidator) validateEnvironment(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if req.Operation != admissionv1.Update || old.Spec.Env() != gialv1beta1.EnvironmentProd || ns.Spec.Env() == gialv1beta1.EnvironmentProd {
+		return nil
+	}
+	if !isMember(req.UserInfo, lnv.PlatformAdminGroups) {
+		return &rejection{"Unauthorized", fmt.Sprintf("only platform admins can move a namespace out of %s", gialv1beta1.EnvironmentProd)}
+	}
+	return nil
+}
+
+// validateSudoSessions rejects sessions for subjects that are not sudoers,
+// and new sessions that have already expired or that last longer than
+// MaxSudoSession. Unchanged sessions are left alone, so that expired
+// sessions do not block unrelated updates. Sudoers inherited from teams and
+// parents can open sessions as well.
+func (lnv *LNamespaceValidator) validateSudoSessions(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if len(ns.Spec.SudoSessions) == 0 {
+		return nil
+	}
+	max := lnv.MaxSudoSession
+	if max == 0 {
+		max = DefaultMaxSudoSession
+	}
+	resolved, err := hierarchy.Resolve(ctx, lnv.Client, ns)
+	if err != nil {
+		return &rejection{"SudoSession", fmt.Sprintf("unable to resolve the sudoers of %s: %v", ns.Name, err)}
+	}
+	now := time.Now()
+	for _, v := range ns.Spec.SudoSessions {
+		sudoer := false
+		for _, s := range resolved.Spec.Sudoers {
+			if s.Name == v.Name {
+				sudoer = true
+			}
+		}
+		if !sudoer {
+			return &rejection{"SudoSession", fmt.Sprintf("sudo session for %s is not allowed, %s is not a sudoer", v.Name, v.Name)}
+		}
+		opened := true
+		for _, o := range old.Spec.SudoSessions {
+			if o.Name == v.Name && o.Expires.Equal(&v.Expires) {
+				opened = false
+			}
+		}
+		if !opened {
+			continue
+		}
+		if !v.Expires.After(now) {
+			return &rejection{"SudoSession", fmt.Sprintf("sudo session for %s has already expired", v.Name)}
+		}
+		if v.Expires.Sub(now) > max {
+			return &rejection{"SudoSession", fmt.Sprintf("sudo session for %s cannot last longer than %s", v.Name, max)}
+		}
+	}
+	return nil
+}
+
+// validateExpiry rejects namespaces that set both expiresAt and ttl, and new
+// expiries that are in the past. Namespaces matching a TTL policy must expire,
+// and a new expiry may be at most the maximum TTL of the policy from now, which
+// also caps extensions.
+func (lnv *LNamespaceValidator) validateExpiry(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if ns.Spec.ExpiresAt != nil && ns.Spec.TTL != nil {
+		return &rejection{"Expiry", "expiresAt and ttl cannot both be set"}
+	}
+	if ns.Spec.TTL != nil && ns.Spec.TTL.Duration <= 0 {
+		return &rejection{"Expiry", "ttl must be positive"}
+	}
+	now := time.Now()
+	created := ns
+	if ns.CreationTimestamp.IsZero() {
+		// the namespace is being created, so a ttl counts from now
+		created = ns.DeepCopy()
+		created.CreationTimestamp.Time = now
+	}
+	expiresAt, expires := created.Expiry()
+	oldExpiresAt, oldExpires := old.Expiry()
+	changed := expires != oldExpires || !expiresAt.Equal(oldExpiresAt)
+	if expires && changed && !expiresAt.After(now) {
+		return &rejection{"Expiry", fmt.Sprintf("namespace cannot expire in the past, at %s", expiresAt.Format(time.RFC3339))}
+	}
+	max, ok := expiry.MaxTTL(lnv.TTLPolicies, ns.Name)
+	if !ok {
+		return nil
+	}
+	if !expires {
+		if req.Operation == admissionv1.Create || oldExpires {
+			return &rejection{"Expiry", fmt.Sprintf("namespace %s must set expiresAt or ttl, at most %s", ns.Name, max)}
+		}
+		return nil
+	}
+	if changed && expiresAt.Sub(now) > max {
+		return &rejection{"Expiry", fmt.Sprintf("namespace %s cannot expire more than %s from now", ns.Name, max)}
+	}
+	return nil
+}
+
+// validateDeletionProtection rejects removing the deletion protection of a
+// namespace, unless done by one of its sudoers or a platform admin. Sudoers
+// inherited from teams and parents count as sudoers.
+func (lnv *LNamespaceValidator) validateDeletionProtection(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if req.Operation != admissionv1.Update || !old.Spec.Protected() || ns.Spec.Protected() {
+		return nil
+	}
+	if isMember(req.UserInfo, lnv.PlatformAdminGroups) {
+		return nil
+	}
+	resolved, err := hierarchy.Resolve(ctx, lnv.Client, old)
+	if err != nil {
+		return &rejection{"DeletionProtection", fmt.Sprintf("unable to resolve the sudoers of %s: %v", ns.Name, err)}
+	}
+	if !isSudoer(req.UserInfo, resolved) {
+		return &rejection{"Unauthorized", fmt.Sprintf("only sudoers of %s and platform admins can remove its deletion protection", ns.Name)}
+	}
+	return nil
+}
+
+// contains returns true if s is one of list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isMember returns true if the user belongs to any of the groups.
+func isMember(user authenticationv1.UserInfo, groups []string) bool {
+	for _, g := range user.Groups {
+		for _, v := range groups {
+			if g == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSudoer returns true if the user is one of the sudoers of ns, or sudoes
+// as its sudoers group.
+func isSudoer(user authenticationv1.UserInfo, ns *gialv1beta1.LNamespace) bool {
+	return contains(user.Groups, ns.GetSudoersGroupName()) || isSubject(user, ns.Spec.Sudoers)
+}
+
+// isSubject returns true if the user is one of subjects, directly or through
+// one of its groups.
+func isSubject(user authenticationv1.UserInfo, subjects []rbacv1.Subject) bool {
+	for _, v := range subjects {
+		switch v.Kind {
+		case rbacv1.UserKind:
+			if v.Name == user.Username {
+				return true
+			}
+		case rbacv1.GroupKind:
+			if contains(user.Groups, v.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deny rejects the request and reports the rejection as an event on the LNamespace.
+func (lnv *LNamespaceValidator) deny(ns *gialv1beta1.LNamespace, reason, message string) admission.Response {
+	lnv.Recorder.Event(ns, "Warning", reason, message)
+	return admission.Denied(message)
+}
+
+// InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
+func (lnv *LNamespaceValidator) InjectDecoder(d *admission.Decoder) error {
+	lnv.decoder = d
+	return nil
+}
+
+// validateParent rejects parents that do not exist, parents that would make
+// the namespace its own ancestor, and parents the requester does not
+// administer.
+func (lnv *LNamespaceValidator) validateParent(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if ns.Spec.Parent == "" || ns.Spec.Parent == old.Spec.Parent {
+		return nil
+	}
+	parent := &gialv1beta1.LNamespace{}
+	if err := lnv.Client.Get(ctx, client.ObjectKey{Name: ns.Spec.Parent}, parent); apierrors.IsNotFound(err) {
+		return &rejection{"Parent", fmt.Sprintf("parent %s does not exist", ns.Spec.Parent)}
+	} else if err != nil {
+		return &rejection{"Parent", fmt.Sprintf("unable to verify parent %s: %v", ns.Spec.Parent, err)}
+	}
+	if _, err := hierarchy.Ancestors(ctx, lnv.Client, ns); err != nil {
+		var cycle *hierarchy.CycleError
+		if errors.As(err, &cycle) {
+			return &rejection{"Parent", fmt.Sprintf("parent %s is not allowed, since the %s", ns.Spec.Parent, cycle.Error())}
+		}
+		return &rejection{"Parent", fmt.Sprintf("unable to verify parent %s: %v", ns.Spec.Parent, err)}
+	}
+	if isMember(req.UserInfo, lnv.PlatformAdminGroups) || fromApprovedRequest(ctx) {
+		return nil
+	}
+	// the child inherits the billing and label overrides of its parent, so
+	// only those who administer the parent can attach namespaces to it
+	resolved, err := hierarchy.Resolve(ctx, lnv.Client, parent)
+	if err != nil {
+		return &rejection{"Parent", fmt.Sprintf("unable to resolve the sudoers of %s: %v", ns.Spec.Parent, err)}
+	}
+	if !isSudoer(req.UserInfo, resolved) && !isSubject(req.UserInfo, resolved.Spec.Managers) {
+		return &rejection{"Unauthorized", fmt.Sprintf("only sudoers and managers of %s and platform admins can make it the parent of a namespace", ns.Spec.Parent)}
+	}
+	return nil
+}
+
+// validateTeams rejects references to teams that do not exist, and to teams
+// the requester is not a member of, since the namespace inherits the billing
+// and subjects of its teams.
+func (lnv *LNamespaceValidator) validateTeams(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	for _, v := range ns.Spec.Teams {
+		if contains(old.Spec.Teams, v) {
+			continue
+		}
+		team := &gialv1beta1.LTeam{}
+		if err := lnv.Client.Get(ctx, client.ObjectKey{Name: v}, team); apierrors.IsNotFound(err) {
+			return &rejection{"Team", fmt.Sprintf("team %s does not exist", v)}
+		} else if err != nil {
+			return &rejection{"Team", fmt.Sprintf("unable to verify team %s: %v", v, err)}
+		}
+		if isMember(req.UserInfo, lnv.PlatformAdminGroups) || fromApprovedRequest(ctx) {
+			continue
+		}
+		if !isSubject(req.UserInfo, team.Spec.Managers) && !isSubject(req.UserInfo, team.Spec.Sudoers) && !isSubject(req.UserInfo, team.Spec.Developers) {
+			return &rejection{"Unauthorized", fmt.Sprintf("only members of team %s and platform admins can add it to a namespace", v)}
+		}
+	}
+	return nil
+}
+
+// validatePlacement rejects placing a namespace in a member cluster unless
+// done by a platform admin or a member of the groups allowed by the cluster
+// Secret, since the sudoers of a namespace administer it in every cluster it
+// is placed in. Cluster selectors also match the clusters registered later,
+// so only platform admins can set them.
+func (lnv *LNamespaceValidator) validatePlacement(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if isMember(req.UserInfo, lnv.PlatformAdminGroups) || fromApprovedRequest(ctx) {
+		return nil
+	}
+	if ns.Spec.ClusterSelector != nil && !apiequality.Semantic.DeepEqual(ns.Spec.ClusterSelector, old.Spec.ClusterSelector) {
+		return &rejection{"Unauthorized", "only platform admins can set spec.clusterSelector"}
+	}
+	for _, v := range ns.Spec.Clusters {
+		if contains(old.Spec.Clusters, v) {
+			continue
+		}
+		if lnv.ClusterSecrets == nil {
+			return &rejection{"Unauthorized", fmt.Sprintf("only platform admins can place namespaces in cluster %s", v)}
+		}
+		secret := &corev1.Secret{}
+		err := lnv.ClusterSecrets.Get(ctx, client.ObjectKey{Namespace: lnv.ClusterSecretNamespace, Name: v}, secret)
+		if apierrors.IsNotFound(err) {
+			return &rejection{"Cluster", fmt.Sprintf("cluster %s does not exist", v)}
+		} else if err != nil {
+			return &rejection{"Cluster", fmt.Sprintf("unable to verify cluster %s: %v", v, err)}
+		}
+		if _, ok := secret.Labels[gialv1beta1.LabelClusterSecret]; !ok {
+			return &rejection{"Cluster", fmt.Sprintf("cluster %s does not exist", v)}
+		}
+		if !isMember(req.UserInfo, utils.SplitList(secret.Annotations[gialv1beta1.AnnotationClusterAllowedGroups])) {
+			return &rejection{"Unauthorized", fmt.Sprintf("only platform admins and the groups allowed by the %s annotation of its cluster Secret can place namespaces in cluster %s", gialv1beta1.AnnotationClusterAllowedGroups, v)}
+		}
+	}
+	return nil
+}
+
+// validateOwnershipLimits rejects namespaces that would take a new owner or
+// cost center over its ownership limit, unless a platform admin has approved
+// the namespace.
+func (lnv *LNamespaceValidator) validateOwnershipLimits(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	approval := ns.Annotations[gialv1beta1.AnnotationOwnershipLimitApproval]
+	if approval != old.Annotations[gialv1beta1.AnnotationOwnershipLimitApproval] && !isMember(req.UserInfo, lnv.PlatformAdminGroups) {
+		return &rejection{"Unauthorized", fmt.Sprintf("only platform admins can set the %s annotation", gialv1beta1.AnnotationOwnershipLimitApproval)}
+	}
+	if approval == "true" || fromApprovedRequest(ctx) {
+		return nil
+	}
+	limits := lnv.OwnershipLimits
+	var added []rbacv1.Subject
+	for _, v := range owners(ns) {
+		if !hasSubject(owners(old), v) && limits.of(v) > 0 {
+			added = append(added, v)
+		}
+	}
+	costCenter := ns.Spec.Billing[limits.CostCenterKey]
+	if limits.CostCenterKey == "" || limits.CostCenter == 0 || costCenter == old.Spec.Billing[limits.CostCenterKey] {
+		costCenter = ""
+	}
+	if len(added) == 0 && costCenter == "" {
+		return nil
+	}
+	l := &gialv1beta1.LNamespaceList{}
+	if err := lnv.Client.List(ctx, l); err != nil {
+		return &rejection{"OwnershipLimit", fmt.Sprintf("unable to verify ownership limits: %v", err)}
+	}
+	escape := fmt.Sprintf("remove one of them or ask a platform admin to approve %s through the %s annotation", ns.Name, gialv1beta1.AnnotationOwnershipLimitApproval)
+	for _, v := range added {
+		count := 0
+		for i := range l.Items {
+			if l.Items[i].Name != ns.Name && hasSubject(owners(&l.Items[i]), v) {
+				count++
+			}
+		}
+		if max := limits.of(v); count >= max {
+			return &rejection{"OwnershipLimit", fmt.Sprintf("%s %s already owns %d LNamespaces, the limit is %d, %s", strings.ToLower(v.Kind), v.Name, count, max, escape)}
+		}
+	}
+	if costCenter != "" {
+		count := 0
+		for _, v := range l.Items {
+			if v.Name != ns.Name && v.Spec.Billing[limits.CostCenterKey] == costCenter {
+				count++
+			}
+		}
+		if count >= limits.CostCenter {
+			return &rejection{"OwnershipLimit", fmt.Sprintf("cost center %s already owns %d LNamespaces, the limit is %d, %s", costCenter, count, limits.CostCenter, escape)}
+		}
+	}
+	return nil
+}
+
+// of returns the limit that applies to the subject.
+func (l OwnershipLimits) of(s rbacv1.Subject) int {
+	if s.Kind == rbacv1.GroupKind {
+		return l.Group
+	}
+	return l.User
+}
+
+// owners returns the sudoers and managers set on ns.
+func owners(ns *gialv1beta1.LNamespace) []rbacv1.Subject {
+	res := append([]rbacv1.Subject{}, ns.Spec.Sudoers...)
+	for _, v := range ns.Spec.Managers {
+		if !hasSubject(res, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// hasSubject returns true if list holds the same subject as s, ignoring its API group.
+func hasSubject(list []rbacv1.Subject, s rbacv1.Subject) bool {
+	for _, v := range list {
+		if v.Kind == s.Kind && v.Name == s.Name && v.Namespace == s.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// validateName rejects new namespaces that violate the naming policy.
+// Namespaces created for an approved LNamespaceRequest are left alone, since
+// the requester was checked when the request was made.
+func (lnv *LNamespaceValidator) validateName(ctx context.Context, req admission.Request, ns, old *gialv1beta1.LNamespace) *rejection {
+	if req.Operation != admissionv1.Create || fromApprovedRequest(ctx) {
+		return nil
+	}
+	return validateNaming(ctx, lnv.Client, lnv.NamingPolicy, req.UserInfo, lnv.PlatformAdminGroups, ns)
+}
+
+// fromApprovedRequest returns true if ctx is the context of the creation of
+// an LNamespace for an approved LNamespaceRequest, by the controller.
+func fromApprovedRequest(ctx context.Context) bool {
+	return ctx.Value(approvedRequestKey{}) != nil
+}
+
+// requestApproved returns true if the LNamespaceRequest named origin, as
+// namespace/name, was approved for an LNamespace called name.
+func (lnv *LNamespaceValidator) requestApproved(ctx context.Context, origin, name string) (bool, error) {
+	key := strings.SplitN(origin, "/", 2)
+	if len(key) != 2 {
+		return false, nil
+	}
+	lnsr := &gialv1beta1.LNamespaceRequest{}
+	if err := lnv.Client.Get(ctx, client.ObjectKey{Namespace: key[0], Name: key[1]}, lnsr); apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return lnsr.Spec.Decision == gialv1beta1.DecisionApproved && lnsr.Spec.Name == name, nil
+}
+
+// restoring returns true if ns is exactly the LNamespace recorded in
+// namespace by a restore, which the controller recreates once the soft deleted
+// LNamespace is gone.
+func restoring(ctx context.Context, c client.Reader, namespace string, ns *gialv1beta1.LNamespace) (bool, error) {
+	if c == nil || namespace == "" {
+		return false, nil
+	}
+	recorded, err := restore.Get(ctx, c, namespace, ns.Name)
+	if err != nil || recorded == nil {
+		return false, err
+	}
+	return apiequality.Semantic.DeepEqual(recorded.Spec, ns.Spec) &&
+		apiequality.Semantic.DeepEqual(recorded.Labels, ns.Labels) &&
+		apiequality.Semantic.DeepEqual(recorded.Annotations, ns.Annotations), nil
+}
+
+// validateNaming rejects names that do not match the naming pattern for the
+// billing of ns, including what it inherits, and names with a prefix reserved
+// for groups the user is not a member of. Platform admins may use any prefix.
+func validateNaming(ctx context.Context, c client.Reader, policy naming.Policy, user authenticationv1.UserInfo, adminGroups []string, ns *gialv1beta1.LNamespace) *rejection {
+	if policy.Pattern != "" {
+		resolved, err := hierarchy.Resolve(ctx, c, ns)
+		if err != nil {
+			return &rejection{"Naming", fmt.Sprintf("unable to resolve the billing of %s: %v", ns.Name, err)}
+		}
+		if err := policy.CheckPattern(ns.Name, resolved.Spec.Billing); err != nil {
+			return &rejection{"Naming", err.Error()}
+		}
+	}
+	prefix, groups := policy.Reserved(ns.Name)
+	if prefix != "" && !isMember(user, groups) && !isMember(user, adminGroups) {
+		return &rejection{"Naming", fmt.Sprintf("names starting with %s are reserved for members of %s", prefix, strings.Join(groups, ", "))}
+	}
+	return nil
+}
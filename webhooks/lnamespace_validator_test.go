@@ -0,0 +1,1267 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/expiry"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+	"github.com/loblaw-sre/namespace-controller/pkg/restore"
+	"github.com/loblaw-sre/namespace-controller/pkg/utils"
+	"github.com/loblaw-sre/namespace-controller/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("validating webhook", func() {
+	var k8sClient client.Client
+	var ns *gialv1beta1.LNamespace
+	var lnv *webhooks.LNamespaceValidator
+	var recorder *record.FakeRecorder
+	var req admission.Request
+	var res admission.Response
+	BeforeEach(func(done Done) {
+		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		recorder = record.NewFakeRecorder(64)
+		lnv = &webhooks.LNamespaceValidator{
+			Client:   k8sClient,
+			Recorder: recorder,
+			ProtectedLabels: utils.KeyMatcher{
+				Keys:     []string{"istio.io/rev"},
+				Prefixes: []string{"pod-security.kubernetes.io/"},
+			},
+			DefaultPodSecurityLevel: gialv1beta1.PodSecurityRestricted,
+			PlatformAdminGroups:     []string{platformAdmins},
+			TTLPolicies:             []expiry.Policy{{Pattern: "preview-*", MaxTTL: 72 * time.Hour}},
+			ControllerUsername:      controller,
+		}
+		lnv.InjectDecoder(decoder)
+		ns = &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: DefaultName,
+			},
+		}
+		req = admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				UserInfo: authenticationv1.UserInfo{
+					Username: john,
+				},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+	JustBeforeEach(func(done Done) {
+		raw, err := json.Marshal(ns)
+		Expect(err).ToNot(HaveOccurred(), "Marshalling namespace definition should not have errored.")
+		req.Object = runtime.RawExtension{Raw: raw}
+		res = lnv.Handle(context.Background(), req)
+		close(done)
+	}, TestTimeout)
+
+	It("accepts a namespace without label overrides", func(done Done) {
+		Expect(res.Allowed).To(BeTrue())
+		close(done)
+	}, TestTimeout)
+
+	When("label overrides contain unprotected labels", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.NamespaceLabelOverrides = map[string]string{"custom": "value"}
+			close(done)
+		}, TestTimeout)
+		It("accepts the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("label overrides contain a protected key", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.NamespaceLabelOverrides = map[string]string{"istio.io/rev": "other"}
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+		It("reports the rejection as an event", func(done Done) {
+			Expect(recorder.Events).To(Receive(ContainSubstring("ProtectedLabel")))
+			close(done)
+		}, TestTimeout)
+
+		Context("that was set before the label was protected", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				ns.Finalizers = []string{gialv1beta1.FinalizerClusters}
+				close(done)
+			}, TestTimeout)
+			It("accepts updates that keep the override", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+
+			Context("and the override is changed", func() {
+				BeforeEach(func(done Done) {
+					ns.Spec.NamespaceLabelOverrides = map[string]string{"istio.io/rev": "another"}
+					close(done)
+				}, TestTimeout)
+				It("rejects the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeFalse())
+					close(done)
+				}, TestTimeout)
+			})
+		})
+	})
+
+	When("label overrides contain a protected prefix", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.NamespaceLabelOverrides = map[string]string{"pod-security.kubernetes.io/enforce": "privileged"}
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+	When("pod security is looser than the cluster default", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{
+				Enforce: gialv1beta1.PodSecurityBaseline,
+				Audit:   gialv1beta1.PodSecurityRestricted,
+				Warn:    gialv1beta1.PodSecurityRestricted,
+			}
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace without approval", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(recorder.Events).To(Receive(ContainSubstring("PodSecurity")))
+			close(done)
+		}, TestTimeout)
+
+		Context("and the level was set through a label override before", func() {
+			BeforeEach(func(done Done) {
+				levels := ns.Spec.PodSecurity
+				ns.Spec.PodSecurity = nil
+				ns.Spec.NamespaceLabelOverrides = map[string]string{"pod-security.kubernetes.io/enforce": "baseline"}
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				ns.Spec.PodSecurity = levels
+				ns.Spec.NamespaceLabelOverrides = nil
+				close(done)
+			}, TestTimeout)
+			It("accepts the migrated level without approval", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+
+			Context("and the level is loosened", func() {
+				BeforeEach(func(done Done) {
+					ns.Spec.PodSecurity.Enforce = gialv1beta1.PodSecurityPrivileged
+					close(done)
+				}, TestTimeout)
+				It("rejects the namespace without approval", func(done Done) {
+					Expect(res.Allowed).To(BeFalse())
+					close(done)
+				}, TestTimeout)
+			})
+		})
+
+		Context("with approval from a platform admin", func() {
+			BeforeEach(func(done Done) {
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: "baseline"}
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with approval for a stricter level", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.PodSecurity.Enforce = gialv1beta1.PodSecurityPrivileged
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: "baseline"}
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with approval set by someone who is not a platform admin", func() {
+			BeforeEach(func(done Done) {
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: "baseline"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(recorder.Events).To(Receive(ContainSubstring("Unauthorized")))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("an existing approval is kept on update by someone who is not a platform admin", func() {
+		BeforeEach(func(done Done) {
+			ns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: "privileged"}
+			ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{Enforce: gialv1beta1.PodSecurityPrivileged}
+			raw, err := json.Marshal(ns)
+			Expect(err).ToNot(HaveOccurred())
+			req.Operation = admissionv1.Update
+			req.OldObject = runtime.RawExtension{Raw: raw}
+			close(done)
+		}, TestTimeout)
+		It("accepts the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+	When("the size is custom without a custom size", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeCustom
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the size is custom", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeCustom
+			ns.Spec.CustomSize = &gialv1beta1.CustomSize{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("100")},
+			}
+			close(done)
+		}, TestTimeout)
+		It("rejects users who are not platform admins", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("only platform admins can set customSize"))
+			close(done)
+		}, TestTimeout)
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+
+			Context("without hard limits", func() {
+				BeforeEach(func(done Done) {
+					ns.Spec.CustomSize = &gialv1beta1.CustomSize{}
+					close(done)
+				}, TestTimeout)
+				It("rejects the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeFalse())
+					Expect(string(res.Result.Reason)).To(ContainSubstring("customSize.hard must set at least one limit"))
+					close(done)
+				}, TestTimeout)
+			})
+		})
+
+		Context("that was set before", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts updates that keep it", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the size is none", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Size = gialv1beta1.SizeNone
+			close(done)
+		}, TestTimeout)
+		It("rejects users who are not platform admins", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("only platform admins can set the none size"))
+			close(done)
+		}, TestTimeout)
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that was set before", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts updates that keep it", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the size is larger than the default", func() {
+		BeforeEach(func(done Done) {
+			lnv.DefaultSize = gialv1beta1.SizeSmall
+			ns.Spec.Size = gialv1beta1.SizeLarge
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring(gialv1beta1.AnnotationSizeApproval))
+			close(done)
+		}, TestTimeout)
+
+		Context("with approval from a platform admin", func() {
+			BeforeEach(func(done Done) {
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationSizeApproval: string(gialv1beta1.SizeLarge)}
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with a smaller approval", func() {
+			BeforeEach(func(done Done) {
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationSizeApproval: string(gialv1beta1.SizeMedium)}
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and the namespace was that large before", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the istio revision is not installed", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "istio-sidecar-injector-1-8",
+					Labels: map[string]string{"istio.io/rev": "1-8"},
+				},
+			})).ToNot(HaveOccurred())
+			ns.Spec.IstioRevision = "1-9"
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace and lists the valid revisions", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("valid revisions: 1-8"))
+			close(done)
+		}, TestTimeout)
+
+		Context("and the revision is unchanged on update", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+	When("the istio revision is installed", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "istiod-1-8",
+					Namespace: "istio-system",
+					Labels:    map[string]string{"app": "istiod", "istio.io/rev": "1-8"},
+				},
+			})).ToNot(HaveOccurred())
+			ns.Spec.IstioRevision = "1-8"
+			close(done)
+		}, TestTimeout)
+		It("accepts the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+	})
+	When("the namespace claims hosts", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+				Spec:       gialv1beta1.LNamespaceSpec{Hosts: []string{"*.shop.loblaw.ca"}},
+			})).ToNot(HaveOccurred())
+			ns.Spec.Hosts = []string{"pharmacy.loblaw.ca"}
+			close(done)
+		}, TestTimeout)
+		It("accepts unclaimed hosts", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("that overlap the claim of another namespace", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Hosts = []string{"api.shop.loblaw.ca"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("claimed by shop"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that are wildcard domains below a self-service domain", func() {
+			BeforeEach(func(done Done) {
+				lnv.WildcardDomains = []string{"loblaw.ca"}
+				ns.Spec.Hosts = []string{"*.pharmacy.loblaw.ca"}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that are broad wildcard domains", func() {
+			BeforeEach(func(done Done) {
+				lnv.WildcardDomains = []string{"loblaw.ca"}
+				ns.Spec.Hosts = []string{"*.loblaw.ca"}
+				close(done)
+			}, TestTimeout)
+			It("rejects users who are not platform admins", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("only platform admins can claim wildcard domain *.loblaw.ca"))
+				close(done)
+			}, TestTimeout)
+
+			Context("claimed by a platform admin", func() {
+				BeforeEach(func(done Done) {
+					ns.Spec.Hosts = []string{"*.com"}
+					req.UserInfo.Groups = []string{platformAdmins}
+					close(done)
+				}, TestTimeout)
+				It("accepts the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeTrue())
+					close(done)
+				}, TestTimeout)
+			})
+		})
+
+		Context("that are malformed", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Hosts = []string{"*"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("a prod namespace is moved to another environment", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Environment = gialv1beta1.EnvironmentProd
+			raw, err := json.Marshal(ns)
+			Expect(err).ToNot(HaveOccurred())
+			req.Operation = admissionv1.Update
+			req.OldObject = runtime.RawExtension{Raw: raw}
+			ns.Spec.Environment = gialv1beta1.EnvironmentDev
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("a sudo session is opened", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Environment = gialv1beta1.EnvironmentProd
+			ns.Spec.Sudoers = []rbacv1.Subject{{Name: john, Kind: "User"}}
+			ns.Spec.SudoSessions = []gialv1beta1.SudoSession{
+				{Name: john, Expires: metav1.NewTime(time.Now().Add(time.Hour))},
+			}
+			close(done)
+		}, TestTimeout)
+		It("accepts the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("for longer than the maximum session", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.SudoSessions[0].Expires = metav1.NewTime(time.Now().Add(24 * time.Hour))
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("cannot last longer than 8h0m0s"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("for a sudoer of a team of the namespace", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(context.Background(), &gialv1beta1.LTeam{
+					ObjectMeta: metav1.ObjectMeta{Name: "pharmacy"},
+					Spec: gialv1beta1.LTeamSpec{
+						Managers: []rbacv1.Subject{{Name: john, Kind: "User"}},
+						Sudoers:  []rbacv1.Subject{{Name: alice, Kind: "User"}},
+					},
+				})).ToNot(HaveOccurred())
+				ns.Spec.Teams = []string{"pharmacy"}
+				ns.Spec.SudoSessions[0].Name = alice
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("for someone who is not a sudoer", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.SudoSessions[0].Name = "mallory@loblaw.ca"
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and an expired session is kept on update", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.SudoSessions[0].Expires = metav1.NewTime(time.Now().Add(-time.Hour))
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the namespace sets both expiresAt and ttl", func() {
+		BeforeEach(func(done Done) {
+			expiresAt := metav1.NewTime(time.Now().Add(time.Hour))
+			ns.Spec.ExpiresAt = &expiresAt
+			ns.Spec.TTL = &metav1.Duration{Duration: time.Hour}
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace matches a ttl policy", func() {
+		BeforeEach(func(done Done) {
+			ns.Name = "preview-shop"
+			ns.Spec.TTL = &metav1.Duration{Duration: 24 * time.Hour}
+			close(done)
+		}, TestTimeout)
+		It("accepts a ttl within the policy", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("without an expiry", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.TTL = nil
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("must set expiresAt or ttl"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("with a ttl longer than the policy", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.TTL = &metav1.Duration{Duration: 96 * time.Hour}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and a manager extends it within the policy", func() {
+			BeforeEach(func(done Done) {
+				ns.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				ns.Spec.TTL = &metav1.Duration{Duration: 96 * time.Hour}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("a namespace is deleted", func() {
+		BeforeEach(func(done Done) {
+			req.Operation = admissionv1.Delete
+			close(done)
+		}, TestTimeout)
+		JustBeforeEach(func(done Done) {
+			raw, err := json.Marshal(ns)
+			Expect(err).ToNot(HaveOccurred())
+			req.Object = runtime.RawExtension{}
+			req.OldObject = runtime.RawExtension{Raw: raw}
+			res = lnv.Handle(context.Background(), req)
+			close(done)
+		}, TestTimeout)
+
+		It("accepts the deletion of an unprotected namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("that is protected", func() {
+			BeforeEach(func(done Done) {
+				protected := true
+				ns.Spec.DeletionProtection = &protected
+				close(done)
+			}, TestTimeout)
+			It("rejects the deletion", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("protected from deletion"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("in prod", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Environment = gialv1beta1.EnvironmentProd
+				close(done)
+			}, TestTimeout)
+			It("rejects the deletion by default", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the deletion protection is removed", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Sudoers = []rbacv1.Subject{{Name: alice, Kind: rbacv1.UserKind}}
+			protected := true
+			ns.Spec.DeletionProtection = &protected
+			raw, err := json.Marshal(ns)
+			Expect(err).ToNot(HaveOccurred())
+			req.Operation = admissionv1.Update
+			req.OldObject = runtime.RawExtension{Raw: raw}
+			unprotected := false
+			ns.Spec.DeletionProtection = &unprotected
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace if the user is not a sudoer", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+
+		Context("by a sudoer", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Username = alice
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("by a sudoer inherited from the parent", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "team"},
+					Spec:       gialv1beta1.LNamespaceSpec{Sudoers: []rbacv1.Subject{{Name: john, Kind: rbacv1.UserKind}}},
+				})).ToNot(HaveOccurred())
+				old := &gialv1beta1.LNamespace{}
+				Expect(json.Unmarshal(req.OldObject.Raw, old)).ToNot(HaveOccurred())
+				old.Spec.Parent = "team"
+				raw, err := json.Marshal(old)
+				Expect(err).ToNot(HaveOccurred())
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				ns.Spec.Parent = "team"
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the namespace has a parent", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "team"},
+				Spec:       gialv1beta1.LNamespaceSpec{Managers: []rbacv1.Subject{{Name: john, Kind: rbacv1.UserKind}}},
+			})).ToNot(HaveOccurred())
+			ns.Spec.Parent = "team"
+			close(done)
+		}, TestTimeout)
+		It("accepts the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("that the requester does not administer", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "other-team"},
+					Spec: gialv1beta1.LNamespaceSpec{
+						Sudoers: []rbacv1.Subject{{Name: alice, Kind: rbacv1.UserKind}},
+						Billing: map[string]string{"team": "other"},
+					},
+				})).ToNot(HaveOccurred())
+				ns.Spec.Parent = "other-team"
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("only sudoers and managers of other-team"))
+				close(done)
+			}, TestTimeout)
+
+			Context("by a platform admin", func() {
+				BeforeEach(func(done Done) {
+					req.UserInfo.Groups = []string{platformAdmins}
+					close(done)
+				}, TestTimeout)
+				It("accepts the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeTrue())
+					close(done)
+				}, TestTimeout)
+			})
+
+			Context("by one of its sudoers", func() {
+				BeforeEach(func(done Done) {
+					req.UserInfo.Username = alice
+					close(done)
+				}, TestTimeout)
+				It("accepts the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeTrue())
+					close(done)
+				}, TestTimeout)
+			})
+		})
+
+		Context("that does not exist", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Parent = "missing"
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("does not exist"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that descends from the namespace", func() {
+			BeforeEach(func(done Done) {
+				Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "child"},
+					Spec:       gialv1beta1.LNamespaceSpec{Parent: DefaultName},
+				})).ToNot(HaveOccurred())
+				ns.Spec.Parent = "child"
+				close(done)
+			}, TestTimeout)
+			It("rejects the cycle", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring(DefaultName + " -> child -> " + DefaultName))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the namespace references a team", func() {
+		BeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), &gialv1beta1.LTeam{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+				Spec: gialv1beta1.LTeamSpec{
+					Developers: []rbacv1.Subject{{Name: "shop-devs", Kind: rbacv1.GroupKind}},
+					Billing:    map[string]string{"team": "shop"},
+				},
+			})).ToNot(HaveOccurred())
+			ns.Spec.Teams = []string{"shop"}
+			close(done)
+		}, TestTimeout)
+		It("rejects users outside of the team", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("only members of team shop"))
+			close(done)
+		}, TestTimeout)
+
+		Context("by a member of the team", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{"shop-devs"}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that it already referenced", func() {
+			BeforeEach(func(done Done) {
+				req.Operation = admissionv1.Update
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("the namespace references a team that does not exist", func() {
+		BeforeEach(func(done Done) {
+			ns.Spec.Teams = []string{"missing"}
+			close(done)
+		}, TestTimeout)
+		It("rejects the namespace", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("team missing does not exist"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	When("the namespace is placed in member clusters", func() {
+		BeforeEach(func(done Done) {
+			lnv.ClusterSecrets = k8sClient
+			lnv.ClusterSecretNamespace = "clusters"
+			Expect(k8sClient.Create(context.Background(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "east",
+					Namespace:   "clusters",
+					Labels:      map[string]string{gialv1beta1.LabelClusterSecret: "true"},
+					Annotations: map[string]string{gialv1beta1.AnnotationClusterAllowedGroups: "team-east, team-all"},
+				},
+			})).ToNot(HaveOccurred())
+			ns.Spec.Clusters = []string{"east"}
+			close(done)
+		}, TestTimeout)
+		It("rejects users outside of the allowed groups of the cluster", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			Expect(string(res.Result.Reason)).To(ContainSubstring("cluster east"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("Unauthorized")))
+			close(done)
+		}, TestTimeout)
+
+		Context("by a member of an allowed group", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{"team-all"}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("by a platform admin", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{platformAdmins}
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that were placed before", func() {
+			BeforeEach(func(done Done) {
+				raw, err := json.Marshal(ns)
+				Expect(err).ToNot(HaveOccurred())
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+				close(done)
+			}, TestTimeout)
+			It("accepts updates that keep the placement", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("that are not registered", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{"team-all"}
+				ns.Spec.Clusters = []string{"west"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("cluster west does not exist"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("through a cluster selector", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = []string{"team-all"}
+				ns.Spec.Clusters = nil
+				ns.Spec.ClusterSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"region": "east"}}
+				close(done)
+			}, TestTimeout)
+			It("rejects users who are not platform admins", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("only platform admins can set spec.clusterSelector"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("without the multi-cluster mode", func() {
+			BeforeEach(func(done Done) {
+				lnv.ClusterSecrets = nil
+				req.UserInfo.Groups = []string{"team-all"}
+				close(done)
+			}, TestTimeout)
+			It("rejects users who are not platform admins", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("ownership limits are set", func() {
+		BeforeEach(func(done Done) {
+			lnv.OwnershipLimits = webhooks.OwnershipLimits{User: 1, CostCenter: 1, CostCenterKey: "cost-center"}
+			Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "existing"},
+				Spec: gialv1beta1.LNamespaceSpec{
+					Managers: []rbacv1.Subject{{Kind: "User", Name: john}},
+					Billing:  map[string]string{"cost-center": "4021"},
+				},
+			})).ToNot(HaveOccurred())
+			ns.Spec.Sudoers = []rbacv1.Subject{{Kind: "User", Name: alice}}
+			close(done)
+		}, TestTimeout)
+		It("accepts owners under their limit", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("and an owner is at their limit", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Sudoers = []rbacv1.Subject{{Kind: "User", Name: john}}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("user " + john + " already owns 1 LNamespaces, the limit is 1"))
+				Expect(string(res.Result.Reason)).To(ContainSubstring(gialv1beta1.AnnotationOwnershipLimitApproval))
+				close(done)
+			}, TestTimeout)
+
+			Context("with approval from a platform admin", func() {
+				BeforeEach(func(done Done) {
+					ns.Annotations = map[string]string{gialv1beta1.AnnotationOwnershipLimitApproval: "true"}
+					req.UserInfo.Groups = []string{platformAdmins}
+					close(done)
+				}, TestTimeout)
+				It("accepts the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeTrue())
+					close(done)
+				}, TestTimeout)
+			})
+
+			Context("with approval set by someone who is not a platform admin", func() {
+				BeforeEach(func(done Done) {
+					ns.Annotations = map[string]string{gialv1beta1.AnnotationOwnershipLimitApproval: "true"}
+					close(done)
+				}, TestTimeout)
+				It("rejects the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeFalse())
+					Expect(recorder.Events).To(Receive(ContainSubstring("Unauthorized")))
+					close(done)
+				}, TestTimeout)
+			})
+		})
+
+		Context("and the cost center is at its limit", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Billing = map[string]string{"cost-center": "4021"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("cost center 4021 already owns 1 LNamespaces"))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("a naming policy is set", func() {
+		BeforeEach(func(done Done) {
+			lnv.NamingPolicy = naming.Policy{
+				Pattern:  "{team}-*",
+				Prefixes: map[string][]string{"pharmacy-": {"pharmacy-sre"}},
+			}
+			ns.Name = "pharmacy-refill"
+			ns.Spec.Billing = map[string]string{"team": "pharmacy"}
+			req.UserInfo.Groups = []string{"pharmacy-sre"}
+			close(done)
+		}, TestTimeout)
+		It("accepts names reserved for a group of the requester", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("and the name does not match the billing", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Billing = map[string]string{"team": "shop"}
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("does not match the naming pattern {team}-*"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and the requester is not a member of the reserving group", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = nil
+				close(done)
+			}, TestTimeout)
+			It("rejects the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("names starting with pharmacy- are reserved for members of pharmacy-sre"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and the namespace is created for an approved request", func() {
+			BeforeEach(func(done Done) {
+				req.UserInfo.Groups = nil
+				Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespaceRequest{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "requests", Name: "pharmacy"},
+					Spec: gialv1beta1.LNamespaceRequestSpec{
+						Name:     "pharmacy-refill",
+						Decision: gialv1beta1.DecisionApproved,
+					},
+				})).ToNot(HaveOccurred())
+				ns.Annotations = map[string]string{gialv1beta1.AnnotationRequest: "requests/pharmacy"}
+				req.UserInfo.Username = controller
+				close(done)
+			}, TestTimeout)
+			It("accepts the namespace", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+
+			Context("by someone other than the controller", func() {
+				BeforeEach(func(done Done) {
+					req.UserInfo.Username = john
+					close(done)
+				}, TestTimeout)
+				It("rejects the namespace", func(done Done) {
+					Expect(res.Allowed).To(BeFalse())
+					close(done)
+				}, TestTimeout)
+			})
+		})
+	})
+
+	When("the controller creates the namespace of a request", func() {
+		var lnsr *gialv1beta1.LNamespaceRequest
+		BeforeEach(func(done Done) {
+			lnv.ClusterSecrets = k8sClient
+			lnv.ClusterSecretNamespace = "clusters"
+			Expect(k8sClient.Create(context.Background(), &gialv1beta1.LNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-team"},
+				Spec:       gialv1beta1.LNamespaceSpec{Sudoers: []rbacv1.Subject{{Name: alice, Kind: rbacv1.UserKind}}},
+			})).ToNot(HaveOccurred())
+			ns.Annotations = map[string]string{gialv1beta1.AnnotationRequest: "requests/shop"}
+			ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{Enforce: gialv1beta1.PodSecurityPrivileged}
+			ns.Spec.Size = gialv1beta1.SizeLarge
+			ns.Spec.Parent = "other-team"
+			ns.Spec.ClusterSelector = &metav1.LabelSelector{}
+			req.UserInfo.Username = controller
+			lnsr = &gialv1beta1.LNamespaceRequest{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "requests", Name: "shop"},
+				Spec: gialv1beta1.LNamespaceRequestSpec{
+					Name:     DefaultName,
+					Template: ns.Spec,
+				},
+			}
+			close(done)
+		}, TestTimeout)
+		JustBeforeEach(func(done Done) {
+			Expect(k8sClient.Create(context.Background(), lnsr)).ToNot(HaveOccurred())
+			res = lnv.Handle(context.Background(), req)
+			close(done)
+		}, TestTimeout)
+
+		It("validates the namespace of an undecided request", func(done Done) {
+			Expect(res.Allowed).To(BeFalse())
+			close(done)
+		}, TestTimeout)
+
+		Context("that was approved", func() {
+			BeforeEach(func(done Done) {
+				lnsr.Spec.Decision = gialv1beta1.DecisionApproved
+				close(done)
+			}, TestTimeout)
+			It("accepts the approvals of the approver", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+
+	When("a soft deleted namespace is restored", func() {
+		var recorded *gialv1beta1.LNamespace
+		BeforeEach(func(done Done) {
+			ns.Annotations = map[string]string{gialv1beta1.AnnotationPodSecurityApproval: "privileged"}
+			ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{Enforce: gialv1beta1.PodSecurityPrivileged}
+			recorded = ns.DeepCopy()
+			lnv.RestoreRecords = k8sClient
+			lnv.RestoreNamespace = "namespace-controller-system"
+			close(done)
+		}, TestTimeout)
+		JustBeforeEach(func(done Done) {
+			record, err := restore.Record("namespace-controller-system", recorded)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k8sClient.Create(context.Background(), record)).ToNot(HaveOccurred())
+			res = lnv.Handle(context.Background(), req)
+			close(done)
+		}, TestTimeout)
+
+		It("accepts the recorded LNamespace with its approvals", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		Context("and the LNamespace differs from the recorded one", func() {
+			BeforeEach(func(done Done) {
+				recorded.Spec.PodSecurity.Enforce = gialv1beta1.PodSecurityBaseline
+				close(done)
+			}, TestTimeout)
+			It("validates it as a new namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+
+		Context("and the record is in another namespace", func() {
+			BeforeEach(func(done Done) {
+				lnv.RestoreNamespace = "other"
+				close(done)
+			}, TestTimeout)
+			It("validates it as a new namespace", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				close(done)
+			}, TestTimeout)
+		})
+	})
+})
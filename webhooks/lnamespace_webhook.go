@@ -21,8 +21,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -30,10 +32,20 @@ import (
 
 // +kubebuilder:webhook:path=/mutate-gial-lblw-dev-v1beta1-lnamespace,mutating=true,failurePolicy=fail,sideEffects=None,groups=gial.lblw.dev,resources=lnamespaces,verbs=create;update,versions=v1beta1,name=mlnamespace.kb.io,admissionReviewVersions={v1,v1beta1}
 
+// errRequesterEmpty is returned for requests that do not name the requesting user.
+var errRequesterEmpty = errors.New("requesting user cannot be empty")
+
 type LNamespaceDefaulter struct {
-	Client               client.Client
-	DefaultIstioRevision string
-	decoder              *admission.Decoder
+	Client                  client.Client
+	DefaultIstioRevision    string
+	DefaultPodSecurityLevel gialv1beta1.PodSecurityLevel
+	DefaultSize             gialv1beta1.NamespaceSize
+	// RestoreRecords reads the restore records of RestoreNamespace, whose
+	// LNamespaces are recreated as recorded.
+	RestoreRecords client.Reader
+	// RestoreNamespace is the namespace of the restore records.
+	RestoreNamespace string
+	decoder          *admission.Decoder
 }
 
 var _ admission.Handler = &LNamespaceDefaulter{}
@@ -47,7 +59,15 @@ func (lnd *LNamespaceDefaulter) Handle(ctx context.Context, req admission.Reques
 	}
 
 	if req.UserInfo.Username == "" {
-		return admission.Errored(http.StatusBadRequest, errors.New("requesting user cannot be empty"))
+		return admission.Errored(http.StatusBadRequest, errRequesterEmpty)
+	}
+	if req.Operation == admissionv1.Create {
+		// a restored LNamespace is recreated as it was recorded
+		if ok, err := restoring(ctx, lnd.RestoreRecords, lnd.RestoreNamespace, ns); err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		} else if ok {
+			return admission.Allowed("")
+		}
 	}
 	if ns.Spec.Sudoers == nil {
 		ns.Spec.Sudoers = []rbacv1.Subject{
@@ -57,9 +77,27 @@ func (lnd *LNamespaceDefaulter) Handle(ctx context.Context, req admission.Reques
 			},
 		}
 	}
-	if ns.Spec.IstioRevision == "" {
+	if ns.Spec.IstioRevision == "" && ns.Spec.MeshMode() == gialv1beta1.MeshSidecar {
 		ns.Spec.IstioRevision = lnd.DefaultIstioRevision
 	}
+	if ns.Spec.Size == "" {
+		ns.Spec.Size = lnd.DefaultSize
+	}
+	// Pod security levels are only defaulted on creation, so that existing
+	// namespaces without levels do not start enforcing the cluster default.
+	// Levels set through label overrides are migrated either way.
+	def := lnd.DefaultPodSecurityLevel
+	if req.Operation == admissionv1.Update {
+		def = ""
+	}
+	if ns.Spec.PodSecurity == nil && (def != "" || hasPodSecurityOverride(ns.Spec.NamespaceLabelOverrides)) {
+		ns.Spec.PodSecurity = &gialv1beta1.PodSecurity{}
+	}
+	if ns.Spec.PodSecurity != nil {
+		defaultPodSecurityLevel(&ns.Spec.PodSecurity.Enforce, ns.Spec.NamespaceLabelOverrides, "enforce", def)
+		defaultPodSecurityLevel(&ns.Spec.PodSecurity.Audit, ns.Spec.NamespaceLabelOverrides, "audit", def)
+		defaultPodSecurityLevel(&ns.Spec.PodSecurity.Warn, ns.Spec.NamespaceLabelOverrides, "warn", def)
+	}
 	marshalledNS, err := json.Marshal(ns)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
@@ -67,6 +105,33 @@ func (lnd *LNamespaceDefaulter) Handle(ctx context.Context, req admission.Reques
 	return admission.PatchResponseFromRaw(req.Object.Raw, marshalledNS)
 }
 
+// defaultPodSecurityLevel fills an unset level for the given Pod Security
+// Admission mode. Levels that were previously set through
+// namespaceLabelOverrides are moved into the level, otherwise the cluster
+// default is used.
+func defaultPodSecurityLevel(level *gialv1beta1.PodSecurityLevel, overrides map[string]string, mode string, def gialv1beta1.PodSecurityLevel) {
+	key := gialv1beta1.PodSecurityLabelPrefix + mode
+	if v, ok := overrides[key]; ok {
+		if *level == "" {
+			*level = gialv1beta1.PodSecurityLevel(v)
+		}
+		delete(overrides, key)
+	}
+	if *level == "" {
+		*level = def
+	}
+}
+
+// hasPodSecurityOverride returns true if overrides set a Pod Security Admission label.
+func hasPodSecurityOverride(overrides map[string]string) bool {
+	for k := range overrides {
+		if strings.HasPrefix(k, gialv1beta1.PodSecurityLabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
 func (lnd *LNamespaceDefaulter) InjectDecoder(d *admission.Decoder) error {
 	lnd.decoder = d
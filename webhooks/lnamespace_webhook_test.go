@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 
 	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/restore"
 	"github.com/loblaw-sre/namespace-controller/webhooks"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -27,8 +28,9 @@ var _ = Describe("webhook", func() {
 	BeforeEach(func(done Done) {
 		k8sClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
 		lnd = &webhooks.LNamespaceDefaulter{
-			Client:               k8sClient,
-			DefaultIstioRevision: "istio-version-1",
+			Client:                  k8sClient,
+			DefaultIstioRevision:    "istio-version-1",
+			DefaultPodSecurityLevel: gialv1beta1.PodSecurityRestricted,
 		}
 		lnd.InjectDecoder(decoder)
 		ns = &gialv1beta1.LNamespace{
@@ -40,11 +42,17 @@ var _ = Describe("webhook", func() {
 	}, TestTimeout)
 	When("the request is submitted", func() {
 		var res admission.Response
+		var operation admissionv1.Operation
 		BeforeEach(func(done Done) {
+			operation = admissionv1.Create
+			close(done)
+		}, TestTimeout)
+		JustBeforeEach(func(done Done) {
 			raw, err := json.Marshal(ns)
 			Expect(err).ToNot(HaveOccurred(), "Marshalling namespace definition should not have errored.")
 			req := admission.Request{
 				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: operation,
 					Object: runtime.RawExtension{
 						Raw: raw,
 					},
@@ -82,5 +90,105 @@ var _ = Describe("webhook", func() {
 			))
 			close(done)
 		}, TestTimeout)
+		It("defaults the pod security levels to the cluster default", func(done Done) {
+			Expect(res.Patches).To(ContainElement(
+				MatchFields(IgnoreExtras, Fields{
+					"Operation": Equal("add"),
+					"Path":      Equal("/spec/podSecurity"),
+					"Value": And(
+						HaveKeyWithValue("enforce", BeEquivalentTo("restricted")),
+						HaveKeyWithValue("audit", BeEquivalentTo("restricted")),
+						HaveKeyWithValue("warn", BeEquivalentTo("restricted")),
+					),
+				}),
+			))
+			close(done)
+		}, TestTimeout)
+		Context("with the namespace out of the mesh", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.Mesh = &gialv1beta1.Mesh{Mode: gialv1beta1.MeshNone}
+				close(done)
+			}, TestTimeout)
+			It("does not default the istio revision", func(done Done) {
+				Expect(res.Patches).ToNot(ContainElement(
+					MatchFields(IgnoreExtras, Fields{
+						"Path": Equal("/spec/istioRevision"),
+					}),
+				))
+				close(done)
+			}, TestTimeout)
+		})
+		Context("with pod security levels set through label overrides", func() {
+			BeforeEach(func(done Done) {
+				ns.Spec.NamespaceLabelOverrides = map[string]string{
+					"pod-security.kubernetes.io/enforce": "baseline",
+					"custom":                             "value",
+				}
+				close(done)
+			}, TestTimeout)
+			It("moves the level into the pod security field", func(done Done) {
+				Expect(res.Patches).To(ContainElement(
+					MatchFields(IgnoreExtras, Fields{
+						"Path":  Equal("/spec/podSecurity"),
+						"Value": HaveKeyWithValue("enforce", BeEquivalentTo("baseline")),
+					}),
+				))
+				Expect(res.Patches).To(ContainElement(
+					MatchFields(IgnoreExtras, Fields{
+						"Operation": Equal("remove"),
+						"Path":      Equal("/spec/namespaceLabelOverrides/pod-security.kubernetes.io~1enforce"),
+					}),
+				))
+				close(done)
+			}, TestTimeout)
+
+			Context("on update", func() {
+				BeforeEach(func(done Done) {
+					operation = admissionv1.Update
+					close(done)
+				}, TestTimeout)
+				It("moves the level without defaulting the others", func(done Done) {
+					Expect(res.Patches).To(ContainElement(
+						MatchFields(IgnoreExtras, Fields{
+							"Path": Equal("/spec/podSecurity"),
+							"Value": And(
+								HaveKeyWithValue("enforce", BeEquivalentTo("baseline")),
+								Not(HaveKey("audit")),
+								Not(HaveKey("warn")),
+							),
+						}),
+					))
+					close(done)
+				}, TestTimeout)
+			})
+		})
+		Context("with a restored namespace", func() {
+			BeforeEach(func(done Done) {
+				lnd.RestoreRecords = k8sClient
+				lnd.RestoreNamespace = "namespace-controller-system"
+				record, err := restore.Record("namespace-controller-system", ns)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(k8sClient.Create(context.Background(), record)).ToNot(HaveOccurred())
+				close(done)
+			}, TestTimeout)
+			It("recreates it as recorded", func(done Done) {
+				Expect(res.Patches).To(BeEmpty())
+				close(done)
+			}, TestTimeout)
+		})
+		Context("with an existing namespace without pod security levels", func() {
+			BeforeEach(func(done Done) {
+				operation = admissionv1.Update
+				close(done)
+			}, TestTimeout)
+			It("does not default the pod security levels", func(done Done) {
+				Expect(res.Patches).ToNot(ContainElement(
+					MatchFields(IgnoreExtras, Fields{
+						"Path": Equal("/spec/podSecurity"),
+					}),
+				))
+				close(done)
+			}, TestTimeout)
+		})
 	})
 })
@@ -0,0 +1,92 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-gial-lblw-dev-v1beta1-lnamespacerequest,mutating=false,failurePolicy=fail,sideEffects=None,groups=gial.lblw.dev,resources=lnamespacerequests,verbs=create;update,versions=v1beta1,name=vlnamespacerequest.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// LNamespaceRequestValidator only lets approvers decide LNamespaceRequests,
+// keeps decided requests from changing, and rejects requests for names that
+// violate the naming policy.
+type LNamespaceRequestValidator struct {
+	Client client.Client
+	// ApproverGroups are the groups allowed to approve or deny requests.
+	ApproverGroups []string
+	// PlatformAdminGroups may request names with any reserved prefix.
+	PlatformAdminGroups []string
+	// NamingPolicy constrains the names of the requested LNamespaces.
+	NamingPolicy naming.Policy
+	decoder      *admission.Decoder
+}
+
+var _ admission.Handler = &LNamespaceRequestValidator{}
+
+// Handle implements admission.Handler
+func (lrv *LNamespaceRequestValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	lnsr := &gialv1beta1.LNamespaceRequest{}
+	if err := lrv.decoder.Decode(req, lnsr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	old := &gialv1beta1.LNamespaceRequest{}
+	if req.Operation == admissionv1.Update {
+		if err := lrv.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if old.Spec.Decision != "" {
+		if !equality.Semantic.DeepEqual(lnsr.Spec, old.Spec) {
+			return admission.Denied(fmt.Sprintf("LNamespaceRequest %s was already %s and cannot change, create a new request instead", lnsr.Name, strings.ToLower(string(old.Spec.Decision))))
+		}
+		return admission.Allowed("")
+	}
+	// undecided requests can still be edited, and the LNamespace created for
+	// an approved request is not checked against the naming policy again
+	if req.Operation == admissionv1.Create || lnsr.Spec.Name != old.Spec.Name || !equality.Semantic.DeepEqual(lnsr.Spec.Template, old.Spec.Template) {
+		ns := &gialv1beta1.LNamespace{
+			ObjectMeta: metav1.ObjectMeta{Name: lnsr.Spec.Name},
+			Spec:       lnsr.Spec.Template,
+		}
+		if r := validateNaming(ctx, lrv.Client, lrv.NamingPolicy, req.UserInfo, lrv.PlatformAdminGroups, ns); r != nil {
+			return admission.Denied(r.message)
+		}
+	}
+	decided := lnsr.Spec.Decision != "" || lnsr.Spec.DecisionReason != old.Spec.DecisionReason
+	if decided && !isMember(req.UserInfo, lrv.ApproverGroups) {
+		return admission.Denied("only approvers can approve or deny LNamespaceRequests")
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
+func (lrv *LNamespaceRequestValidator) InjectDecoder(d *admission.Decoder) error {
+	lrv.decoder = d
+	return nil
+}
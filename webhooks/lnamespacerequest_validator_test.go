@@ -0,0 +1,153 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	"github.com/loblaw-sre/namespace-controller/pkg/naming"
+	"github.com/loblaw-sre/namespace-controller/webhooks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("LNamespaceRequest webhooks", func() {
+	const approvers = "platform-approvers"
+	var lnsr *gialv1beta1.LNamespaceRequest
+	var req admission.Request
+	BeforeEach(func(done Done) {
+		lnsr = &gialv1beta1.LNamespaceRequest{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "requests", Name: "pharmacy"},
+			Spec:       gialv1beta1.LNamespaceRequestSpec{Name: DefaultName},
+		}
+		req = admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				UserInfo: authenticationv1.UserInfo{
+					Username: john,
+				},
+			},
+		}
+		close(done)
+	}, TestTimeout)
+
+	Describe("the defaulter", func() {
+		It("makes the requester the sudoer of the requested namespace", func(done Done) {
+			lrd := &webhooks.LNamespaceRequestDefaulter{}
+			lrd.InjectDecoder(decoder)
+			raw, err := json.Marshal(lnsr)
+			Expect(err).ToNot(HaveOccurred())
+			req.Object = runtime.RawExtension{Raw: raw}
+			res := lrd.Handle(context.Background(), req)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Patches).To(HaveLen(1))
+			Expect(res.Patches[0].Path).To(Equal("/spec/template/sudoers"))
+			close(done)
+		}, TestTimeout)
+	})
+
+	Describe("the validator", func() {
+		var old *gialv1beta1.LNamespaceRequest
+		var res admission.Response
+		BeforeEach(func(done Done) {
+			old = nil
+			close(done)
+		}, TestTimeout)
+		JustBeforeEach(func(done Done) {
+			lrv := &webhooks.LNamespaceRequestValidator{
+				Client:         fake.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+				ApproverGroups: []string{approvers},
+				NamingPolicy:   naming.Policy{Prefixes: map[string][]string{"pharmacy-": {"pharmacy-sre"}}},
+			}
+			lrv.InjectDecoder(decoder)
+			raw, err := json.Marshal(lnsr)
+			Expect(err).ToNot(HaveOccurred())
+			req.Object = runtime.RawExtension{Raw: raw}
+			if old != nil {
+				req.Operation = admissionv1.Update
+				raw, err := json.Marshal(old)
+				Expect(err).ToNot(HaveOccurred())
+				req.OldObject = runtime.RawExtension{Raw: raw}
+			}
+			res = lrv.Handle(context.Background(), req)
+			close(done)
+		}, TestTimeout)
+
+		It("accepts undecided requests", func(done Done) {
+			Expect(res.Allowed).To(BeTrue())
+			close(done)
+		}, TestTimeout)
+
+		When("the requested name is reserved for another group", func() {
+			BeforeEach(func(done Done) {
+				lnsr.Spec.Name = "pharmacy-refill"
+				close(done)
+			}, TestTimeout)
+			It("rejects the request", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("reserved for members of pharmacy-sre"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		When("an undecided request is renamed to a name reserved for another group", func() {
+			BeforeEach(func(done Done) {
+				old = lnsr.DeepCopy()
+				lnsr.Spec.Name = "pharmacy-refill"
+				close(done)
+			}, TestTimeout)
+			It("rejects the change", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("reserved for members of pharmacy-sre"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		When("a requester approves their own request", func() {
+			BeforeEach(func(done Done) {
+				lnsr.Spec.Decision = gialv1beta1.DecisionApproved
+				close(done)
+			}, TestTimeout)
+			It("rejects the decision", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("only approvers"))
+				close(done)
+			}, TestTimeout)
+		})
+
+		When("an approver approves a request", func() {
+			BeforeEach(func(done Done) {
+				old = lnsr.DeepCopy()
+				lnsr.Spec.Decision = gialv1beta1.DecisionApproved
+				req.UserInfo = authenticationv1.UserInfo{Username: alice, Groups: []string{approvers}}
+				close(done)
+			}, TestTimeout)
+			It("accepts the decision", func(done Done) {
+				Expect(res.Allowed).To(BeTrue())
+				close(done)
+			}, TestTimeout)
+		})
+
+		When("a decided request changes", func() {
+			BeforeEach(func(done Done) {
+				lnsr.Spec.Decision = gialv1beta1.DecisionDenied
+				old = lnsr.DeepCopy()
+				lnsr.Spec.Decision = gialv1beta1.DecisionApproved
+				req.UserInfo = authenticationv1.UserInfo{Username: alice, Groups: []string{approvers}}
+				close(done)
+			}, TestTimeout)
+			It("rejects the change", func(done Done) {
+				Expect(res.Allowed).To(BeFalse())
+				Expect(string(res.Result.Reason)).To(ContainSubstring("was already denied"))
+				close(done)
+			}, TestTimeout)
+		})
+	})
+})
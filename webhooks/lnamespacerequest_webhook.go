@@ -0,0 +1,68 @@
+/*
+Copyright 2020.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	gialv1beta1 "github.com/loblaw-sre/namespace-controller/api/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/mutate-gial-lblw-dev-v1beta1-lnamespacerequest,mutating=true,failurePolicy=fail,sideEffects=None,groups=gial.lblw.dev,resources=lnamespacerequests,verbs=create,versions=v1beta1,name=mlnamespacerequest.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// LNamespaceRequestDefaulter makes the requester the sudoer of the requested
+// LNamespace, if the request does not name one. The LNamespace is created by
+// the controller, so its own defaulter cannot tell who asked for it.
+type LNamespaceRequestDefaulter struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.Handler = &LNamespaceRequestDefaulter{}
+
+// Handle implements admission.Handler
+func (lrd *LNamespaceRequestDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	lnsr := &gialv1beta1.LNamespaceRequest{}
+	if err := lrd.decoder.Decode(req, lnsr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.UserInfo.Username == "" {
+		return admission.Errored(http.StatusBadRequest, errRequesterEmpty)
+	}
+	if lnsr.Spec.Template.Sudoers == nil {
+		lnsr.Spec.Template.Sudoers = []rbacv1.Subject{
+			{
+				Name: req.UserInfo.Username,
+				Kind: "User",
+			},
+		}
+	}
+	marshalled, err := json.Marshal(lnsr)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// InjectDecoder implements "sigs.k8s.io/controller-runtime/pkg/webhook/admission".DecoderInjector
+func (lrd *LNamespaceRequestDefaulter) InjectDecoder(d *admission.Decoder) error {
+	lrd.decoder = d
+	return nil
+}
@@ -43,8 +43,11 @@ const (
 	TestTimeout       = 10
 	EventuallyTimeout = 8 //Eventually calls should time out before the end of the test
 
-	DefaultName = "test-ns"
-	john        = "john@loblaw.ca"
+	DefaultName    = "test-ns"
+	john           = "john@loblaw.ca"
+	alice          = "alice@loblaw.ca"
+	platformAdmins = "platform-admins"
+	controller     = "system:serviceaccount:namespace-controller-system:namespace-controller-manager"
 )
 
 func TestAPIs(t *testing.T) {